@@ -4,121 +4,450 @@ import (
 	"flag"
 	"fmt"
 	"kodb-util/config"
+	"kodb-util/logging"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
+const (
+	appName = "kodb-util"
+
+	defaultBatchSize = 16
+
+	// DefaultServeAddr is the address used by the serve command when none is given
+	DefaultServeAddr = "localhost:8080"
+)
+
+// snapshotNameRegex matches the names accepted for database snapshots; the name becomes part of a database name
+var snapshotNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ErrHelp is returned by GetArgs when help was requested and has been printed
+var ErrHelp = flag.ErrHelp
+
 // Args defines and handles the CLI input flags/arguments
 type Args struct {
+	Command               string // subcommand that was run, e.g. "export"; empty when only legacy flags were used
 	Clean                 bool
 	Import                bool
 	ImportBatchSize       int
+	ImportViews           bool     // replace the views in an existing database
+	ImportProcs           bool     // replace the stored procedures in an existing database
+	ImportData            bool     // replace the table data in an existing database
+	ImportTables          []string // tables whose data ImportData replaces; empty for all
 	ExportAll             bool
 	ExportData            bool
 	ExportStructure       bool
 	ExportProcs           bool
 	ExportViews           bool
 	ExportJsonSchema      bool
+	ExportSecurity        bool
 	ConfigPath            string
+	Profile               string
 	DbUser                string
 	DbPass                string
 	SchemaDir             string
 	CreateManualArtifacts bool
+	LintSchema            bool
+	VerifyModels          bool
+	RoundTrip             bool
+	CheckConfig           bool
+	Init                  bool          // interactively write the config file, then exit
+	Preflight             bool          // check the server, login, and OpenKO-db before running the requested jobs
+	Timeout               time.Duration // limit for the whole run; 0 for none
+	PhaseTimeout          time.Duration // limit for each clean/import/verify/export phase; 0 for none
+	LogLevel              string
+	LogFormat             string
+	LogFile               string
+	TraceSql              bool
+	SlowSql               time.Duration
+	Report                string // path of the JSON run report; empty for none
+	Serve                 string // address to serve the HTTP API on; empty to run the requested jobs and exit
+	Watch                 bool   // re-run changed view and stored procedure scripts until cancelled
+	Restore               string // backup file (or "latest") to restore the configured databases from; empty for none
+	NoBackup              bool   // skip the backup that clean takes of databases that have data
+	DropOrphanLogins      bool   // have clean drop the unconfigured logins that map to the dropped database
+	Snapshot              string // name of the database snapshot to create; empty for none
+	Revert                string // name of the database snapshot to revert to; empty for none
+	DropSnapshot          string // name of the database snapshot to drop; empty for none
+	ListSnapshots         bool
+	Yes                   bool // confirm destructive jobs without prompting
+	Force                 bool // drop or replace databases that hold more player data than safety.maxGuardedRows
+
+	// Deprecated lists a notice for each legacy flag that was used, to be logged once logging is set up
+	Deprecated []string
 }
 
-// Validate ensures that the combination of arguments used is valid
+// Validate ensures that the combination of arguments used is valid.  Subcommands only ever set one job, so the
+// cross-checks below only apply to the legacy flags.
 func (this Args) Validate() (err error) {
-	if !(this.Clean || this.Import || this.HasExportJob()) {
-		flag.Usage()
+	if this.Init {
+		if this.HasDbJob() || this.LintSchema || this.CheckConfig || this.Preflight || this.Serve != "" {
+			return fmt.Errorf("-init cannot be combined with other actions")
+		}
+		return nil
+	}
+	if this.Serve != "" {
+		if this.HasDbJob() || this.LintSchema || this.CheckConfig {
+			return fmt.Errorf("-serve cannot be combined with other actions; jobs are started through the HTTP API")
+		}
+		return validateServeAddr(this.Serve)
+	}
+	if !(this.HasDbJob() || this.LintSchema || this.CheckConfig || this.Preflight) {
 		return fmt.Errorf("no actionable arguments provided")
 	}
 	if this.Clean && this.HasExportJob() {
 		return fmt.Errorf("cannot perform both clean and export actions")
 	}
-	if this.Import && this.HasExportJob() {
-		// maybe to test that nothing changes, but for general use would be an expensive no-op
-		// could do a separate arg/job for diff checking
+	if this.Clean && this.VerifyModels {
+		return fmt.Errorf("cannot perform both clean and verify actions")
+	}
+	if this.HasPartialImportJob() && (this.Clean || this.Import) {
+		return fmt.Errorf("-importViews, -importProcs, and -importData replace objects in an existing database; they cannot be combined with clean or import")
+	}
+	if len(this.ImportTables) > 0 && !this.ImportData {
+		return fmt.Errorf("-tables requires import data (or -importData)")
+	}
+	if this.ExportSecurity && (this.ExportStructure || this.ExportAll) {
+		// both write the 2_, 3_, and 4_ scripts; structure would replace the ones read from the server
+		return fmt.Errorf("export security cannot be combined with export structure or all")
+	}
+	if (this.Import || this.HasPartialImportJob()) && this.HasExportJob() {
+		// use -roundTrip to test that nothing changes
 		return fmt.Errorf("running import and export together is redundant")
 	}
+	if this.Timeout < 0 || this.PhaseTimeout < 0 {
+		return fmt.Errorf("timeouts cannot be negative")
+	}
+	if this.RoundTrip && (this.Clean || this.Import || this.VerifyModels || this.HasExportJob()) {
+		return fmt.Errorf("-roundTrip cannot be combined with other database actions")
+	}
+	if this.Watch && (this.Clean || this.Import || this.HasPartialImportJob() || this.VerifyModels || this.RoundTrip || this.HasExportJob() || this.LintSchema || this.CheckConfig) {
+		return fmt.Errorf("-watch cannot be combined with other actions")
+	}
+	if this.HasSnapshotJob() {
+		if this.Clean || this.Import || this.HasPartialImportJob() || this.VerifyModels || this.RoundTrip || this.Watch || this.Restore != "" || this.HasExportJob() || this.LintSchema || this.CheckConfig {
+			return fmt.Errorf("snapshot actions cannot be combined with other actions")
+		}
+		if (this.Snapshot != "" && this.Revert != "") || (this.Snapshot != "" && this.DropSnapshot != "") || (this.Revert != "" && this.DropSnapshot != "") {
+			return fmt.Errorf("-snapshot, -revert, and -dropSnapshot cannot be combined")
+		}
+		for _, name := range []string{this.Snapshot, this.Revert, this.DropSnapshot} {
+			if name != "" {
+				if err = ValidateSnapshotName(name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if this.Restore != "" && (this.Clean || this.Import || this.HasPartialImportJob() || this.VerifyModels || this.RoundTrip || this.Watch || this.HasExportJob() || this.LintSchema || this.CheckConfig) {
+		return fmt.Errorf("-restore cannot be combined with other actions")
+	}
 
 	return nil
 }
 
-func (this Args) HasExportJob() bool {
-	if this.ExportAll || this.ExportJsonSchema || this.ExportData || this.ExportStructure || this.ExportProcs || this.ExportViews {
-		return true
-	}
-	return false
+// HasDbJob returns true if any of the requested jobs require a database connection
+func (this Args) HasDbJob() bool {
+	return this.Clean || this.Import || this.HasPartialImportJob() || this.VerifyModels || this.RoundTrip || this.Watch || this.Restore != "" || this.HasSnapshotJob() || this.HasExportJob()
 }
 
-// GetArgs reads the CLI arguments using the go flag package
-func GetArgs() (a Args) {
-	_clean := flag.Bool("clean", false, "Clean drops any configured users and drops the databaseConfig.dbname database")
-	_import := flag.Bool("import", false, "Runs clean and imports the contents of OpenKO-db/ManaualSetup, StoredProcedures, and Views")
-	importBatchSize := flag.Int("batchSize", 16, "Batch sized used when importing table data.  Valid range [2-999], if invalid value specified will default to 16")
-	exportAll := flag.Bool("exportAll", false, "Export both the data and structure of the database")
-	exportData := flag.Bool("exportData", false, "Export table data from the database")
-	exportStructure := flag.Bool("exportStructure", false, "Export the structural elements of the database")
-	exportProcs := flag.Bool("exportProcs", false, "Export the stored procedures of the database")
-	exportViews := flag.Bool("exportViews", false, "Export the views of the database")
-	exportJsonSchema := flag.Bool("exportJsonSchema", false, "Export table properties from the database to update jsonSchema.  Not part of -exportAll")
-	configPath := flag.String("config", config.DefaultConfigFileName, "Path to config file, inclusive of the filename")
-	dbUser := flag.String("dbuser", "", "Database connection user override")
-	dbPass := flag.String("dbpass", "", "Database connection password override")
-	schemaDir := flag.String("schema", "", "OpenKO-db schema directory override; in most cases you'll just want to use the default git submodule location")
+// ManagesDatabases returns true if any of the requested jobs create or drop databases or logins, which preflight
+// then requires permission for
+func (this Args) ManagesDatabases() bool {
+	return this.Clean || this.Import || this.RoundTrip || this.Restore != "" || this.Snapshot != "" || this.Revert != "" || this.DropSnapshot != ""
+}
 
-	flag.Parse()
+// HasPartialImportJob returns true if views, procs, or data are to be imported into an existing database
+func (this Args) HasPartialImportJob() bool {
+	return this.ImportViews || this.ImportProcs || this.ImportData
+}
+
+// HasSnapshotJob returns true if database snapshots are to be created, reverted to, dropped, or listed
+func (this Args) HasSnapshotJob() bool {
+	return this.Snapshot != "" || this.Revert != "" || this.DropSnapshot != "" || this.ListSnapshots
+}
 
-	if _clean != nil {
-		a.Clean = *_clean
+// DestructiveJobs returns the names of the requested jobs that drop or replace data, which need to be confirmed
+func (this Args) DestructiveJobs() (jobs []string) {
+	if this.Clean && !this.Import {
+		jobs = append(jobs, "clean")
+	}
+	if this.Import {
+		jobs = append(jobs, "clean and import")
 	}
+	if this.ImportData {
+		jobs = append(jobs, "replace table data")
+	}
+	if this.Restore != "" {
+		jobs = append(jobs, "restore "+this.Restore)
+	}
+	if this.Revert != "" {
+		jobs = append(jobs, "revert to snapshot "+this.Revert)
+	}
+	if this.DropSnapshot != "" {
+		jobs = append(jobs, "drop snapshot "+this.DropSnapshot)
+	}
+	return jobs
+}
 
-	if _import != nil {
-		a.Import = *_import
+// ForbidReason returns the isForbid* flag of db that doesn't allow the jobs in this, or "" if they're allowed.  These
+// are the same checks processDb makes before each phase.
+func (this Args) ForbidReason(db config.GenDbConfig) string {
+	if (this.Clean || this.Import || this.Restore != "" || this.Snapshot != "" || this.Revert != "" || this.DropSnapshot != "") && db.IsForbidClean {
+		return "isForbidClean"
 	}
+	if (this.Import || this.HasPartialImportJob() || this.Restore != "" || this.Watch) && db.IsForbidImport {
+		return "isForbidImport"
+	}
+	if this.HasExportJob() && db.IsForbidExport {
+		return "isForbidExport"
+	}
+	return ""
+}
 
-	if exportJsonSchema != nil {
-		a.ExportJsonSchema = *exportJsonSchema
+func (this Args) HasExportJob() bool {
+	if this.ExportAll || this.ExportJsonSchema || this.ExportData || this.ExportStructure || this.ExportProcs || this.ExportViews || this.ExportSecurity {
+		return true
 	}
+	return false
+}
+
+// GetArgs reads the CLI arguments: global flags, then an optional subcommand with its own flags and arguments.
+// Returns ErrHelp if help was requested.
+func GetArgs() (a Args, err error) {
+	return ParseArgs(os.Args[1:])
+}
 
-	if exportAll != nil {
-		a.ExportAll = *exportAll
+// ParseArgs parses argv (without the program name) the same way GetArgs does
+func ParseArgs(argv []string) (a Args, err error) {
+	root := flag.NewFlagSet(appName, flag.ContinueOnError)
+	addGlobalFlags(root, &a)
+	legacy := addLegacyFlags(root, &a)
+	root.BoolVar(&a.ImportViews, "importViews", false, "Replace the views in the existing database without cleaning it; see import views")
+	root.BoolVar(&a.ImportProcs, "importProcs", false, "Replace the stored procedures in the existing database without cleaning it; see import procs")
+	root.BoolVar(&a.ImportData, "importData", false, "Replace the table data in the existing database without cleaning it; see import data")
+	addTablesFlag(root, &a)
+	root.BoolVar(&a.Watch, "watch", false, "Watch OpenKO-db/ManualSetup and re-run changed view and stored procedure scripts until cancelled; see the watch command")
+	root.StringVar(&a.Restore, "restore", "", "Restore the configured databases from this backup file, or latest, and re-create their logins and users; see the restore command")
+	addNoBackupFlag(root, &a)
+	addDropOrphanLoginsFlag(root, &a)
+	root.StringVar(&a.Snapshot, "snapshot", "", "Create a database snapshot with this name of each configured database; see the snapshot command")
+	root.StringVar(&a.Revert, "revert", "", "Revert each configured database to its database snapshot with this name; see the snapshot command")
+	root.StringVar(&a.DropSnapshot, "dropSnapshot", "", "Drop the database snapshot with this name of each configured database; see the snapshot command")
+	root.BoolVar(&a.ListSnapshots, "listSnapshots", false, "List the database snapshots of each configured database; see the snapshot command")
+	root.BoolVar(&a.Init, "init", false, "Ask for the connection settings, test them, and write the config file; see config init")
+	root.StringVar(&a.Serve, "serve", "", "Serve the jobs as an HTTP API on this localhost address, e.g. :8080, instead of running a command; see the serve command")
+	root.Usage = func() {
+		printRootUsage(root)
 	}
 
-	if exportData != nil {
-		a.ExportData = *exportData
+	err = root.Parse(argv)
+	if err != nil {
+		return a, err
 	}
 
-	if exportStructure != nil {
-		a.ExportStructure = *exportStructure
+	// legacy flags are aliases for the subcommands
+	root.Visit(func(f *flag.Flag) {
+		if replacement, ok := legacy[f.Name]; ok {
+			a.Deprecated = append(a.Deprecated, fmt.Sprintf("-%s is deprecated and will be removed in a future release; use: %s %s", f.Name, appName, replacement))
+		}
+	})
+
+	if root.NArg() > 0 {
+		if root.Arg(0) == "help" {
+			if root.NArg() > 1 {
+				if cmd := findCommand(root.Arg(1)); cmd != nil {
+					cmd.newFlagSet(&a).Usage()
+					return a, ErrHelp
+				}
+			}
+			root.Usage()
+			return a, ErrHelp
+		}
+
+		cmd := findCommand(root.Arg(0))
+		if cmd == nil {
+			root.Usage()
+			return a, fmt.Errorf("unknown command %s", root.Arg(0))
+		}
+		if a.HasDbJob() || a.LintSchema || a.CheckConfig || a.Init || a.Serve != "" {
+			return a, fmt.Errorf("the %s command cannot be combined with legacy job flags or -serve", cmd.Name)
+		}
+
+		fs := cmd.newFlagSet(&a)
+		positional, err := parseInterleaved(fs, root.Args()[1:])
+		if err != nil {
+			return a, err
+		}
+		a.Command = cmd.Name
+		err = cmd.Apply(&a, positional)
+		if err != nil {
+			fs.Usage()
+			return a, err
+		}
+	} else if !(a.HasDbJob() || a.LintSchema || a.CheckConfig || a.Init || a.Preflight || a.Serve != "") {
+		root.Usage()
+		return a, fmt.Errorf("no command provided")
 	}
 
-	if exportProcs != nil {
-		a.ExportProcs = *exportProcs
+	return a, nil
+}
+
+// ValidateSnapshotName returns an error if name can't be used as a database snapshot name
+func ValidateSnapshotName(name string) error {
+	if !snapshotNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %s; use letters, digits, and underscores", name)
 	}
+	return nil
+}
 
-	if exportViews != nil {
-		a.ExportViews = *exportViews
+// validateServeAddr ensures that addr is a host:port on the loopback interface; the API has no authentication, so
+// it's never exposed to the network.  An empty host, e.g. ":8080", means localhost.
+func validateServeAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -serve address %s: %v", addr, err)
+	}
+	if host == "" || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
 	}
+	return fmt.Errorf("-serve address %s must be on localhost", addr)
+}
 
-	if configPath != nil {
-		a.ConfigPath = *configPath
-		config.ConfigPath = *configPath
+// addGlobalFlags registers the flags shared by every command.  The current values in a are used as defaults so that
+// global flags given before the command name carry over to the command's flag set.
+func addGlobalFlags(fs *flag.FlagSet, a *Args) {
+	if a.ConfigPath == "" {
+		a.ConfigPath = config.DefaultConfigFileName
+	}
+	if a.LogLevel == "" {
+		a.LogLevel = "info"
+	}
+	if a.LogFormat == "" {
+		a.LogFormat = logging.FormatText
+	}
+	if a.SlowSql == 0 {
+		a.SlowSql = logging.DefaultSlowSqlThreshold
 	}
 
-	if dbUser != nil {
-		a.DbUser = *dbUser
+	fs.StringVar(&a.ConfigPath, "config", a.ConfigPath, "Path to config file, inclusive of the filename")
+	fs.StringVar(&a.Profile, "profile", a.Profile, "Name of the profiles entry in the config file to apply over the base configuration, e.g. dev or staging.  Defaults to the KODB_PROFILE environment variable")
+	fs.StringVar(&a.DbUser, "dbuser", a.DbUser, "Database connection user override")
+	fs.StringVar(&a.DbPass, "dbpass", a.DbPass, "Database connection password override.  Use -dbpass=- to be prompted for the password")
+	fs.StringVar(&a.SchemaDir, "schema", a.SchemaDir, "OpenKO-db schema directory override; in most cases you'll just want to use the default git submodule location")
+	fs.DurationVar(&a.Timeout, "timeout", a.Timeout, "Cancel the run and rollback the open transaction if it takes longer than this, e.g. 30m.  0 disables the limit")
+	fs.DurationVar(&a.PhaseTimeout, "phaseTimeout", a.PhaseTimeout, "Cancel the run and rollback the open transaction if any single clean, import, verify, or export phase takes longer than this, e.g. 10m.  0 disables the limit")
+	fs.StringVar(&a.LogLevel, "logLevel", a.LogLevel, "Minimum level of log messages to output: debug, info, warn, or error")
+	fs.StringVar(&a.LogFormat, "logFormat", a.LogFormat, "Log output format: text or json")
+	fs.StringVar(&a.LogFile, "logFile", a.LogFile, "Also append log output to this file")
+	fs.BoolVar(&a.TraceSql, "traceSql", a.TraceSql, "Log every SQL statement with its duration and row count")
+	fs.DurationVar(&a.SlowSql, "slowSql", a.SlowSql, "With -traceSql, statements that take longer than this are logged as warnings")
+	fs.BoolVar(&a.Yes, "yes", a.Yes, "Run destructive jobs (clean, import, import data, restore, revert, dropping snapshots) without asking for confirmation")
+	fs.BoolVar(&a.Force, "force", a.Force, "Drop or replace databases that hold more rows in the safety.guardedTables than safety.maxGuardedRows")
+	fs.BoolVar(&a.Preflight, "preflight", a.Preflight, "Before any job, check the server version and edition, the login's permission to create databases and logins, and the OpenKO-db directory")
+	fs.StringVar(&a.Report, "report", a.Report, "Write a JSON report of the databases processed, phases run, files read and written, row counts, durations, skipped phases, warnings, and the final error to this file")
+}
+
+// addLegacyFlags registers the pre-subcommand job flags on the root flag set and returns the command that replaces each
+func addLegacyFlags(fs *flag.FlagSet, a *Args) (replacements map[string]string) {
+	a.ImportBatchSize = defaultBatchSize
+	fs.BoolVar(&a.Clean, "clean", false, "Deprecated: use the clean command")
+	fs.BoolVar(&a.Import, "import", false, "Deprecated: use the import command")
+	fs.IntVar(&a.ImportBatchSize, "batchSize", defaultBatchSize, "Deprecated: use import -batchSize")
+	fs.BoolVar(&a.ExportAll, "exportAll", false, "Deprecated: use export all")
+	fs.BoolVar(&a.ExportData, "exportData", false, "Deprecated: use export data")
+	fs.BoolVar(&a.ExportStructure, "exportStructure", false, "Deprecated: use export structure")
+	fs.BoolVar(&a.ExportProcs, "exportProcs", false, "Deprecated: use export procs")
+	fs.BoolVar(&a.ExportViews, "exportViews", false, "Deprecated: use export views")
+	fs.BoolVar(&a.ExportJsonSchema, "exportJsonSchema", false, "Deprecated: use export jsonschema")
+	fs.BoolVar(&a.LintSchema, "lintSchema", false, "Deprecated: use the lint command")
+	fs.BoolVar(&a.VerifyModels, "verifyModels", false, "Deprecated: use diff models")
+	fs.BoolVar(&a.RoundTrip, "roundTrip", false, "Deprecated: use diff roundtrip")
+	fs.BoolVar(&a.CheckConfig, "checkConfig", false, "Deprecated: use config check")
+
+	return map[string]string{
+		"clean":            "clean",
+		"import":           "import",
+		"batchSize":        "import -batchSize",
+		"exportAll":        "export all",
+		"exportData":       "export data",
+		"exportStructure":  "export structure",
+		"exportProcs":      "export procs",
+		"exportViews":      "export views",
+		"exportJsonSchema": "export jsonschema",
+		"lintSchema":       "lint",
+		"verifyModels":     "diff models",
+		"roundTrip":        "diff roundtrip",
+		"checkConfig":      "config check",
 	}
+}
 
-	if dbPass != nil {
-		a.DbPass = *dbPass
+// tablesValue is a flag.Value for a comma separated list of table names; the flag may also be repeated
+type tablesValue struct {
+	tables *[]string
+}
+
+func (this tablesValue) String() string {
+	if this.tables == nil {
+		return ""
 	}
+	return strings.Join(*this.tables, ",")
+}
 
-	if schemaDir != nil {
-		a.SchemaDir = *schemaDir
+func (this tablesValue) Set(val string) error {
+	for _, table := range strings.Split(val, ",") {
+		table = strings.TrimSpace(table)
+		if table != "" {
+			*this.tables = append(*this.tables, table)
+		}
 	}
+	return nil
+}
 
-	if importBatchSize != nil {
-		a.ImportBatchSize = *importBatchSize
+// addTablesFlag registers -tables, which limits -importData to the listed tables
+func addTablesFlag(fs *flag.FlagSet, a *Args) {
+	fs.Var(tablesValue{tables: &a.ImportTables}, "tables", "Comma separated list of tables whose data -importData replaces, e.g. ITEM,MAGIC.  Defaults to every table")
+}
+
+// addNoBackupFlag registers -noBackup, which skips the backup clean takes before dropping a database that has data
+func addNoBackupFlag(fs *flag.FlagSet, a *Args) {
+	fs.BoolVar(&a.NoBackup, "noBackup", a.NoBackup, "Don't back up databases that have data before dropping them; overrides genConfig.backup.isDisabled")
+}
+
+// addDropOrphanLoginsFlag registers -dropOrphanLogins, which has clean drop the orphan logins it reports
+func addDropOrphanLoginsFlag(fs *flag.FlagSet, a *Args) {
+	fs.BoolVar(&a.DropOrphanLogins, "dropOrphanLogins", a.DropOrphanLogins, "Drop the logins that map to a cleaned database but aren't in the config, instead of only reporting them")
+}
+
+// parseInterleaved parses fs from args, allowing flags to appear after positional arguments,
+// e.g. "export data -schema ./OpenKO-db".  Returns the positional arguments in order.
+func parseInterleaved(fs *flag.FlagSet, args []string) (positional []string, err error) {
+	for {
+		err = fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
+}
 
-	return a
+// printRootUsage prints the command list followed by the global and legacy flags
+func printRootUsage(root *flag.FlagSet) {
+	out := root.Output()
+	fmt.Fprintf(out, "Usage: %s [global flags] <command> [command flags] [arguments]\n\nCommands:\n", appName)
+	for i := range commands {
+		fmt.Fprintf(out, "  %-32s %s\n", commands[i].Name+" "+commands[i].ArgsUsage, commands[i].Summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s help <command>' or '%s <command> -h' for the command's flags.\n\nGlobal flags:\n", appName, appName)
+	root.PrintDefaults()
 }
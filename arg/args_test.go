@@ -0,0 +1,89 @@
+package arg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		argv    []string
+		wantErr string // substring of the expected error; empty for none
+		check   func(a Args) bool
+	}{
+		{name: "import command", argv: []string{"import"}, check: func(a Args) bool { return a.Command == "import" && a.Import }},
+		{name: "import kinds", argv: []string{"import", "views", "procs"}, check: func(a Args) bool { return a.ImportViews && a.ImportProcs && !a.Import }},
+		{name: "global flags before command", argv: []string{"-config", "other.yaml", "clean", "-yes"}, check: func(a Args) bool { return a.ConfigPath == "other.yaml" && a.Clean && a.Yes }},
+		{name: "export kinds", argv: []string{"export", "views", "procs"}, check: func(a Args) bool { return a.ExportViews && a.ExportProcs && !a.ExportAll }},
+		{name: "diff roundtrip", argv: []string{"diff", "roundtrip"}, check: func(a Args) bool { return a.RoundTrip && !a.VerifyModels }},
+		{name: "config check", argv: []string{"config", "check"}, check: func(a Args) bool { return a.CheckConfig }},
+		{name: "config init", argv: []string{"config", "init"}, check: func(a Args) bool { return a.Init && !a.CheckConfig }},
+		{name: "preflight before command", argv: []string{"-preflight", "import"}, check: func(a Args) bool { return a.Preflight && a.Import }},
+		{name: "legacy flag", argv: []string{"-exportAll"}, check: func(a Args) bool { return a.ExportAll && len(a.Deprecated) == 1 }},
+		{name: "legacy flag with command", argv: []string{"-clean", "import"}, wantErr: "cannot be combined with legacy job flags"},
+		{name: "serve with command", argv: []string{"-serve", ":8080", "clean"}, wantErr: "cannot be combined with legacy job flags or -serve"},
+		{name: "init with command", argv: []string{"-init", "clean"}, wantErr: "cannot be combined with legacy job flags"},
+		{name: "unknown command", argv: []string{"frobnicate"}, wantErr: "unknown command"},
+		{name: "unknown import kind", argv: []string{"import", "tables"}, wantErr: "unknown import kind"},
+		{name: "unknown export kind", argv: []string{"export", "tables"}, wantErr: "unknown export kind"},
+		{name: "unknown diff mode", argv: []string{"diff", "tables"}, wantErr: "unknown diff mode"},
+		{name: "config without subcommand", argv: []string{"config"}, wantErr: "config requires a subcommand"},
+		{name: "no command", argv: []string{"-logLevel", "debug"}, wantErr: "no command provided"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := ParseArgs(test.argv)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("ParseArgs(%v) error = %v, want %q", test.argv, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseArgs(%v) error = %v", test.argv, err)
+			}
+			if !test.check(a) {
+				t.Errorf("ParseArgs(%v) = %+v", test.argv, a)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		argv    []string
+		wantErr string
+	}{
+		{name: "import", argv: []string{"import"}},
+		{name: "preflight only", argv: []string{"-preflight", "config", "check"}},
+		{name: "legacy clean and export", argv: []string{"-clean", "-exportData"}, wantErr: "cannot perform both clean and export"},
+		{name: "legacy import and export", argv: []string{"-import", "-exportViews"}, wantErr: "redundant"},
+		{name: "legacy round trip and clean", argv: []string{"-roundTrip", "-clean"}, wantErr: "-roundTrip cannot be combined"},
+		{name: "security with structure", argv: []string{"export", "security", "structure"}, wantErr: "export security cannot be combined"},
+		{name: "security with all", argv: []string{"export", "security", "all"}, wantErr: "export security cannot be combined"},
+		{name: "tables without data", argv: []string{"import", "views", "-tables", "ITEM"}, wantErr: "-tables requires import data"},
+		{name: "init with preflight", argv: []string{"-preflight", "config", "init"}, wantErr: "-init cannot be combined"},
+		{name: "serve on the network", argv: []string{"serve", "0.0.0.0:8080"}, wantErr: "must be on localhost"},
+		{name: "invalid snapshot name", argv: []string{"-snapshot", "a-b"}, wantErr: "invalid snapshot name"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := ParseArgs(test.argv)
+			if err != nil {
+				t.Fatalf("ParseArgs(%v) error = %v", test.argv, err)
+			}
+			err = a.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package arg
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Command is a kodb-util subcommand.  Every command accepts the global flags in addition to its own.
+type Command struct {
+	Name        string
+	ArgsUsage   string // synopsis of the positional arguments, e.g. "<kind>..."
+	Summary     string // one line description for the command list
+	Description string // shown in the command's help
+
+	// Flags registers the command-specific flags, if any
+	Flags func(fs *flag.FlagSet, a *Args)
+
+	// Apply sets the jobs to run from the positional arguments
+	Apply func(a *Args, positional []string) error
+}
+
+// export kinds accepted by the export command, and the job each one enables
+var exportKinds = []struct {
+	Name string
+	Set  func(a *Args)
+	Help string
+}{
+	{Name: "data", Set: func(a *Args) { a.ExportData = true }, Help: "table data as 6_InsertData_*.sql"},
+	{Name: "structure", Set: func(a *Args) { a.ExportStructure = true }, Help: "database, schema, user, login, table, and role creation scripts from the config"},
+	{Name: "views", Set: func(a *Args) { a.ExportViews = true }, Help: "views as 7_CreateView_*.sql"},
+	{Name: "procs", Set: func(a *Args) { a.ExportProcs = true }, Help: "stored procedures as 8_CreateStoredProc_*.sql and jsonSchema/procedures"},
+	{Name: "jsonschema", Set: func(a *Args) { a.ExportJsonSchema = true }, Help: "table properties merged into jsonSchema; not part of all"},
+	{Name: "security", Set: func(a *Args) { a.ExportSecurity = true }, Help: "schemas, users, logins, roles, and grants read from the server, and a gameDb config fragment; not part of all, and can't be combined with structure"},
+	{Name: "all", Set: func(a *Args) { a.ExportAll = true }, Help: "data, structure, views, and procs"},
+}
+
+// commands lists the subcommands in the order they're shown in the help
+var commands = []Command{
+	{
+		Name:      "import",
+		ArgsUsage: "[views|procs|data]...",
+		Summary:   "Clean, then import OpenKO-db into the configured databases; or replace views, procs, or data",
+		Description: "Without arguments, runs clean and imports the contents of OpenKO-db/ManualSetup, StoredProcedures, and Views.\n" +
+			"With arguments, replaces only those objects in the existing database, within a transaction, without running clean:\n" +
+			"  views        drop and re-create the views in 7_CreateView_*.sql\n" +
+			"  procs        drop and re-create the stored procedures in 8_CreateStoredProc_*.sql\n" +
+			"  data         empty the tables listed in -tables (default all) and insert 6_InsertData_*.sql",
+		Flags: func(fs *flag.FlagSet, a *Args) {
+			fs.IntVar(&a.ImportBatchSize, "batchSize", a.ImportBatchSize, "Batch sized used when importing table data.  Valid range [2-999], if invalid value specified will default to 16")
+			addTablesFlag(fs, a)
+			addNoBackupFlag(fs, a)
+			addDropOrphanLoginsFlag(fs, a)
+		},
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) == 0 {
+				a.Import = true
+				return nil
+			}
+			for _, kind := range positional {
+				switch strings.ToLower(kind) {
+				case "views":
+					a.ImportViews = true
+				case "procs":
+					a.ImportProcs = true
+				case "data":
+					a.ImportData = true
+				default:
+					return fmt.Errorf("unknown import kind %s, expected views, procs, or data", kind)
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "clean",
+		Summary: "Drop the configured databases and logins",
+		Description: "Clean closes the other sessions using each configured database, drops it along with its snapshots, then drops the " +
+			"configured logins.  A database that has data is backed up first to a timestamped .bak in genConfig.backup.dir (or the " +
+			"instance's default backup directory), unless -noBackup is set.  Logins that map to a dropped database but aren't in " +
+			"the config are reported, and dropped with -dropOrphanLogins.",
+		Flags: func(fs *flag.FlagSet, a *Args) {
+			addNoBackupFlag(fs, a)
+			addDropOrphanLoginsFlag(fs, a)
+		},
+		Apply: func(a *Args, positional []string) error {
+			if err := noPositional("clean", positional); err != nil {
+				return err
+			}
+			a.Clean = true
+			return nil
+		},
+	},
+	{
+		Name:      "restore",
+		ArgsUsage: "<file|latest>",
+		Summary:   "Restore the configured databases from a backup taken by clean",
+		Description: "Restore each configured database from file, a path on the database server or a file name in genConfig.backup.dir, " +
+			"or from the latest backup of that database in the server's backup history.  A backup of a different database is skipped.  " +
+			"The configured logins and users are re-created afterwards.  Equivalent to -restore file.",
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) != 1 {
+				return fmt.Errorf("restore requires a backup file or latest")
+			}
+			a.Restore = positional[0]
+			return nil
+		},
+	},
+	{
+		Name:      "snapshot",
+		ArgsUsage: "<create|revert|drop> <name> | list",
+		Summary:   "Create, revert to, drop, or list database snapshots of the configured databases",
+		Description: "Database snapshots are a fast way to reset a database to a known state, e.g. before each integration test:\n" +
+			"  create <name>   snapshot each configured database as [db]_snapshot_[name]; equivalent to -snapshot name\n" +
+			"  revert <name>   revert each configured database to its snapshot; the snapshot is kept.  Other snapshots of the\n" +
+			"                  database must be dropped first.  Equivalent to -revert name\n" +
+			"  drop <name>     drop the snapshot; equivalent to -dropSnapshot name\n" +
+			"  list            list the snapshots of each configured database; equivalent to -listSnapshots\n" +
+			"Databases with isForbidClean set are skipped, except by list.  Clean drops a database's snapshots along with it.",
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) == 1 && strings.EqualFold(positional[0], "list") {
+				a.ListSnapshots = true
+				return nil
+			}
+			if len(positional) != 2 {
+				return fmt.Errorf("snapshot requires create, revert, or drop and a name, or list")
+			}
+			switch strings.ToLower(positional[0]) {
+			case "create":
+				a.Snapshot = positional[1]
+			case "revert":
+				a.Revert = positional[1]
+			case "drop":
+				a.DropSnapshot = positional[1]
+			default:
+				return fmt.Errorf("unknown snapshot action %s, expected create, revert, drop, or list", positional[0])
+			}
+			return nil
+		},
+	},
+	{
+		Name:        "export",
+		ArgsUsage:   "<kind>...",
+		Summary:     "Export the database to OpenKO-db: data, structure, views, procs, jsonschema, security, or all",
+		Description: exportDescription(),
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) == 0 {
+				return fmt.Errorf("export requires at least one kind")
+			}
+			for _, kindName := range positional {
+				if err := SetExportKind(a, kindName); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Name:      "diff",
+		ArgsUsage: "[models|roundtrip]",
+		Summary:   "Compare the database with the models and OpenKO-db",
+		Description: "models (default): compare the columns, types, nullability, defaults, and indexes of the OpenKO-gorm models, jsonSchema, and database and report any mismatches.\n" +
+			"roundtrip: import into a scratch database, export everything to a temp directory, and report files that differ from OpenKO-db/ManualSetup and jsonSchema.  The scratch database is dropped afterwards.",
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) > 1 {
+				return fmt.Errorf("diff accepts a single mode")
+			}
+			mode := "models"
+			if len(positional) == 1 {
+				mode = positional[0]
+			}
+			return SetDiffMode(a, mode)
+		},
+	},
+	{
+		Name:        "lint",
+		Summary:     "Check OpenKO-db/jsonSchema without connecting to the database",
+		Description: "Check OpenKO-db/jsonSchema for MANUAL_TODO markers, duplicate names, invalid identifiers, invalid type lengths, and bad index columns.  Does not connect to the database.",
+		Apply: func(a *Args, positional []string) error {
+			if err := noPositional("lint", positional); err != nil {
+				return err
+			}
+			a.LintSchema = true
+			return nil
+		},
+	},
+	{
+		Name:      "config",
+		ArgsUsage: "check|init",
+		Summary:   "Validate or create the config file",
+		Description: "check: validate the config file (with any profile, environment, and command-line overrides applied) and exit.  " +
+			"init: ask for the server's host, port, instance, and authentication, and the OpenKO-db directory, test the connection " +
+			"and the login's permissions, and write them to the config file, keeping its other settings.  Equivalent to -init.",
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) == 1 && strings.EqualFold(positional[0], "check") {
+				a.CheckConfig = true
+				return nil
+			}
+			if len(positional) == 1 && strings.EqualFold(positional[0], "init") {
+				a.Init = true
+				return nil
+			}
+			return fmt.Errorf("config requires a subcommand: check or init")
+		},
+	},
+	{
+		Name:    "watch",
+		Summary: "Re-run changed view and stored procedure scripts until cancelled",
+		Description: "Watch OpenKO-db/ManualSetup and, when a 7_CreateView_*.sql or 8_CreateStoredProc_*.sql script changes, drop the view or " +
+			"procedure and re-run the script against the configured databases.  Each change is applied in its own transaction; " +
+			"failures are logged and the previous version is kept.  Press Ctrl-C to stop.  Equivalent to -watch.",
+		Apply: func(a *Args, positional []string) error {
+			if err := noPositional("watch", positional); err != nil {
+				return err
+			}
+			a.Watch = true
+			return nil
+		},
+	},
+	{
+		Name:      "serve",
+		ArgsUsage: "[addr]",
+		Summary:   "Serve the import, clean, export, and diff jobs as an HTTP API on localhost",
+		Description: "Listen on addr (default " + DefaultServeAddr + ") and run jobs requested over HTTP, streaming their progress and logs as " +
+			"Server-Sent Events.  Only localhost addresses are accepted.  Equivalent to -serve addr.",
+		Apply: func(a *Args, positional []string) error {
+			if len(positional) > 1 {
+				return fmt.Errorf("serve accepts a single address")
+			}
+			a.Serve = DefaultServeAddr
+			if len(positional) == 1 {
+				a.Serve = positional[0]
+			}
+			return nil
+		},
+	},
+}
+
+// SetExportKind enables the export job for kind, one of the export command's arguments, e.g. "data"
+func SetExportKind(a *Args, kind string) error {
+	for i := range exportKinds {
+		if strings.EqualFold(exportKinds[i].Name, kind) {
+			exportKinds[i].Set(a)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown export kind %s", kind)
+}
+
+// SetDiffMode enables the diff job for mode: models or roundtrip
+func SetDiffMode(a *Args, mode string) error {
+	switch strings.ToLower(mode) {
+	case "models":
+		a.VerifyModels = true
+	case "roundtrip":
+		a.RoundTrip = true
+	default:
+		return fmt.Errorf("unknown diff mode %s, expected models or roundtrip", mode)
+	}
+	return nil
+}
+
+// findCommand returns the command named name, or nil
+func findCommand(name string) *Command {
+	for i := range commands {
+		if strings.EqualFold(commands[i].Name, name) {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns the flag set for this command: its own flags plus the global flags
+func (this *Command) newFlagSet(a *Args) *flag.FlagSet {
+	fs := flag.NewFlagSet(appName+" "+this.Name, flag.ContinueOnError)
+	if this.Flags != nil {
+		this.Flags(fs, a)
+	}
+	addGlobalFlags(fs, a)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", appName, this.Name, this.ArgsUsage, this.Description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// noPositional returns an error if a command that takes no arguments was given some
+func noPositional(name string, positional []string) error {
+	if len(positional) > 0 {
+		return fmt.Errorf("%s does not take arguments, got: %s", name, strings.Join(positional, " "))
+	}
+	return nil
+}
+
+// exportDescription lists the export kinds for the export command's help
+func exportDescription() string {
+	sb := strings.Builder{}
+	sb.WriteString("Export one or more kinds of artifact from the database to OpenKO-db:")
+	for _, kind := range exportKinds {
+		sb.WriteString(fmt.Sprintf("\n  %-12s %s", kind.Name, kind.Help))
+	}
+	return sb.String()
+}
@@ -1,16 +1,19 @@
 package artifacts
 
 import (
+	"context"
 	"fmt"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/report"
 	"os"
 	"path/filepath"
 )
 
 // the artifacts package contains reference constants and helpers that map to the OpenKO-db project
 // This package shouldn't import any other packages in this project to avoid circular dependencies.
-// Exception: config package
+// Exception: config package, and the mssql/errs/report packages which never import artifacts
 
 const (
 
@@ -19,6 +22,20 @@ const (
 	ViewsDir       = "Views"
 	StoredProcsDir = "StoredProcedures"
 	ManualSetupDir = "ManualSetup"
+	JsonSchemaDir  = "jsonSchema"
+
+	// JsonSchemaProceduresDir is the sub-directory of JsonSchemaDir containing stored procedure definitions
+	JsonSchemaProceduresDir = "procedures"
+
+	// 1. table/procedure name
+	// JsonSchemaNameFmt output format for jsonSchema file names
+	JsonSchemaNameFmt = "%s.json"
+
+	// JsonSchemaSearchPattern is the pattern used to load files from the JsonSchemaDir
+	JsonSchemaSearchPattern = "*.json"
+
+	// JsonSchemaTodoMarker is stubbed into new jsonSchema definitions that will need to have codegen-specific properties manually set
+	JsonSchemaTodoMarker = "MANUAL_TODO"
 
 	// template files used to generate several structural exports
 
@@ -37,92 +54,118 @@ const (
 	ExportTableDataFileNameFmt       = "6_InsertData_%s.sql"
 	ExportViewFileNameFmt            = "7_CreateView_%s.sql"
 	ExportStoredProcedureFileNameFmt = "8_CreateStoredProc_%s.sql"
+	ExportRoleFileNameFmt            = "9_CreateRole_%s.sql"
+
+	// 1. database name
+	// ExportGenDbConfigFileNameFmt is the gameDb config fragment written by the security export
+	ExportGenDbConfigFileNameFmt = "GenDbConfig_%s.yaml"
 )
 
 // ExportDatabaseArtifact writes the generated sql used to create a database in the last import to OpenKO-db/ManualSetup
-func ExportDatabaseArtifact(driver *mssql.MssqlDbDriver, sqlScript string) (err error) {
-	return exportManualSetupArtifact(driver.GenDbConfig.Name, sqlScript, ExportDatabaseFileNameFmt)
+func ExportDatabaseArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, driver.GenDbConfig.Name, sqlScript, ExportDatabaseFileNameFmt)
 }
 
 // ExportSchemaArtifact writes the generated sql used to create a schema in the last import to OpenKO-db/ManualSetup
-func ExportSchemaArtifact(driver *mssql.MssqlDbDriver, schemaIndex int, sqlScript string) (err error) {
+func ExportSchemaArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, schemaIndex int, sqlScript string) (err error) {
 	// A schema name could exist in multiple databases - prevent collision on filename
 	nameFmt := fmt.Sprintf("%s_%s", driver.GenDbConfig.Name, driver.GenDbConfig.Schemas[schemaIndex])
-	return exportManualSetupArtifact(nameFmt, sqlScript, ExportSchemaFileNameFmt)
+	return exportManualSetupArtifact(ctx, driver, nameFmt, sqlScript, ExportSchemaFileNameFmt)
 }
 
 // ExportUserArtifact writes the generated sql used to create a user in the last import to OpenKO-db/ManualSetup
-func ExportUserArtifact(driver *mssql.MssqlDbDriver, userIndex int, sqlScript string) (err error) {
-	return exportManualSetupArtifact(driver.GenDbConfig.Users[userIndex].Name, sqlScript, ExportUserFileNameFmt)
+func ExportUserArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, userIndex int, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, driver.GenDbConfig.Users[userIndex].Name, sqlScript, ExportUserFileNameFmt)
 }
 
 // ExportLoginArtifact writes the generated sql used to create a login in the last import to OpenKO-db/ManualSetup
-func ExportLoginArtifact(driver *mssql.MssqlDbDriver, loginIndex int, sqlScript string) (err error) {
-	return exportManualSetupArtifact(driver.GenDbConfig.Logins[loginIndex].Name, sqlScript, ExportLoginFileNameFmt)
+func ExportLoginArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, loginIndex int, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, driver.GenDbConfig.Logins[loginIndex].Name, sqlScript, ExportLoginFileNameFmt)
 }
 
 // ExportTableArtifact writes the gorm-generated sql used to create a table in the last import to OpenKO-db/ManualSetup
-func ExportTableArtifact(driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
-	return exportManualSetupArtifact(name, sqlScript, ExportTableFileNameFmt)
+func ExportTableArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, name, sqlScript, ExportTableFileNameFmt)
 }
 
 // ExportTableDataArtifact writes the gorm-generated sql used to create a table in the last import to OpenKO-db/ManualSetup
-func ExportTableDataArtifact(driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
-	return exportManualSetupArtifact(name, sqlScript, ExportTableDataFileNameFmt)
+func ExportTableDataArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, name, sqlScript, ExportTableDataFileNameFmt)
 }
 
 // ExportStoredProcArtifact writes the sql extracted using a system query to OpenKO-db/ManualSetup
-func ExportStoredProcArtifact(driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
-	return exportManualSetupArtifact(name, sqlScript, ExportStoredProcedureFileNameFmt)
+func ExportStoredProcArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, name, sqlScript, ExportStoredProcedureFileNameFmt)
 }
 
 // ExportViewArtifact writes the view sql extracted using a system query to OpenKO-db/ManualSetup
-func ExportViewArtifact(driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
-	return exportManualSetupArtifact(name, sqlScript, ExportViewFileNameFmt)
+func ExportViewArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, name string, sqlScript string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, name, sqlScript, ExportViewFileNameFmt)
 }
 
-func exportManualSetupArtifact(name string, sqlScript string, fileNameFmt string) (err error) {
-	fileName := filepath.Join(config.GetConfig().GenConfig.SchemaDir, ManualSetupDir, fmt.Sprintf(fileNameFmt, name))
-	fmt.Println(fmt.Sprintf("Exporting %s", fileName))
-	return os.WriteFile(fileName, []byte(sqlScript), 0644)
+// ExportGenDbConfigArtifact writes a gameDb config fragment read from the database's catalog to OpenKO-db/ManualSetup
+func ExportGenDbConfigArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, fragment string) (err error) {
+	return exportManualSetupArtifact(ctx, driver, driver.GenDbConfig.Name, fragment, ExportGenDbConfigFileNameFmt)
 }
 
-// GetCreateDatabaseScript loads the CreateDatabase template, substitutes variables, and returns the sql script as a string
-func GetCreateDatabaseScript(driver *mssql.MssqlDbDriver) (script string, err error) {
-	sqlFmtBytes, err := os.ReadFile(filepath.Join(config.GetConfig().GenConfig.SchemaDir, TemplatesDir, CreateDatabaseTemplate))
+// exportManualSetupArtifact writes sqlScript to the driver's OpenKO-db/ManualSetup as fileNameFmt with name
+func exportManualSetupArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, name string, sqlScript string, fileNameFmt string) (err error) {
+	fileName := filepath.Join(driver.SchemaDir(), ManualSetupDir, fmt.Sprintf(fileNameFmt, name))
+	logging.FromContext(ctx).DebugContext(ctx, "exporting", "file", fileName)
+	err = os.WriteFile(fileName, []byte(sqlScript), 0644)
 	if err != nil {
-		return "", err
+		return &errs.FileSystemError{Op: "write", Path: fileName, Err: err}
 	}
-
-	return fmt.Sprintf(string(sqlFmtBytes), driver.GenDbConfig.Name), nil
+	report.FileWritten(ctx, fileName)
+	return nil
 }
 
-// GetCreateLoginScript loads the CreateLogin template, substitutes variables, and returns the sql script as a string
-func GetCreateLoginScript(driver *mssql.MssqlDbDriver, loginIndex int) (script string, err error) {
-	sqlFmtBytes, err := os.ReadFile(filepath.Join(config.GetConfig().GenConfig.SchemaDir, TemplatesDir, CreateLoginTemplate))
+// GetCreateDatabaseScript renders the CreateDatabase template and returns the sql script as a string, with the
+// database's genConfig.gameDb[].create options applied.  Legacy templates are given: 1. database name
+func GetCreateDatabaseScript(ctx context.Context, driver *mssql.MssqlDbDriver) (script string, err error) {
+	data := newTemplateData(driver)
+	data.Create = newCreateDatabaseData(driver)
+	script, err = renderTemplate(ctx, driver, CreateDatabaseTemplate, data, driver.GenDbConfig.Name)
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf(string(sqlFmtBytes), driver.GenDbConfig.Logins[loginIndex].Name, driver.GenDbConfig.Name, driver.GenDbConfig.Logins[loginIndex].Pass), nil
-}
-
-// GetCreateUserScript loads the CreateUser template, substitutes variables, and returns the sql script as a string
-func GetCreateUserScript(driver *mssql.MssqlDbDriver, userIndex int) (script string, err error) {
-	sqlFmtBytes, err := os.ReadFile(filepath.Join(config.GetConfig().GenConfig.SchemaDir, TemplatesDir, CreateUserTemplate))
-	if err != nil {
-		return "", err
+	script, ok := addCreateClauses(script, data.Create)
+	if !ok {
+		templatePath := filepath.Join(driver.SchemaDir(), TemplatesDir, CreateDatabaseTemplate)
+		return "", &errs.TemplateError{File: templatePath, Err: fmt.Errorf("no CREATE DATABASE statement to add the create options of %s to", driver.GenDbConfig.Name)}
 	}
+	settings := databaseSettingsSql(driver.GenDbConfig.Name, data.Create.Options)
+	if settings != "" {
+		script = trimBatchTerminators(script) + mssql.BatchTerminator + "\n" + settings
+	}
+	return script, nil
+}
 
-	return fmt.Sprintf(string(sqlFmtBytes), driver.GenDbConfig.Users[userIndex].Name, driver.GenDbConfig.Users[userIndex].Schema, driver.GenDbConfig.Name), nil
+// GetCreateLoginScript renders the CreateLogin template and returns the sql script as a string.  Legacy templates are
+// given: 1. login name 2. database name 3. password
+func GetCreateLoginScript(ctx context.Context, driver *mssql.MssqlDbDriver, loginIndex int) (script string, err error) {
+	login := driver.GenDbConfig.Logins[loginIndex]
+	data := newTemplateData(driver)
+	data.Login = login.Name
+	data.Password = login.Pass
+	return renderTemplate(ctx, driver, CreateLoginTemplate, data, login.Name, driver.GenDbConfig.Name, login.Pass)
 }
 
-// GetCreateSchemaScript loads the CreateSchema template, substitutes variables, and returns the sql script as a string
-func GetCreateSchemaScript(driver *mssql.MssqlDbDriver, schemaIndex int) (script string, err error) {
-	sqlFmtBytes, err := os.ReadFile(filepath.Join(config.GetConfig().GenConfig.SchemaDir, TemplatesDir, CreateSchemaTemplate))
-	if err != nil {
-		return "", err
-	}
+// GetCreateUserScript renders the CreateUser template and returns the sql script as a string.  Legacy templates are
+// given: 1. user name 2. schema 3. database name
+func GetCreateUserScript(ctx context.Context, driver *mssql.MssqlDbDriver, userIndex int) (script string, err error) {
+	user := driver.GenDbConfig.Users[userIndex]
+	data := newTemplateData(driver)
+	data.User = user.Name
+	data.Schema = user.Schema
+	return renderTemplate(ctx, driver, CreateUserTemplate, data, user.Name, user.Schema, driver.GenDbConfig.Name)
+}
 
-	return fmt.Sprintf(string(sqlFmtBytes), driver.GenDbConfig.Schemas[schemaIndex], driver.GenDbConfig.Name), nil
+// GetCreateSchemaScript renders the CreateSchema template and returns the sql script as a string.  Legacy templates
+// are given: 1. schema 2. database name
+func GetCreateSchemaScript(ctx context.Context, driver *mssql.MssqlDbDriver, schemaIndex int) (script string, err error) {
+	data := newTemplateData(driver)
+	data.Schema = driver.GenDbConfig.Schemas[schemaIndex]
+	return renderTemplate(ctx, driver, CreateSchemaTemplate, data, driver.GenDbConfig.Schemas[schemaIndex], driver.GenDbConfig.Name)
 }
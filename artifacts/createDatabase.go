@@ -0,0 +1,159 @@
+package artifacts
+
+import (
+	"fmt"
+	"kodb-util/config"
+	"kodb-util/mssql"
+	"regexp"
+	"strings"
+)
+
+const (
+	// 1. database name
+	dataFileNameFmt = "%s.mdf"
+	logFileNameFmt  = "%s_log.ldf"
+	logFileLogical  = "%s_log"
+
+	// 1. database name 2. option
+	alterDatabaseSqlFmt = "ALTER DATABASE [%s] SET %s"
+)
+
+// createDatabaseRegex finds the CREATE DATABASE statement in CreateDatabase templates that don't use .Create, so the
+// file and collation clauses can be added to it
+var createDatabaseRegex = regexp.MustCompile(`(?i)CREATE\s+DATABASE\s+(\[[^\]]*(\]\][^\]]*)*\]|[^\s;]+)`)
+
+// collateRegex finds a COLLATE clause a template already has, which the configured collation replaces
+var collateRegex = regexp.MustCompile(`(?i)\bCOLLATE\s+[A-Za-z0-9_]+`)
+
+// databaseFileRegex matches a path that is a full file name rather than a directory
+var databaseFileRegex = regexp.MustCompile(`(?i)\.(mdf|ndf|ldf)$`)
+
+// CreateDatabaseData is the CreateDatabase template's view of the database's genConfig.gameDb[].create options, e.g.
+//
+//	CREATE DATABASE [{{.Database}}] {{.Create.Files}} {{.Create.Collation}}
+//
+// CreateDatabase templates that don't use .Create have the clauses added to their CREATE DATABASE statement.  The
+// recovery model, compatibility level, and READ_COMMITTED_SNAPSHOT are always set by statements appended to the
+// template's script.
+type CreateDatabaseData struct {
+	Options   config.DatabaseOptions // as configured
+	Files     string                 // ON PRIMARY (...) LOG ON (...) clauses, or "" if no file options are configured
+	Collation string                 // COLLATE clause, or "" if no collation is configured
+}
+
+// newCreateDatabaseData renders the clauses for the driver's create options
+func newCreateDatabaseData(driver *mssql.MssqlDbDriver) CreateDatabaseData {
+	dbName := driver.GenDbConfig.Name
+	opts := driver.GenDbConfig.Create
+	data := CreateDatabaseData{Options: opts}
+
+	dataFile := fileSpec(dbName, opts.DataFile, fmt.Sprintf(dataFileNameFmt, dbName))
+	logFile := fileSpec(fmt.Sprintf(logFileLogical, dbName), opts.LogFile, fmt.Sprintf(logFileNameFmt, dbName))
+	if dataFile != "" {
+		data.Files = "ON PRIMARY " + dataFile
+	}
+	if logFile != "" {
+		data.Files = strings.TrimSpace(data.Files + " LOG ON " + logFile)
+	}
+	if opts.Collation != "" {
+		data.Collation = "COLLATE " + opts.Collation
+	}
+	return data
+}
+
+// fileSpec returns the (NAME = ...) file specification for opts, or "" if no path is configured; config validation
+// requires a path when any of the other file options are set
+func fileSpec(logicalName string, opts config.DatabaseFileOptions, fileName string) string {
+	if opts.Path == "" {
+		return ""
+	}
+	path := opts.Path
+	if !databaseFileRegex.MatchString(path) {
+		path = mssql.JoinServerPath(path, fileName)
+	}
+	parts := []string{fmt.Sprintf("NAME = [%s]", logicalName), "FILENAME = " + mssql.QuoteString(path)}
+	if opts.Size != "" {
+		parts = append(parts, "SIZE = "+strings.ToUpper(opts.Size))
+	}
+	if opts.MaxSize != "" {
+		parts = append(parts, "MAXSIZE = "+strings.ToUpper(opts.MaxSize))
+	}
+	if opts.Growth != "" {
+		parts = append(parts, "FILEGROWTH = "+strings.ToUpper(opts.Growth))
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// addCreateClauses adds the file and collation clauses that script doesn't already contain to its CREATE DATABASE
+// statement, for templates that don't use .Create.  A COLLATE clause in the statement is replaced by the configured
+// collation.  Returns false if the script has no CREATE DATABASE statement.
+func addCreateClauses(script string, data CreateDatabaseData) (string, bool) {
+	addFiles := data.Files != "" && !strings.Contains(script, data.Files)
+	addCollation := data.Collation != "" && !strings.Contains(script, data.Collation)
+	if !addFiles && !addCollation {
+		return script, true
+	}
+	loc := createDatabaseRegex.FindStringIndex(script)
+	if loc == nil {
+		return script, false
+	}
+	head, statement, rest := script[:loc[1]], script[loc[1]:], ""
+	if end := strings.Index(strings.ToUpper(statement), mssql.BatchTerminator); end >= 0 {
+		statement, rest = statement[:end], statement[end:]
+	}
+
+	clauses := []string{}
+	if addFiles {
+		clauses = append(clauses, data.Files)
+	}
+	if addCollation {
+		if collateRegex.MatchString(statement) {
+			statement = collateRegex.ReplaceAllLiteralString(statement, data.Collation)
+		} else {
+			clauses = append(clauses, data.Collation)
+		}
+	}
+	if len(clauses) > 0 {
+		head += " " + strings.Join(clauses, " ")
+	}
+	return head + statement + rest, true
+}
+
+// trimBatchTerminators removes trailing whitespace and GO statements from script
+func trimBatchTerminators(script string) string {
+	for {
+		trimmed := strings.TrimRight(script, " \t\r\n")
+		if !strings.HasSuffix(strings.ToUpper(trimmed), mssql.BatchTerminator) {
+			return trimmed
+		}
+		script = trimmed[:len(trimmed)-len(mssql.BatchTerminator)]
+	}
+}
+
+// databaseSettingsSql returns the ALTER DATABASE batches that apply the options that can't be given to CREATE
+// DATABASE, or "" if none are configured
+func databaseSettingsSql(dbName string, opts config.DatabaseOptions) string {
+	settings := []string{}
+	if opts.RecoveryModel != "" {
+		settings = append(settings, "RECOVERY "+strings.ToUpper(opts.RecoveryModel))
+	}
+	if opts.CompatibilityLevel != 0 {
+		settings = append(settings, fmt.Sprintf("COMPATIBILITY_LEVEL = %d", opts.CompatibilityLevel))
+	}
+	if opts.ReadCommittedSnapshot {
+		settings = append(settings, "READ_COMMITTED_SNAPSHOT ON")
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("-- settings from genConfig.gameDb[].create")
+	for _, setting := range settings {
+		sb.WriteString("\n")
+		sb.WriteString(fmt.Sprintf(alterDatabaseSqlFmt, dbName, setting))
+		sb.WriteString(mssql.BatchTerminator)
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Open-KO/kodb-godef/jsonSchema"
+	"os"
+	"path/filepath"
+)
+
+// TableDefFile pairs a jsonSchema table definition with the file it was loaded from
+type TableDefFile struct {
+	Path string
+	Def  jsonSchema.TableDef
+}
+
+// ProcDefFile pairs a jsonSchema procedure definition with the file it was loaded from
+type ProcDefFile struct {
+	Path string
+	Def  jsonSchema.ProcDef
+}
+
+// JsonSchemaPath returns the path of OpenKO-db/jsonSchema, where schemaDir is the OpenKO-db project
+func JsonSchemaPath(schemaDir string) string {
+	return filepath.Join(schemaDir, JsonSchemaDir)
+}
+
+// JsonSchemaProcPath returns the path of OpenKO-db/jsonSchema/procedures, where schemaDir is the OpenKO-db project
+func JsonSchemaProcPath(schemaDir string) string {
+	return filepath.Join(JsonSchemaPath(schemaDir), JsonSchemaProceduresDir)
+}
+
+// LoadTableDefs reads every OpenKO-db/jsonSchema/*.json file.  Files that fail to parse are returned in parseErrs
+// so callers can decide whether to report or abort.
+func LoadTableDefs(schemaDir string) (defs []TableDefFile, parseErrs []error, err error) {
+	fileNames, err := filepath.Glob(filepath.Join(JsonSchemaPath(schemaDir), JsonSchemaSearchPattern))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range fileNames {
+		fileBytes, err := os.ReadFile(fileNames[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		def := TableDefFile{Path: fileNames[i]}
+		err = json.Unmarshal(fileBytes, &def.Def)
+		if err != nil {
+			parseErrs = append(parseErrs, fmt.Errorf("%s: failed to unmarshal into TableDef: %v", fileNames[i], err))
+			continue
+		}
+		defs = append(defs, def)
+	}
+
+	return defs, parseErrs, nil
+}
+
+// LoadProcDefs reads every OpenKO-db/jsonSchema/procedures/*.json file.  Files that fail to parse are returned in
+// parseErrs so callers can decide whether to report or abort.
+func LoadProcDefs(schemaDir string) (defs []ProcDefFile, parseErrs []error, err error) {
+	fileNames, err := filepath.Glob(filepath.Join(JsonSchemaProcPath(schemaDir), JsonSchemaSearchPattern))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range fileNames {
+		fileBytes, err := os.ReadFile(fileNames[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		def := ProcDefFile{Path: fileNames[i]}
+		err = json.Unmarshal(fileBytes, &def.Def)
+		if err != nil {
+			parseErrs = append(parseErrs, fmt.Errorf("%s: failed to unmarshal into ProcDef: %v", fileNames[i], err))
+			continue
+		}
+		defs = append(defs, def)
+	}
+
+	return defs, parseErrs, nil
+}
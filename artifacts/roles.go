@@ -0,0 +1,121 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"kodb-util/config"
+	"kodb-util/mssql"
+	"strings"
+)
+
+const (
+	// 1. role name literal 2. role name
+	createRoleSqlFmt = "IF DATABASE_PRINCIPAL_ID(%s) IS NULL\n\tCREATE ROLE %s"
+
+	// 1. role name 2. member name
+	addRoleMemberSqlFmt = "ALTER ROLE %s ADD MEMBER %s"
+
+	// 1. permissions 2. securable 3. role name
+	grantSqlFmt = "GRANT %s ON %s TO %s"
+
+	// grants on every object of a type are built when the script runs, so that they cover the objects that exist then.
+	// 1. GRANT ... ON OBJECT:: literal 2. TO ... literal 3. catalog view 4. schema filter
+	grantObjectTypeSqlFmt = `DECLARE @grants NVARCHAR(MAX) = N''
+SELECT @grants = @grants + %s + QUOTENAME(SCHEMA_NAME([schema_id])) + N'.' + QUOTENAME([name]) + %s + NCHAR(10)
+FROM %s
+WHERE [is_ms_shipped] = 0%s
+EXEC sp_executesql @grants`
+)
+
+// grantObjectTypeViews are the catalog views listing the objects of each GrantConfig.ObjectType
+var grantObjectTypeViews = map[string]string{
+	config.GrantViews:      "[sys].[views]",
+	config.GrantProcedures: "[sys].[procedures]",
+}
+
+// ExportRoleArtifact writes the generated sql used to create a role in the last import to OpenKO-db/ManualSetup
+func ExportRoleArtifact(ctx context.Context, driver *mssql.MssqlDbDriver, roleIndex int, sqlScript string) (err error) {
+	// A role name could exist in multiple databases - prevent collision on filename
+	nameFmt := fmt.Sprintf("%s_%s", driver.GenDbConfig.Name, driver.GenDbConfig.Roles[roleIndex].Name)
+	return exportManualSetupArtifact(ctx, driver, nameFmt, sqlScript, ExportRoleFileNameFmt)
+}
+
+// GetCreateRoleScript returns the sql script that creates the role configured in genConfig.gameDb[].roles, adds its
+// members, and grants its permissions.  The script can be run again on a database that has the role.
+func GetCreateRoleScript(ctx context.Context, driver *mssql.MssqlDbDriver, roleIndex int) (script string, err error) {
+	role := driver.GenDbConfig.Roles[roleIndex]
+	batches := []string{}
+	if !role.IsFixed() {
+		batches = append(batches, createRoleSql(role.Name))
+	}
+
+	for _, member := range role.Members {
+		// a member role may be configured after this one
+		if memberRole, ok := findRole(driver.GenDbConfig.Roles, member); ok && !memberRole.IsFixed() {
+			batches = append(batches, createRoleSql(memberRole.Name))
+		}
+		batches = append(batches, fmt.Sprintf(addRoleMemberSqlFmt, quoteName(role.Name), quoteName(member)))
+	}
+
+	for _, grant := range role.Grants {
+		permissions := make([]string, len(grant.Permissions))
+		for i, permission := range grant.Permissions {
+			permissions[i] = strings.ToUpper(strings.Join(strings.Fields(permission), " "))
+		}
+		permissionList := strings.Join(permissions, ", ")
+
+		switch {
+		case len(grant.Objects) > 0:
+			for _, object := range grant.Objects {
+				batches = append(batches, fmt.Sprintf(grantSqlFmt, permissionList, "OBJECT::"+quoteObjectName(object), quoteName(role.Name)))
+			}
+		case grant.ObjectType != "":
+			schemaFilter := ""
+			if grant.Schema != "" {
+				schemaFilter = " AND SCHEMA_NAME([schema_id]) = " + mssql.QuoteString(grant.Schema)
+			}
+			batches = append(batches, fmt.Sprintf(grantObjectTypeSqlFmt,
+				mssql.QuoteString(fmt.Sprintf("GRANT %s ON OBJECT::", permissionList)),
+				mssql.QuoteString(fmt.Sprintf(" TO %s;", quoteName(role.Name))),
+				grantObjectTypeViews[strings.ToLower(grant.ObjectType)],
+				schemaFilter))
+		default:
+			batches = append(batches, fmt.Sprintf(grantSqlFmt, permissionList, "SCHEMA::"+quoteName(grant.Schema), quoteName(role.Name)))
+		}
+	}
+
+	header := fmt.Sprintf("-- role %s of database %s, from genConfig.gameDb[].roles\n", role.Name, driver.GenDbConfig.Name)
+	if len(batches) == 0 {
+		return header, nil
+	}
+	return header + strings.Join(batches, mssql.BatchTerminator+"\n") + mssql.BatchTerminator + "\n", nil
+}
+
+// createRoleSql returns the statement that creates the role named name if it doesn't exist
+func createRoleSql(name string) string {
+	return fmt.Sprintf(createRoleSqlFmt, mssql.QuoteString(name), quoteName(name))
+}
+
+// findRole returns the role named name, ignoring case
+func findRole(roles []config.RoleConfig, name string) (config.RoleConfig, bool) {
+	for _, role := range roles {
+		if strings.EqualFold(role.Name, name) {
+			return role, true
+		}
+	}
+	return config.RoleConfig{}, false
+}
+
+// quoteName returns name as a bracketed identifier
+func quoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// quoteObjectName returns a [schema.]name object name as [schema].[name]; the schema defaults to dbo
+func quoteObjectName(object string) string {
+	schema, name, ok := strings.Cut(object, ".")
+	if !ok {
+		schema, name = "dbo", object
+	}
+	return quoteName(schema) + "." + quoteName(name)
+}
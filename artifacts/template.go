@@ -0,0 +1,88 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is the data model of the OpenKO-db/Templates files, which are rendered with text/template, e.g.
+//
+//	CREATE LOGIN [{{.Login}}] WITH PASSWORD = {{sqlString .Password}}, DEFAULT_DATABASE = [{{.Database}}]
+//
+// Fields that don't apply to a template are empty.  Referencing a Vars key that isn't configured is an error.
+type TemplateData struct {
+	Database string // name of the database being created, or that the schema/user/login belongs to
+	DbType   string // type of the database, e.g. GAME
+	Schema   string // CreateSchema: the schema to create; CreateUser: the user's default schema
+	User     string // CreateUser: the user to create
+	Login    string // CreateLogin: the login to create
+	Password string // CreateLogin: the login's password
+
+	// Create is CreateDatabase's view of the database's create options
+	Create CreateDatabaseData
+
+	// Vars are genConfig.templateVars overlaid with the database's templateVars
+	Vars map[string]string
+}
+
+// templateFuncs are available to every template
+var templateFuncs = template.FuncMap{
+	// sqlString quotes a value as an N'...' string literal
+	"sqlString": mssql.QuoteString,
+	// sqlIdent escapes a value for use between [ and ]
+	"sqlIdent": func(val string) string {
+		return strings.ReplaceAll(val, "]", "]]")
+	},
+}
+
+// newTemplateData returns the data shared by every template of the driver's database
+func newTemplateData(driver *mssql.MssqlDbDriver) TemplateData {
+	vars := map[string]string{}
+	for key, val := range driver.Config.GenConfig.TemplateVars {
+		vars[key] = val
+	}
+	for key, val := range driver.GenDbConfig.TemplateVars {
+		vars[key] = val
+	}
+	return TemplateData{
+		Database: driver.GenDbConfig.Name,
+		DbType:   string(driver.DbType),
+		Vars:     vars,
+	}
+}
+
+// renderTemplate loads a file in the driver's OpenKO-db/Templates and renders it with data.  Templates written before text/template
+// support use fmt verbs, e.g. %s, and are detected by having no {{ actions; they're given legacyArgs in order.
+func renderTemplate(ctx context.Context, driver *mssql.MssqlDbDriver, templateName string, data TemplateData, legacyArgs ...any) (string, error) {
+	templatePath := filepath.Join(driver.SchemaDir(), TemplatesDir, templateName)
+	templateBytes, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", &errs.TemplateError{File: templatePath, Err: err}
+	}
+	report.FileRead(ctx, templatePath)
+	text := string(templateBytes)
+
+	if !strings.Contains(text, "{{") {
+		logging.FromContext(ctx).DebugContext(ctx, "rendering legacy template with positional arguments", "template", templateName)
+		return fmt.Sprintf(text, legacyArgs...), nil
+	}
+
+	tmpl, err := template.New(templateName).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", &errs.TemplateError{File: templatePath, Err: err}
+	}
+	sb := strings.Builder{}
+	err = tmpl.Execute(&sb, data)
+	if err != nil {
+		return "", &errs.TemplateError{File: templatePath, Err: err}
+	}
+	return sb.String(), nil
+}
@@ -3,29 +3,50 @@ package config
 import (
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
 	DefaultConfigFileName = "kodb-util-config.yaml"
-)
 
-var (
-	// ConfigPath is where the application will load the configuration file from.
-	// This path is relative to the working directory; not this source file.
-	// This can be overridden via CLI argument -config
-	ConfigPath = ""
+	// DatabaseConfig.AuthMode values
+
+	AuthModeSql      = "sql"
+	AuthModeWindows  = "windows"
+	AuthModeAzure    = "azure"
+	AuthModeKerberos = "krb5"
+
+	// GrantConfig.ObjectType values
+
+	GrantViews      = "views"
+	GrantProcedures = "procedures"
 
-	// configInstance implements a singleton for the GetConfig() function
-	configInstance *KodbConfig
+	// DefaultMaxGuardedRows is used when SafetyConfig.MaxGuardedRows is 0
+	DefaultMaxGuardedRows = 100
 )
 
+// FixedDatabaseRoles exist in every database; RoleConfig entries with these names only add members
+var FixedDatabaseRoles = []string{"db_owner", "db_securityadmin", "db_accessadmin", "db_backupoperator", "db_ddladmin",
+	"db_datawriter", "db_datareader", "db_denydatawriter", "db_denydatareader"}
+
+// DefaultGuardedTables are the account and character tables used when SafetyConfig.GuardedTables is empty
+var DefaultGuardedTables = []string{"TB_USER", "ACCOUNT_CHAR", "USERDATA"}
+
 // KodbConfig is the structure that binds the values in the configuration file
 type KodbConfig struct {
 	DatabaseConfig DatabaseConfig `yaml:"databaseConfig"`
 	GenConfig      GenConfig      `yaml:"genConfig"`
+	Safety         SafetyConfig   `yaml:"safety"`
+
+	// Profiles are named partial configurations that are overlaid on the base configuration when selected with
+	// -profile.  Only the properties present in the profile are replaced; lists are replaced as a whole.
+	Profiles map[string]yaml.Node `yaml:"profiles,omitempty"`
+
+	filePath    string     // absolute path the configuration was loaded from
+	node        yaml.Node  // parsed document, used for error positions
+	profileNode *yaml.Node // selected profile, if any, used for error positions
 }
 
 // DatabaseConfig contains the connection configuration for an MSSQL server instance
@@ -34,7 +55,50 @@ type DatabaseConfig struct {
 	Port     int    `yaml:"port"`
 	Instance string `yaml:"instance"`
 	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	Password string `yaml:"password"` // supports ${ENV_VAR} references
+
+	// PasswordFile is read into Password when set; useful for docker/k8s secrets
+	PasswordFile string `yaml:"passwordFile"`
+
+	// AuthMode selects how to authenticate.  Blank uses sql auth when User is set, and Windows auth when it isn't.
+	// See the AuthMode* constants
+	AuthMode string `yaml:"authMode"`
+
+	// TLS options; blank/zero values use the go-mssqldb defaults
+	Encrypt                string `yaml:"encrypt"`                // true, false, strict, or disable
+	TrustServerCertificate bool   `yaml:"trustServerCertificate"` // accept self-signed certificates, e.g. SQL Server in a Linux container
+	Certificate            string `yaml:"certificate"`            // path to a pem/der certificate to validate the server against
+	HostNameInCertificate  string `yaml:"hostNameInCertificate"`
+
+	DialTimeout       int    `yaml:"dialTimeout"`       // seconds
+	ConnectionTimeout int    `yaml:"connectionTimeout"` // seconds
+	PacketSize        int    `yaml:"packetSize"`        // bytes
+	AppName           string `yaml:"appName"`           // shown in sys.dm_exec_sessions.program_name
+	FailoverPartner   string `yaml:"failoverPartner"`
+	FailoverPort      int    `yaml:"failoverPort"`
+
+	Azure    AzureAuthConfig    `yaml:"azure"`    // used when AuthMode is AuthModeAzure
+	Kerberos KerberosAuthConfig `yaml:"kerberos"` // used when AuthMode is AuthModeKerberos
+
+	// Params are added to the connection string as-is, for go-mssqldb options that don't have a property here
+	Params map[string]string `yaml:"params"`
+}
+
+// AzureAuthConfig contains the Azure AD/Entra ID options.  User and Password are used as the client id/secret or
+// AD user/password, depending on the FedAuth workflow.
+type AzureAuthConfig struct {
+	// FedAuth is the go-mssqldb fedauth workflow, e.g. ActiveDirectoryDefault, ActiveDirectoryServicePrincipal,
+	// ActiveDirectoryManagedIdentity, ActiveDirectoryPassword
+	FedAuth string `yaml:"fedAuth"`
+}
+
+// KerberosAuthConfig contains the Kerberos options.  Either a KeytabFile or CredCacheFile is required.
+type KerberosAuthConfig struct {
+	ConfigFile    string `yaml:"configFile"` // krb5.conf
+	KeytabFile    string `yaml:"keytabFile"`
+	CredCacheFile string `yaml:"credCacheFile"`
+	Realm         string `yaml:"realm"`
+	ServerSpn     string `yaml:"serverSpn"`
 }
 
 // GenConfig contains the configuration used to generate/export our application databases
@@ -43,6 +107,38 @@ type GenConfig struct {
 	// TODO:  When implementing multi-db, we'll make this into an array of loginDb, gameDb, and logDb
 	//	to allow more flexible generation
 	GameDbs []GenDbConfig `yaml:"gameDb"`
+
+	Backup BackupConfig `yaml:"backup"`
+
+	// TemplateVars are passed to the OpenKO-db/Templates as .Vars, e.g. {{.Vars.collation}}
+	TemplateVars map[string]string `yaml:"templateVars"`
+}
+
+// BackupConfig controls the backup that clean takes before dropping a database that has data
+type BackupConfig struct {
+	// Dir is the directory backups are written to and restored from.  BACKUP DATABASE runs on the server, so this is a
+	// path on the database server.  Blank uses the instance's default backup directory.
+	Dir string `yaml:"dir"`
+
+	// IsDisabled skips the backup before clean.  Can also be set with -noBackup
+	IsDisabled bool `yaml:"isDisabled"`
+}
+
+// SafetyConfig guards the destructive jobs (clean, import, restore, revert) against being run on the wrong server
+type SafetyConfig struct {
+	// AllowedHosts, when set, are the only hosts destructive jobs can run against.  Entries are case-insensitive glob
+	// patterns, e.g. localhost or *.dev.example.com, matched against both host and host\instance
+	AllowedHosts []string `yaml:"allowedHosts"`
+
+	// DeniedHosts are hosts destructive jobs never run against, even with -yes or -force.  Same patterns as AllowedHosts
+	DeniedHosts []string `yaml:"deniedHosts"`
+
+	// GuardedTables hold player data.  Empty uses DefaultGuardedTables
+	GuardedTables []string `yaml:"guardedTables"`
+
+	// MaxGuardedRows is the number of rows in GuardedTables above which a database isn't dropped or replaced without
+	// -force.  0 uses DefaultMaxGuardedRows; negative disables the check
+	MaxGuardedRows int `yaml:"maxGuardedRows"`
 }
 
 // GenDbConfig contains the configuration for an individual application database
@@ -54,12 +150,72 @@ type GenDbConfig struct {
 	IsForbidClean  bool          `yaml:"isForbidClean"`  // forbid any clean operations on this database
 	IsForbidImport bool          `yaml:"isForbidImport"` // forbid any import operations on this database
 	IsForbidExport bool          `yaml:"isForbidExport"` // forbid any export operations for this database
+
+	// TemplateVars are overlaid on genConfig.templateVars for this database's templates
+	TemplateVars map[string]string `yaml:"templateVars"`
+
+	// Create are the settings the database is created with by import
+	Create DatabaseOptions `yaml:"create"`
+
+	// Roles are database roles import creates after the views and stored procedures, with their members and grants
+	Roles []RoleConfig `yaml:"roles"`
+}
+
+// RoleConfig is a database role, its members, and the permissions granted to it.  A fixed role such as db_datareader
+// isn't created and can't be granted permissions, but can be given members.
+type RoleConfig struct {
+	Name    string        `yaml:"name"`
+	Members []string      `yaml:"members,omitempty"` // users or roles of the database
+	Grants  []GrantConfig `yaml:"grants,omitempty"`
+}
+
+// GrantConfig grants permissions on a schema, on named objects, or on every view or stored procedure.  Set one of:
+// Objects, ObjectType, or Schema alone; Schema limits ObjectType to the objects in that schema.
+type GrantConfig struct {
+	Permissions []string `yaml:"permissions"` // e.g. SELECT, EXECUTE
+	Schema      string   `yaml:"schema,omitempty"`
+	Objects     []string `yaml:"objects,omitempty"`    // [schema.]name; the schema defaults to dbo
+	ObjectType  string   `yaml:"objectType,omitempty"` // views or procedures
+}
+
+// DatabaseOptions are the settings a database is created with.  Blank/zero values use the server's defaults.
+type DatabaseOptions struct {
+	Collation string `yaml:"collation"` // e.g. Korean_Wansung_CI_AS
+
+	DataFile DatabaseFileOptions `yaml:"dataFile"`
+	LogFile  DatabaseFileOptions `yaml:"logFile"`
+
+	RecoveryModel         string `yaml:"recoveryModel"`         // SIMPLE, FULL, or BULK_LOGGED
+	CompatibilityLevel    int    `yaml:"compatibilityLevel"`    // e.g. 150 for SQL Server 2019
+	ReadCommittedSnapshot bool   `yaml:"readCommittedSnapshot"` // SET READ_COMMITTED_SNAPSHOT ON
+}
+
+// DatabaseFileOptions are the settings of a database's data or log file.  Sizes are a number with an optional KB, MB,
+// GB, or TB suffix, e.g. 512MB; Growth can also be a percentage, and MaxSize can be UNLIMITED.
+type DatabaseFileOptions struct {
+	// Path is a directory, or a full file name ending in .mdf, .ndf, or .ldf, on the database server.  Files in a
+	// directory are named [db].mdf and [db]_log.ldf.  Required if any other option of the file is set.
+	Path    string `yaml:"path"`
+	Size    string `yaml:"size"`
+	MaxSize string `yaml:"maxSize"`
+	Growth  string `yaml:"growth"`
+}
+
+// IsFixed returns true if the role is one of the FixedDatabaseRoles
+func (this RoleConfig) IsFixed() bool {
+	for _, name := range FixedDatabaseRoles {
+		if strings.EqualFold(name, this.Name) {
+			return true
+		}
+	}
+	return false
 }
 
 // LoginConfig contains the configuration of a single database login credential
 type LoginConfig struct {
-	Name string `yaml:"name"`
-	Pass string `yaml:"pass"`
+	Name     string `yaml:"name"`
+	Pass     string `yaml:"pass"`               // supports ${ENV_VAR} references
+	PassFile string `yaml:"passFile,omitempty"` // read into Pass when set
 }
 
 // UserConfig contains the configuration of a single database user
@@ -68,36 +224,60 @@ type UserConfig struct {
 	Schema string `yaml:"schema"`
 }
 
-// GetConfig returns a singleton instance of KodbConfig containing the application's configuration.
-// can throw panic if config cannot be loaded
-func GetConfig() *KodbConfig {
-	if configInstance == nil {
-		loadConfig()
+// Load reads the configuration file at path, relative to the working directory, and overlays the named entry of the
+// top-level profiles key and the KODB_* environment variables.  An empty path loads DefaultConfigFileName; an empty
+// profile falls back to the KODB_PROFILE environment variable.  Each call returns a new KodbConfig.
+func Load(path string, profile string) (conf *KodbConfig, err error) {
+	if path == "" {
+		path = DefaultConfigFileName
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse path for config: %v", err)
 	}
 
-	return configInstance
-}
+	yamlFile, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
 
-// loadConfig attempts to read the configuration file and unmarshal it to a KodbConfig struct
-// can throw panic if the configuration cannot be loaded
-func loadConfig() {
-	// Failing to load config is one of the few areas where we'll do a panic instead of error handling
-	if ConfigPath == "" {
-		ConfigPath = DefaultConfigFileName
+	// keep the parsed node tree so that Validate can report line/column positions
+	conf = &KodbConfig{filePath: absPath}
+	err = yaml.Unmarshal(yamlFile, &conf.node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", absPath, err)
 	}
-	absPath, pErr := filepath.Abs(ConfigPath)
-	if pErr != nil {
-		log.Panic(fmt.Errorf("failed to parse path for config: %v", pErr))
+	err = conf.node.Decode(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", absPath, err)
 	}
 
-	yamlFile, err := os.ReadFile(absPath)
+	// precedence: base file < profile < KODB_* environment variables < CLI arguments (applied by main)
+	profileName := profile
+	if profileName == "" {
+		profileName = os.Getenv(envProfile)
+	}
+	if profileName != "" {
+		profileNode, ok := conf.Profiles[profileName]
+		if !ok {
+			return nil, fmt.Errorf("profile %s is not defined in %s", profileName, absPath)
+		}
+		conf.profileNode = &profileNode
+		err = profileNode.Decode(conf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse profile %s: %v", profileName, err)
+		}
+	}
+
+	err = applyEnvOverrides(conf)
 	if err != nil {
-		log.Panic(fmt.Errorf("failed to read config.yaml: %v", err))
+		return nil, err
 	}
 
-	err = yaml.Unmarshal(yamlFile, &configInstance)
+	err = resolveSecrets(conf)
 	if err != nil {
-		log.Panic(fmt.Errorf("failed to parse config.yaml: %v", err))
+		return nil, err
 	}
 
+	return conf, nil
 }
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// envPrefix is prepended to the upper-cased yaml path of every configuration property, for example:
+	// KODB_DATABASECONFIG_HOST, KODB_GENCONFIG_SCHEMADIR, KODB_GENCONFIG_GAMEDB_0_LOGINS_0_PASS,
+	// KODB_DATABASECONFIG_PARAMS_ENCRYPT
+	envPrefix = "KODB"
+
+	// envProfile selects a profile when -profile isn't used
+	envProfile = "KODB_PROFILE"
+)
+
+// envRefReg matches a value that is entirely an environment variable reference: ${NAME}
+var envRefReg = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// applyEnvOverrides walks the configuration and replaces any property that has a matching KODB_* environment variable.
+// List entries are addressed by index and must already exist in the file; []string values are comma separated.  Map
+// entries (params, templateVars) are addressed by key: a key matching an existing entry, ignoring case, replaces it,
+// and any other key is added as written in the variable name.
+func applyEnvOverrides(conf *KodbConfig) error {
+	return applyEnvToValue(reflect.ValueOf(conf).Elem(), envPrefix)
+}
+
+// applyEnvToValue recursively applies environment overrides to v, where name is the variable name for v
+func applyEnvToValue(v reflect.Value, name string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if tag == "" || tag == "-" || !field.IsExported() {
+				continue
+			}
+			err := applyEnvToValue(v.Field(i), name+"_"+strings.ToUpper(tag))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			if envVal, ok := os.LookupEnv(name); ok {
+				parts := []string{}
+				for _, part := range strings.Split(envVal, ",") {
+					if part = strings.TrimSpace(part); part != "" {
+						parts = append(parts, part)
+					}
+				}
+				v.Set(reflect.ValueOf(parts))
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			err := applyEnvToValue(v.Index(i), fmt.Sprintf("%s_%d", name, i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		keyPrefix := name + "_"
+		for _, env := range os.Environ() {
+			envName, envVal, _ := strings.Cut(env, "=")
+			key, ok := strings.CutPrefix(envName, keyPrefix)
+			if !ok || key == "" {
+				continue
+			}
+			for _, existing := range v.MapKeys() {
+				if strings.EqualFold(existing.String(), key) {
+					key = existing.String()
+					break
+				}
+			}
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			v.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(envVal))
+		}
+		return nil
+	}
+
+	envVal, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(envVal)
+	case reflect.Int:
+		intVal, err := strconv.Atoi(envVal)
+		if err != nil {
+			return fmt.Errorf("environment variable %s: %v", name, err)
+		}
+		v.SetInt(int64(intVal))
+	case reflect.Bool:
+		boolVal, err := strconv.ParseBool(envVal)
+		if err != nil {
+			return fmt.Errorf("environment variable %s: %v", name, err)
+		}
+		v.SetBool(boolVal)
+	}
+
+	return nil
+}
+
+// resolveSecrets loads password/pass values from their file or ${ENV_VAR} references
+func resolveSecrets(conf *KodbConfig) (err error) {
+	conf.DatabaseConfig.Password, err = ResolveSecret(conf.DatabaseConfig.Password, conf.DatabaseConfig.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("databaseConfig.password: %v", err)
+	}
+
+	for i := range conf.GenConfig.GameDbs {
+		for j := range conf.GenConfig.GameDbs[i].Logins {
+			login := &conf.GenConfig.GameDbs[i].Logins[j]
+			login.Pass, err = ResolveSecret(login.Pass, login.PassFile)
+			if err != nil {
+				return fmt.Errorf("genConfig.gameDb[%d].logins[%d].pass: %v", i, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResolveSecret returns the contents of secretFile if set, otherwise the expanded ${ENV_VAR} reference in value,
+// otherwise value as-is
+func ResolveSecret(value string, secretFile string) (string, error) {
+	if secretFile != "" {
+		fileBytes, err := os.ReadFile(secretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %v", err)
+		}
+		// editors and `echo` like to add a trailing newline
+		return strings.TrimRight(string(fileBytes), "\r\n"), nil
+	}
+
+	if match := envRefReg.FindStringSubmatch(value); match != nil {
+		envVal, ok := os.LookupEnv(match[1])
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return envVal, nil
+	}
+
+	return value, nil
+}
+
+// ReadSecret prompts for a secret on stdin.  Input is not echoed when stdin is a terminal; otherwise a single line is
+// read so that secrets can be piped in.
+func ReadSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		secretBytes, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		return string(secretBytes), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read secret: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
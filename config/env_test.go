@@ -0,0 +1,80 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("KODB_TEST_SECRET", "from env")
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(secretFile, []byte("from file\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		value      string
+		secretFile string
+		want       string
+		wantErr    bool
+	}{
+		{name: "plain", value: "plain", want: "plain"},
+		{name: "env reference", value: "${KODB_TEST_SECRET}", want: "from env"},
+		{name: "unset env reference", value: "${KODB_TEST_UNSET}", wantErr: true},
+		{name: "file over value", value: "${KODB_TEST_SECRET}", secretFile: secretFile, want: "from file"},
+		{name: "missing file", secretFile: filepath.Join(t.TempDir(), "missing"), wantErr: true},
+		{name: "not a whole reference", value: "pre${KODB_TEST_SECRET}", want: "pre${KODB_TEST_SECRET}"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveSecret(test.value, test.secretFile)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ResolveSecret(%q, %q) error = %v, wantErr %v", test.value, test.secretFile, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ResolveSecret(%q, %q) = %q, want %q", test.value, test.secretFile, got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	t.Setenv("KODB_DATABASECONFIG_HOST", "db.local")
+	t.Setenv("KODB_DATABASECONFIG_PORT", "1500")
+	t.Setenv("KODB_GENCONFIG_GAMEDB_0_LOGINS_0_PASS", "secret")
+	t.Setenv("KODB_DATABASECONFIG_PARAMS_ENCRYPT", "true")
+	t.Setenv("KODB_DATABASECONFIG_PARAMS_ApplicationIntent", "ReadOnly")
+	t.Setenv("KODB_GENCONFIG_GAMEDB_0_TEMPLATEVARS_motd", "hello")
+
+	conf := &KodbConfig{}
+	conf.DatabaseConfig.Host = "localhost"
+	conf.DatabaseConfig.Params = map[string]string{"encrypt": "false"}
+	conf.GenConfig.GameDbs = []GenDbConfig{{Name: "KN_online", Logins: []LoginConfig{{Name: "knight", Pass: "old"}}}}
+	err := applyEnvOverrides(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.DatabaseConfig.Host != "db.local" || conf.DatabaseConfig.Port != 1500 {
+		t.Errorf("databaseConfig = %s:%d, want db.local:1500", conf.DatabaseConfig.Host, conf.DatabaseConfig.Port)
+	}
+	if pass := conf.GenConfig.GameDbs[0].Logins[0].Pass; pass != "secret" {
+		t.Errorf("login pass = %q, want secret", pass)
+	}
+	// an existing key is replaced regardless of case, and a new one is added as written
+	wantParams := map[string]string{"encrypt": "true", "ApplicationIntent": "ReadOnly"}
+	if !maps.Equal(conf.DatabaseConfig.Params, wantParams) {
+		t.Errorf("params = %v, want %v", conf.DatabaseConfig.Params, wantParams)
+	}
+	if motd := conf.GenConfig.GameDbs[0].TemplateVars["motd"]; motd != "hello" {
+		t.Errorf("gameDb templateVars motd = %q, want hello", motd)
+	}
+
+	t.Setenv("KODB_DATABASECONFIG_PORT", "not a port")
+	if err = applyEnvOverrides(conf); err == nil {
+		t.Error("applyEnvOverrides accepted a non-numeric port")
+	}
+}
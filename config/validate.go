@@ -0,0 +1,404 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultDbSchema always exists in an MSSQL database, so users may reference it without listing it in schemas
+	defaultDbSchema = "dbo"
+
+	minPort = 1
+	maxPort = 65535
+
+	// minCompatibilityLevel is SQL Server 2008's; older levels can't be set on supported servers
+	minCompatibilityLevel = 100
+)
+
+// grantPermissions are the permissions a GrantConfig may grant
+var grantPermissions = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "EXECUTE", "REFERENCES", "ALTER", "CONTROL",
+	"VIEW DEFINITION", "TAKE OWNERSHIP", "VIEW CHANGE TRACKING"}
+
+var (
+	collationRegex         = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	fileSizeRegex          = regexp.MustCompile(`(?i)^[0-9]+(KB|MB|GB|TB)?$`)
+	fileGrowthPercentRegex = regexp.MustCompile(`^[0-9]+%$`)
+)
+
+// ValidationError is a single configuration problem and where it was found
+type ValidationError struct {
+	Path    string // yaml path of the property, e.g. genConfig.gameDb[0].name
+	Line    int    // 0 if the value didn't come from a file (environment variable, CLI argument, or missing)
+	Column  int
+	Message string
+}
+
+func (this ValidationError) Error() string {
+	if this.Line > 0 {
+		return fmt.Sprintf("line %d, column %d: %s: %s", this.Line, this.Column, this.Path, this.Message)
+	}
+	return fmt.Sprintf("%s: %s", this.Path, this.Message)
+}
+
+// ValidationErrors is the list of every problem found by KodbConfig.Validate
+type ValidationErrors []ValidationError
+
+func (this ValidationErrors) Error() string {
+	lines := make([]string, 0, len(this))
+	for i := range this {
+		lines = append(lines, this[i].Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the loaded configuration for problems that would otherwise surface part way through a job.
+// Every problem is collected and returned as ValidationErrors; returns nil if the configuration is valid.
+func (this *KodbConfig) Validate() error {
+	errs := ValidationErrors{}
+	add := func(message string, path ...any) {
+		line, column := this.position(path...)
+		errs = append(errs, ValidationError{
+			Path:    formatPath(path...),
+			Line:    line,
+			Column:  column,
+			Message: message,
+		})
+	}
+
+	// databaseConfig
+	if strings.TrimSpace(this.DatabaseConfig.Host) == "" {
+		add("host is empty", "databaseConfig", "host")
+	}
+	if this.DatabaseConfig.Port < minPort || this.DatabaseConfig.Port > maxPort {
+		add(fmt.Sprintf("port %d is out of range [%d-%d]", this.DatabaseConfig.Port, minPort, maxPort), "databaseConfig", "port")
+	}
+
+	switch this.DatabaseConfig.AuthMode {
+	case "", AuthModeWindows:
+	case AuthModeSql:
+		if this.DatabaseConfig.User == "" {
+			add("user is required for sql authentication", "databaseConfig", "user")
+		}
+	case AuthModeAzure:
+		if this.DatabaseConfig.Azure.FedAuth == "" {
+			add("fedAuth is required for azure authentication", "databaseConfig", "azure", "fedAuth")
+		}
+	case AuthModeKerberos:
+		krb := this.DatabaseConfig.Kerberos
+		if krb.KeytabFile == "" && krb.CredCacheFile == "" {
+			add("keytabFile or credCacheFile is required for krb5 authentication", "databaseConfig", "kerberos")
+		}
+		if krb.KeytabFile != "" && (krb.Realm == "" || krb.ConfigFile == "") {
+			add("realm and configFile are required when using keytabFile", "databaseConfig", "kerberos")
+		}
+		for _, file := range []struct{ key, path string }{{"configFile", krb.ConfigFile}, {"keytabFile", krb.KeytabFile}, {"credCacheFile", krb.CredCacheFile}} {
+			if _, err := os.Stat(file.path); file.path != "" && err != nil {
+				add(fmt.Sprintf("%s %s does not exist", file.key, file.path), "databaseConfig", "kerberos", file.key)
+			}
+		}
+	default:
+		add(fmt.Sprintf("unknown authMode %s, expected one of: %s, %s, %s, %s", this.DatabaseConfig.AuthMode, AuthModeSql, AuthModeWindows, AuthModeAzure, AuthModeKerberos), "databaseConfig", "authMode")
+	}
+
+	switch strings.ToLower(this.DatabaseConfig.Encrypt) {
+	case "", "true", "false", "strict", "disable":
+	default:
+		add(fmt.Sprintf("unknown encrypt value %s, expected one of: true, false, strict, disable", this.DatabaseConfig.Encrypt), "databaseConfig", "encrypt")
+	}
+	if this.DatabaseConfig.Certificate != "" {
+		if _, err := os.Stat(this.DatabaseConfig.Certificate); err != nil {
+			add(fmt.Sprintf("certificate %s does not exist", this.DatabaseConfig.Certificate), "databaseConfig", "certificate")
+		}
+	}
+	for _, opt := range []struct {
+		key string
+		val int
+	}{{"dialTimeout", this.DatabaseConfig.DialTimeout}, {"connectionTimeout", this.DatabaseConfig.ConnectionTimeout}, {"packetSize", this.DatabaseConfig.PacketSize}} {
+		if opt.val < 0 {
+			add(fmt.Sprintf("%s cannot be negative", opt.key), "databaseConfig", opt.key)
+		}
+	}
+	if this.DatabaseConfig.FailoverPort != 0 && (this.DatabaseConfig.FailoverPort < minPort || this.DatabaseConfig.FailoverPort > maxPort) {
+		add(fmt.Sprintf("failoverPort %d is out of range [%d-%d]", this.DatabaseConfig.FailoverPort, minPort, maxPort), "databaseConfig", "failoverPort")
+	}
+
+	// genConfig
+	if this.GenConfig.SchemaDir == "" {
+		add("schemaDir is empty", "genConfig", "schemaDir")
+	} else if stat, err := os.Stat(this.GenConfig.SchemaDir); err != nil {
+		add(fmt.Sprintf("schemaDir %s does not exist; run: git submodule update --init --recursive --remote", this.GenConfig.SchemaDir), "genConfig", "schemaDir")
+	} else if !stat.IsDir() {
+		add(fmt.Sprintf("schemaDir %s is not a directory", this.GenConfig.SchemaDir), "genConfig", "schemaDir")
+	}
+
+	if len(this.GenConfig.GameDbs) == 0 {
+		add("no databases configured", "genConfig", "gameDb")
+	}
+
+	dbNames := map[string]int{}
+	loginNames := map[string]string{}
+	for i, db := range this.GenConfig.GameDbs {
+		if strings.TrimSpace(db.Name) == "" {
+			add("name is empty", "genConfig", "gameDb", i, "name")
+		} else if prev, ok := dbNames[strings.ToLower(db.Name)]; ok {
+			add(fmt.Sprintf("database %s is already configured by gameDb[%d]", db.Name, prev), "genConfig", "gameDb", i, "name")
+		} else {
+			dbNames[strings.ToLower(db.Name)] = i
+		}
+
+		for j, schema := range db.Schemas {
+			if strings.TrimSpace(schema) == "" {
+				add("schema name is empty", "genConfig", "gameDb", i, "schemas", j)
+			}
+		}
+
+		for j, login := range db.Logins {
+			if strings.TrimSpace(login.Name) == "" {
+				add("name is empty", "genConfig", "gameDb", i, "logins", j, "name")
+				continue
+			}
+			// logins are server-wide and their default database is set by the db they're listed under
+			if prevDb, ok := loginNames[strings.ToLower(login.Name)]; ok {
+				add(fmt.Sprintf("login %s is already configured for database %s; a login should only be listed under one database", login.Name, prevDb), "genConfig", "gameDb", i, "logins", j, "name")
+			} else {
+				loginNames[strings.ToLower(login.Name)] = db.Name
+			}
+		}
+
+		this.validateDatabaseOptions(db.Create, add, "genConfig", "gameDb", i, "create")
+
+		userNames := map[string]bool{}
+		for j, user := range db.Users {
+			if strings.TrimSpace(user.Name) == "" {
+				add("name is empty", "genConfig", "gameDb", i, "users", j, "name")
+			} else if userNames[strings.ToLower(user.Name)] {
+				add(fmt.Sprintf("user %s is configured more than once for database %s", user.Name, db.Name), "genConfig", "gameDb", i, "users", j, "name")
+			} else {
+				userNames[strings.ToLower(user.Name)] = true
+			}
+
+			if user.Schema == "" {
+				add("schema is empty", "genConfig", "gameDb", i, "users", j, "schema")
+			} else if !strings.EqualFold(user.Schema, defaultDbSchema) && !containsFold(db.Schemas, user.Schema) {
+				add(fmt.Sprintf("schema %s is not listed in gameDb[%d].schemas", user.Schema, i), "genConfig", "gameDb", i, "users", j, "schema")
+			}
+		}
+
+		this.validateRoles(db, add, "genConfig", "gameDb", i, "roles")
+	}
+
+	// safety
+	for _, hosts := range []struct {
+		key      string
+		patterns []string
+	}{{"allowedHosts", this.Safety.AllowedHosts}, {"deniedHosts", this.Safety.DeniedHosts}} {
+		for i, pattern := range hosts.patterns {
+			if _, err := path.Match(pattern, ""); err != nil || strings.TrimSpace(pattern) == "" {
+				add(fmt.Sprintf("invalid host pattern %q", pattern), "safety", hosts.key, i)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateDatabaseOptions checks the create options of a database; path is the yaml path of opts
+func (this *KodbConfig) validateDatabaseOptions(opts DatabaseOptions, add func(message string, path ...any), path ...any) {
+	at := func(key ...any) []any {
+		return append(append([]any{}, path...), key...)
+	}
+	if opts.Collation != "" && !collationRegex.MatchString(opts.Collation) {
+		add(fmt.Sprintf("invalid collation %s", opts.Collation), at("collation")...)
+	}
+	switch strings.ToUpper(opts.RecoveryModel) {
+	case "", "SIMPLE", "FULL", "BULK_LOGGED":
+	default:
+		add(fmt.Sprintf("unknown recoveryModel %s, expected one of: SIMPLE, FULL, BULK_LOGGED", opts.RecoveryModel), at("recoveryModel")...)
+	}
+	if opts.CompatibilityLevel != 0 && (opts.CompatibilityLevel < minCompatibilityLevel || opts.CompatibilityLevel%10 != 0) {
+		add(fmt.Sprintf("invalid compatibilityLevel %d, expected e.g. 150 or 160", opts.CompatibilityLevel), at("compatibilityLevel")...)
+	}
+	for _, file := range []struct {
+		key  string
+		opts DatabaseFileOptions
+	}{{"dataFile", opts.DataFile}, {"logFile", opts.LogFile}} {
+		if file.opts.Path == "" && file.opts != (DatabaseFileOptions{}) {
+			add("path is required when size, maxSize, or growth is set", at(file.key, "path")...)
+		}
+		if file.opts.Size != "" && !fileSizeRegex.MatchString(file.opts.Size) {
+			add(fmt.Sprintf("invalid size %s, expected e.g. 512MB", file.opts.Size), at(file.key, "size")...)
+		}
+		if file.opts.MaxSize != "" && !strings.EqualFold(file.opts.MaxSize, "UNLIMITED") && !fileSizeRegex.MatchString(file.opts.MaxSize) {
+			add(fmt.Sprintf("invalid maxSize %s, expected e.g. 10GB or UNLIMITED", file.opts.MaxSize), at(file.key, "maxSize")...)
+		}
+		if file.opts.Growth != "" && !fileSizeRegex.MatchString(file.opts.Growth) && !fileGrowthPercentRegex.MatchString(file.opts.Growth) {
+			add(fmt.Sprintf("invalid growth %s, expected e.g. 64MB or 10%%", file.opts.Growth), at(file.key, "growth")...)
+		}
+	}
+}
+
+// validateRoles checks the roles of a database; path is the yaml path of its roles
+func (this *KodbConfig) validateRoles(db GenDbConfig, add func(message string, path ...any), path ...any) {
+	at := func(key ...any) []any {
+		return append(append([]any{}, path...), key...)
+	}
+	principals := map[string]bool{}
+	for _, user := range db.Users {
+		principals[strings.ToLower(user.Name)] = true
+	}
+	for _, role := range db.Roles {
+		principals[strings.ToLower(role.Name)] = true
+	}
+
+	roleNames := map[string]bool{}
+	for i, role := range db.Roles {
+		if strings.TrimSpace(role.Name) == "" {
+			add("name is empty", at(i, "name")...)
+		} else if roleNames[strings.ToLower(role.Name)] {
+			add(fmt.Sprintf("role %s is configured more than once for database %s", role.Name, db.Name), at(i, "name")...)
+		} else if containsUser(db.Users, role.Name) {
+			add(fmt.Sprintf("role %s has the same name as a user", role.Name), at(i, "name")...)
+		} else {
+			roleNames[strings.ToLower(role.Name)] = true
+		}
+
+		for j, member := range role.Members {
+			if strings.EqualFold(member, role.Name) {
+				add(fmt.Sprintf("role %s can't be a member of itself", role.Name), at(i, "members", j)...)
+			} else if !principals[strings.ToLower(member)] {
+				add(fmt.Sprintf("member %s is not a user or role of database %s", member, db.Name), at(i, "members", j)...)
+			}
+		}
+
+		if role.IsFixed() && len(role.Grants) > 0 {
+			add(fmt.Sprintf("%s is a fixed role; permissions can't be granted to it", role.Name), at(i, "grants")...)
+			continue
+		}
+		for j, grant := range role.Grants {
+			if len(grant.Permissions) == 0 {
+				add("no permissions", at(i, "grants", j, "permissions")...)
+			}
+			for k, permission := range grant.Permissions {
+				if !containsFold(grantPermissions, strings.Join(strings.Fields(permission), " ")) {
+					add(fmt.Sprintf("unknown permission %s, expected one of: %s", permission, strings.Join(grantPermissions, ", ")), at(i, "grants", j, "permissions", k)...)
+				}
+			}
+
+			switch {
+			case len(grant.Objects) > 0 && (grant.ObjectType != "" || grant.Schema != ""):
+				add("objects can't be combined with objectType or schema", at(i, "grants", j)...)
+			case len(grant.Objects) == 0 && grant.ObjectType == "" && grant.Schema == "":
+				add("one of objects, objectType, or schema is required", at(i, "grants", j)...)
+			}
+			if grant.ObjectType != "" && !strings.EqualFold(grant.ObjectType, GrantViews) && !strings.EqualFold(grant.ObjectType, GrantProcedures) {
+				add(fmt.Sprintf("unknown objectType %s, expected %s or %s", grant.ObjectType, GrantViews, GrantProcedures), at(i, "grants", j, "objectType")...)
+			}
+			if grant.Schema != "" && !strings.EqualFold(grant.Schema, defaultDbSchema) && !containsFold(db.Schemas, grant.Schema) {
+				add(fmt.Sprintf("schema %s is not listed in the database's schemas", grant.Schema), at(i, "grants", j, "schema")...)
+			}
+			for k, object := range grant.Objects {
+				if strings.TrimSpace(object) == "" {
+					add("object name is empty", at(i, "grants", j, "objects", k)...)
+				}
+			}
+		}
+	}
+}
+
+// containsUser returns true if users has a user named name, ignoring case
+func containsUser(users []UserConfig, name string) bool {
+	for i := range users {
+		if strings.EqualFold(users[i].Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilePath returns the absolute path the configuration was loaded from
+func (this *KodbConfig) FilePath() string {
+	return this.filePath
+}
+
+// position returns the line and column of the value at path.  The selected profile is checked first since its values
+// win; if the path isn't found the position of the closest parent is used.
+func (this *KodbConfig) position(path ...any) (line int, column int) {
+	if this.profileNode != nil {
+		if node, depth := findNode(this.profileNode, path...); node != nil && depth == len(path) {
+			return node.Line, node.Column
+		}
+	}
+
+	if len(this.node.Content) == 0 {
+		return 0, 0
+	}
+	node, _ := findNode(this.node.Content[0], path...)
+	if node == nil {
+		return 0, 0
+	}
+	return node.Line, node.Column
+}
+
+// findNode walks path (string keys and int indexes) from root and returns the deepest node found and how many path
+// elements were matched
+func findNode(root *yaml.Node, path ...any) (node *yaml.Node, depth int) {
+	node = root
+	for depth = 0; depth < len(path); depth++ {
+		var next *yaml.Node
+		switch key := path[depth].(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == key {
+						next = node.Content[i+1]
+						break
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return node, depth
+		}
+		node = next
+	}
+	return node, depth
+}
+
+// formatPath returns path in the form genConfig.gameDb[0].name
+func formatPath(path ...any) string {
+	sb := strings.Builder{}
+	for i := range path {
+		switch key := path[i].(type) {
+		case string:
+			if i > 0 {
+				sb.WriteString(".")
+			}
+			sb.WriteString(key)
+		case int:
+			sb.WriteString(fmt.Sprintf("[%d]", key))
+		}
+	}
+	return sb.String()
+}
+
+// containsFold returns true if list contains val, ignoring case
+func containsFold(list []string, val string) bool {
+	for i := range list {
+		if strings.EqualFold(list[i], val) {
+			return true
+		}
+	}
+	return false
+}
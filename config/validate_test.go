@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schemaDir := t.TempDir()
+	configYaml := fmt.Sprintf(`databaseConfig:
+  port: 99999
+genConfig:
+  schemaDir: %s
+  gameDb:
+    - name: KN_online
+`, schemaDir)
+	path := filepath.Join(t.TempDir(), DefaultConfigFileName)
+	err := os.WriteFile(path, []byte(configYaml), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validationErrs ValidationErrors
+	if !errors.As(conf.Validate(), &validationErrs) {
+		t.Fatalf("Validate() error = %v, want ValidationErrors", conf.Validate())
+	}
+
+	want := []struct {
+		path string
+		line int
+	}{
+		{path: "databaseConfig.host", line: 2}, // a missing key is reported where its parent mapping starts
+		{path: "databaseConfig.port", line: 2},
+	}
+	if len(validationErrs) != len(want) {
+		t.Fatalf("Validate() = %v, want %d errors", validationErrs, len(want))
+	}
+	for i := range want {
+		if validationErrs[i].Path != want[i].path || validationErrs[i].Line != want[i].line {
+			t.Errorf("error %d = %s at line %d, want %s at line %d", i, validationErrs[i].Path, validationErrs[i].Line, want[i].path, want[i].line)
+		}
+	}
+
+	conf.DatabaseConfig.Host = "localhost"
+	conf.DatabaseConfig.Port = 1433
+	if err = conf.Validate(); err != nil {
+		t.Errorf("Validate() after fixing = %v", err)
+	}
+}
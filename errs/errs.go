@@ -0,0 +1,169 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Process exit codes.  Each error type in this package maps to its own code so that scripts and CI can tell failures
+// apart without parsing the output.
+const (
+	ExitOk         = 0
+	ExitUnknown    = 1 // errors that aren't one of the types below, including recovered panics
+	ExitArgs       = 2 // invalid command-line arguments
+	ExitConfig     = 3
+	ExitConnection = 4
+	ExitTemplate   = 5
+	ExitSqlBatch   = 6
+	ExitFileSystem = 7
+	ExitValidation = 8   // lint, model verification, or round trip found problems
+	ExitSafety     = 9   // a destructive job was refused by the safety checks, or wasn't confirmed
+	ExitCancelled  = 130 // Ctrl-C, SIGTERM, or -timeout/-phaseTimeout; matches the shell convention for SIGINT
+)
+
+// exitCoder is implemented by every error type in this package
+type exitCoder interface {
+	ExitCode() int
+}
+
+// ExitCode returns the process exit code for err: ExitOk for nil, ExitCancelled for context cancellation, the code of
+// the first typed error in the chain, ExitFileSystem for untyped *fs.PathError, otherwise ExitUnknown
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOk
+	}
+	// cancellation surfaces as whichever error the interrupted query returned, so check it first
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ExitCancelled
+	}
+	var coder exitCoder
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return ExitFileSystem
+	}
+	return ExitUnknown
+}
+
+// ArgsError is returned for invalid command-line arguments
+type ArgsError struct {
+	Err error
+}
+
+func (this *ArgsError) Error() string {
+	return fmt.Sprintf("arguments error: %v", this.Err)
+}
+
+func (this *ArgsError) Unwrap() error { return this.Err }
+func (this *ArgsError) ExitCode() int { return ExitArgs }
+
+// ConfigError is returned when the configuration file can't be loaded or is invalid
+type ConfigError struct {
+	File string
+	Err  error
+}
+
+func (this *ConfigError) Error() string {
+	if this.File == "" {
+		return fmt.Sprintf("config error: %v", this.Err)
+	}
+	return fmt.Sprintf("config error in %s: %v", this.File, this.Err)
+}
+
+func (this *ConfigError) Unwrap() error { return this.Err }
+func (this *ConfigError) ExitCode() int { return ExitConfig }
+
+// ConnectionError is returned when a connection to the database server can't be opened
+type ConnectionError struct {
+	Host     string
+	Database string
+	Err      error
+}
+
+func (this *ConnectionError) Error() string {
+	return fmt.Sprintf("failed to connect to %s on %s: %v", this.Database, this.Host, this.Err)
+}
+
+func (this *ConnectionError) Unwrap() error { return this.Err }
+func (this *ConnectionError) ExitCode() int { return ExitConnection }
+
+// TemplateError is returned when an OpenKO-db/Templates file can't be read or rendered
+type TemplateError struct {
+	File string
+	Err  error
+}
+
+func (this *TemplateError) Error() string {
+	return fmt.Sprintf("template %s: %v", this.File, this.Err)
+}
+
+func (this *TemplateError) Unwrap() error { return this.Err }
+func (this *TemplateError) ExitCode() int { return ExitTemplate }
+
+// SqlBatchError is returned when a batch of an imported script fails
+type SqlBatchError struct {
+	File    string // script file or name
+	Batch   int    // 1-based index of the failed batch
+	Batches int    // number of batches in the script
+	Number  int32  // SQL Server error number; 0 if the error didn't come from the server
+	Err     error
+}
+
+func (this *SqlBatchError) Error() string {
+	if this.Number != 0 {
+		return fmt.Sprintf("batch [%d/%d] in %s failed with error %d: %v", this.Batch, this.Batches, this.File, this.Number, this.Err)
+	}
+	return fmt.Sprintf("batch [%d/%d] in %s failed: %v", this.Batch, this.Batches, this.File, this.Err)
+}
+
+func (this *SqlBatchError) Unwrap() error { return this.Err }
+func (this *SqlBatchError) ExitCode() int { return ExitSqlBatch }
+
+// FileSystemError is returned when reading or writing an OpenKO-db file or directory fails
+type FileSystemError struct {
+	Op   string // e.g. read, write, mkdir
+	Path string
+	Err  error
+}
+
+func (this *FileSystemError) Error() string {
+	return fmt.Sprintf("failed to %s %s: %v", this.Op, this.Path, this.Err)
+}
+
+func (this *FileSystemError) Unwrap() error { return this.Err }
+func (this *FileSystemError) ExitCode() int { return ExitFileSystem }
+
+// ValidationError is returned when a check (lint, model verification, round trip) completes but finds problems
+type ValidationError struct {
+	Check    string // name of the check, e.g. lintSchema
+	Problems int
+	Err      error
+}
+
+func (this *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", this.Check, this.Err)
+}
+
+func (this *ValidationError) Unwrap() error { return this.Err }
+func (this *ValidationError) ExitCode() int { return ExitValidation }
+
+// SafetyError is returned when a destructive job is refused by the safety checks, or isn't confirmed
+type SafetyError struct {
+	Host     string
+	Database string // empty when the refusal applies to every database
+	Err      error
+}
+
+func (this *SafetyError) Error() string {
+	if this.Database == "" {
+		return fmt.Sprintf("refused on %s: %v", this.Host, this.Err)
+	}
+	return fmt.Sprintf("refused for %s on %s: %v", this.Database, this.Host, this.Err)
+}
+
+func (this *SafetyError) Unwrap() error { return this.Err }
+func (this *SafetyError) ExitCode() int { return ExitSafety }
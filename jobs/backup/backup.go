@@ -0,0 +1,331 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/jobs/importDb"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"kodb-util/safety"
+	"strings"
+	"time"
+)
+
+const (
+	// Latest is the -restore value that restores the newest backup of each database
+	Latest = "latest"
+
+	// 1. database name 2. timestamp
+	backupFileNameFmt = "%s_%s.bak"
+
+	// backupTimestampFmt is sortable, and valid in Windows and Linux file names
+	backupTimestampFmt = "20060102-150405"
+
+	// 1. database name
+	countRowsSqlFmt = `SELECT COALESCE(SUM(p.[rows]), 0)
+FROM [%[1]s].[sys].[partitions] p
+INNER JOIN [%[1]s].[sys].[tables] t ON t.[object_id] = p.[object_id]
+WHERE p.[index_id] IN (0, 1) AND t.[is_ms_shipped] = 0`
+
+	defaultBackupDirSql = "SELECT CAST(SERVERPROPERTY('InstanceDefaultBackupPath') AS NVARCHAR(4000))"
+
+	// 1. database name 2. file literal.  COPY_ONLY leaves any scheduled backup chain alone
+	backupSqlFmt = "BACKUP DATABASE [%s] TO DISK = %s WITH COPY_ONLY, INIT, CHECKSUM, NAME = N'kodb-util'"
+
+	// latestBackupSql finds the newest full backup of a database in the server's backup history
+	latestBackupSql = `SELECT TOP 1 mf.[physical_device_name]
+FROM [msdb].[dbo].[backupset] bs
+INNER JOIN [msdb].[dbo].[backupmediafamily] mf ON mf.[media_set_id] = bs.[media_set_id]
+WHERE bs.[database_name] = ? AND bs.[type] = 'D'
+ORDER BY bs.[backup_finish_date] DESC`
+
+	// 1. file literal
+	restoreHeaderSqlFmt = "RESTORE HEADERONLY FROM DISK = %s"
+
+	// 1. database name
+	singleUserSqlFmt = "ALTER DATABASE [%s] SET SINGLE_USER WITH ROLLBACK IMMEDIATE"
+	multiUserSqlFmt  = "ALTER DATABASE [%s] SET MULTI_USER"
+
+	// 1. database name 2. file literal
+	restoreSqlFmt = "RESTORE DATABASE [%s] FROM DISK = %s WITH REPLACE, RECOVERY"
+
+	// orphanedUserSql returns 1 if the sql user has no matching login, e.g. because clean dropped it after the backup
+	orphanedUserSql = `SELECT COUNT(*)
+FROM [sys].[database_principals] dp
+LEFT JOIN [sys].[server_principals] sp ON sp.[sid] = dp.[sid]
+WHERE dp.[name] = ? AND dp.[type] = 'S' AND dp.[authentication_type] = 1 AND sp.[sid] IS NULL`
+
+	userExistsSql = "SELECT COUNT(*) FROM [sys].[database_principals] WHERE [name] = ?"
+
+	// 1. user name 2. login name
+	remapUserSqlFmt = "ALTER USER [%s] WITH LOGIN = [%s]"
+)
+
+// BeforeDrop backs up the driver's database if it exists and has data, unless backups are disabled in config.  Returns
+// the path of the backup file on the server, or "" if no backup was needed.
+func BeforeDrop(ctx context.Context, driver *mssql.MssqlDbDriver) (file string, err error) {
+	if driver.Config.GenConfig.Backup.IsDisabled {
+		logging.FromContext(ctx).InfoContext(ctx, "backup before clean is disabled", "db", driver.GenDbConfig.Name)
+		return "", nil
+	}
+
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return "", err
+	}
+	conn = conn.WithContext(ctx)
+
+	exists, err := mssql.DatabaseExists(ctx, conn, driver.GenDbConfig.Name)
+	if err != nil || !exists {
+		return "", err
+	}
+	var rows int64
+	err = conn.Raw(fmt.Sprintf(countRowsSqlFmt, driver.GenDbConfig.Name)).Scan(&rows).Error
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		logging.FromContext(ctx).InfoContext(ctx, "database has no data, skipping backup", "db", driver.GenDbConfig.Name)
+		return "", nil
+	}
+
+	return Backup(ctx, driver)
+}
+
+// Backup writes a full, copy-only backup of the driver's database to a timestamped file in the configured backup
+// directory and returns the file's path on the server
+func Backup(ctx context.Context, driver *mssql.MssqlDbDriver) (file string, err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return "", err
+	}
+	conn = conn.WithContext(ctx)
+
+	dir, err := getBackupDir(conn, driver.Config.GenConfig.Backup.Dir)
+	if err != nil {
+		return "", err
+	}
+	file = mssql.JoinServerPath(dir, fmt.Sprintf(backupFileNameFmt, driver.GenDbConfig.Name, time.Now().Format(backupTimestampFmt)))
+
+	logging.FromContext(ctx).InfoContext(ctx, "backing up database", "db", driver.GenDbConfig.Name, "file", file)
+	start := time.Now()
+	err = conn.Exec(fmt.Sprintf(backupSqlFmt, driver.GenDbConfig.Name, mssql.QuoteString(file))).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to back up %s to %s: %w", driver.GenDbConfig.Name, file, err)
+	}
+	report.FileWritten(ctx, file)
+	logging.FromContext(ctx).InfoContext(ctx, "database backed up", "db", driver.GenDbConfig.Name, "file", file, "elapsed", time.Since(start))
+	return file, nil
+}
+
+// Restore replaces the driver's database with the backup in file, a path on the server, a file name in the backup
+// directory, or Latest for the newest backup of the database.  A backup of a different database is skipped.  The
+// configured logins and users are then re-created, since clean drops the logins after the backup is taken.
+func Restore(ctx context.Context, driver *mssql.MssqlDbDriver, file string) (err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+
+	file, err = resolveBackupFile(conn, dbName, file, driver.Config.GenConfig.Backup.Dir)
+	if err != nil {
+		return err
+	}
+
+	// a backup of a different database is meant for one of the other configured databases
+	backupDbName, err := getBackupDatabaseName(conn, file)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(backupDbName, dbName) {
+		logging.FromContext(ctx).InfoContext(ctx, "backup is of a different database, skipping restore", "db", dbName, "file", file, "backupDb", backupDbName)
+		report.Skip(ctx, "restore", fmt.Sprintf("backup is of database %s", backupDbName))
+		return nil
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "restoring database", "db", dbName, "file", file)
+	start := time.Now()
+	exists, err := mssql.DatabaseExists(ctx, conn, dbName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = safety.CheckGuardedRows(ctx, driver, "restore")
+		if err != nil {
+			return err
+		}
+		// RESTORE needs exclusive access; open transactions are rolled back
+		err = conn.Exec(fmt.Sprintf(singleUserSqlFmt, dbName)).Error
+		if err != nil {
+			return err
+		}
+	}
+	err = conn.Exec(fmt.Sprintf(restoreSqlFmt, dbName, mssql.QuoteString(file))).Error
+	if err != nil {
+		if exists {
+			if muErr := conn.Exec(fmt.Sprintf(multiUserSqlFmt, dbName)).Error; muErr != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "failed to set database back to multi-user", "db", dbName, "error", muErr)
+			}
+		}
+		return fmt.Errorf("failed to restore %s from %s: %w", dbName, file, err)
+	}
+	// the backup may have been taken while the database was in single-user mode
+	err = conn.Exec(fmt.Sprintf(multiUserSqlFmt, dbName)).Error
+	if err != nil {
+		return err
+	}
+	report.FileRead(ctx, file)
+	logging.FromContext(ctx).InfoContext(ctx, "database restored", "db", dbName, "file", file, "elapsed", time.Since(start))
+
+	return restorePrincipals(ctx, driver)
+}
+
+// restorePrincipals creates the configured users and logins that don't exist, the same way import does, and maps
+// restored users to the logins of the same name
+func restorePrincipals(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	tx, err := driver.GetTx()
+	if err != nil {
+		return err
+	}
+	tx = tx.WithContext(ctx)
+	masterConn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+
+	userScripts := []importDb.Script{}
+	for i, user := range driver.GenDbConfig.Users {
+		var count int
+		err = tx.Raw(userExistsSql, user.Name).Scan(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		script := importDb.Script{Name: fmt.Sprintf(artifacts.ExportUserFileNameFmt, user.Name)}
+		script.Sql, err = artifacts.GetCreateUserScript(ctx, driver, i)
+		if err != nil {
+			return err
+		}
+		userScripts = append(userScripts, script)
+	}
+	if len(userScripts) > 0 {
+		sArgs := importDb.ScriptArgs{ProgressLabel: "creating users"}
+		err = importDb.RunScripts(ctx, driver, sArgs, userScripts...)
+		if err != nil {
+			return err
+		}
+	}
+
+	loginScripts := []importDb.Script{}
+	for i, login := range driver.GenDbConfig.Logins {
+		exists, err := mssql.LoginExists(ctx, masterConn, login.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		script := importDb.Script{Name: fmt.Sprintf(artifacts.ExportLoginFileNameFmt, login.Name)}
+		script.Sql, err = artifacts.GetCreateLoginScript(ctx, driver, i)
+		if err != nil {
+			return err
+		}
+		loginScripts = append(loginScripts, script)
+	}
+	if len(loginScripts) > 0 {
+		sArgs := importDb.ScriptArgs{ProgressLabel: "creating logins", IsUseDefaultSystemDb: true}
+		err = importDb.RunScripts(ctx, driver, sArgs, loginScripts...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// users restored from the backup still carry the SID of the login that was dropped
+	for _, user := range driver.GenDbConfig.Users {
+		exists, err := mssql.LoginExists(ctx, masterConn, user.Name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		var orphaned int
+		err = tx.Raw(orphanedUserSql, user.Name).Scan(&orphaned).Error
+		if err != nil {
+			return err
+		}
+		if orphaned == 0 {
+			continue
+		}
+		err = tx.Exec(fmt.Sprintf(remapUserSqlFmt, user.Name, user.Name)).Error
+		if err != nil {
+			return err
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "mapped restored user to login", "user", user.Name)
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "logins and users restored", "db", driver.GenDbConfig.Name, "users", len(userScripts), "logins", len(loginScripts))
+	return nil
+}
+
+// resolveBackupFile returns the server path of the backup to restore dbName from, where backupDir is the configured
+// backup directory
+func resolveBackupFile(conn *gorm.DB, dbName string, file string, backupDir string) (string, error) {
+	if strings.EqualFold(file, Latest) {
+		var latest string
+		err := conn.Raw(latestBackupSql, dbName).Scan(&latest).Error
+		if err != nil {
+			return "", err
+		}
+		if latest == "" {
+			return "", fmt.Errorf("no backups of %s found in the server's backup history", dbName)
+		}
+		return latest, nil
+	}
+	if strings.ContainsAny(file, `/\`) {
+		return file, nil
+	}
+	dir, err := getBackupDir(conn, backupDir)
+	if err != nil {
+		return "", err
+	}
+	return mssql.JoinServerPath(dir, file), nil
+}
+
+// getBackupDatabaseName returns the name of the database a backup file was taken of
+func getBackupDatabaseName(conn *gorm.DB, file string) (string, error) {
+	headers := []map[string]any{}
+	err := conn.Raw(fmt.Sprintf(restoreHeaderSqlFmt, mssql.QuoteString(file))).Scan(&headers).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup %s: %w", file, err)
+	}
+	if len(headers) == 0 {
+		return "", fmt.Errorf("backup %s is empty", file)
+	}
+	dbName, _ := headers[0]["DatabaseName"].(string)
+	return dbName, nil
+}
+
+// getBackupDir returns the configured backupDir, or the instance's default backup directory if it's blank
+func getBackupDir(conn *gorm.DB, backupDir string) (string, error) {
+	if backupDir != "" {
+		return backupDir, nil
+	}
+	var dir string
+	err := conn.Raw(defaultBackupDirSql).Scan(&dir).Error
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return "", &errs.ConfigError{Err: fmt.Errorf("the server has no default backup directory; set genConfig.backup.dir")}
+	}
+	return dir, nil
+}
@@ -3,44 +3,223 @@ package clean
 import (
 	"context"
 	"fmt"
+	"gorm.io/gorm"
+	"kodb-util/config"
+	"kodb-util/jobs/backup"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/report"
+	"kodb-util/safety"
 	"strings"
 )
 
 const (
-	dropUserSqlFmt = "DROP LOGIN [%s]"
-	dropDbSqlFmt   = "DROP DATABASE IF EXISTS [%s]"
+	dropLoginSqlFmt = "DROP LOGIN [%s]"
+	dropDbSqlFmt    = "DROP DATABASE [%s]"
+
+	// 1. database name.  Closes every other session so that the drop doesn't fail or wait on them
+	singleUserSqlFmt = "ALTER DATABASE [%s] SET SINGLE_USER WITH ROLLBACK IMMEDIATE"
+	multiUserSqlFmt  = "ALTER DATABASE [%s] SET MULTI_USER"
+
+	// a login can't be dropped while it's logged in, e.g. by a game server.  Only its sessions in the database being
+	// cleaned (parameters: login name, database name) are closed, before the database is dropped; sessions in other
+	// databases make the drop fail.
+	loginSessionsSql = "SELECT [session_id] FROM [sys].[dm_exec_sessions] WHERE [login_name] = ? AND [database_id] = DB_ID(?) AND [session_id] <> @@SPID"
+	killSqlFmt       = "KILL %d"
+
+	// mappedLoginsSqlFmt returns the logins that have a user in the database (1. database name), or use it as their
+	// default database (parameter).  dbo and the fixed principals (principal_id 1-4), system logins (##name##), and the
+	// login kodb-util is connected with are excluded.
+	mappedLoginsSqlFmt = `SELECT sp.[name]
+FROM [%[1]s].[sys].[database_principals] dp
+INNER JOIN [sys].[server_principals] sp ON sp.[sid] = dp.[sid]
+WHERE dp.[principal_id] > 4 AND sp.[type] IN ('S', 'U', 'G') AND sp.[name] NOT LIKE '##%%' AND sp.[name] <> SUSER_SNAME()
+UNION
+SELECT sp.[name]
+FROM [sys].[server_principals] sp
+WHERE sp.[default_database_name] = ? AND sp.[type] IN ('S', 'U', 'G') AND sp.[name] NOT LIKE '##%%' AND sp.[name] <> SUSER_SNAME()`
 )
 
-// Clean will remove any existing [schemaConfig.gameDb.name] database and [schemaConfig.gameDb.users] from an mssql instance
+// Clean will remove any existing [schemaConfig.gameDb.name] database and [schemaConfig.gameDb.logins] from an mssql
+// instance.  A database that has data is backed up first, unless genConfig.backup.isDisabled is set.  Logins that map
+// to the database but aren't configured are reported, and dropped if driver.Options.DropOrphanLogins is set.
 func Clean(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Clean --")
+	logging.FromContext(ctx).InfoContext(ctx, "cleaning database", "db", driver.GenDbConfig.Name)
+	err = safety.CheckGuardedRows(ctx, driver, "clean")
+	if err != nil {
+		return err
+	}
+	_, err = backup.BeforeDrop(ctx, driver)
+	if err != nil {
+		return fmt.Errorf("backup before clean failed; set genConfig.backup.isDisabled or use -noBackup to clean without one: %w", err)
+	}
+
 	conn, err := driver.GetMasterConnection()
 	if err != nil {
 		return err
 	}
+	conn = conn.WithContext(ctx)
 
-	fmt.Print(fmt.Sprintf("Dropping %s database... ", driver.GenDbConfig.Name))
-	err = conn.Exec(fmt.Sprintf(dropDbSqlFmt, driver.GenDbConfig.Name)).Error
+	// the database's users are gone once it's dropped, so find the logins they map to first
+	orphans, err := findOrphanLogins(ctx, conn, driver)
 	if err != nil {
 		return err
 	}
-	fmt.Println(" Done")
 
-	// If the users we're about to create exist in the system database, drop them
-	for _, user := range driver.GenDbConfig.Users {
-		fmt.Print(fmt.Sprintf("Dropping user %s... ", user.Name))
-		err = conn.Exec(fmt.Sprintf(dropUserSqlFmt, user.Name)).Error
+	dropLogins := []string{}
+	for _, login := range driver.GenDbConfig.Logins {
+		dropLogins = append(dropLogins, login.Name)
+	}
+	if driver.Options.DropOrphanLogins {
+		dropLogins = append(dropLogins, orphans...)
+	}
+	for _, name := range dropLogins {
+		err = closeLoginSessions(ctx, conn, name, driver.GenDbConfig.Name)
 		if err != nil {
-			// ignore failed drop error - user may not exist.
-			if !strings.HasPrefix(err.Error(), "mssql: Cannot drop the login") {
-				return err
-			}
-			fmt.Print(" Not found.")
-			err = nil
+			return err
+		}
+	}
+
+	err = DropDatabase(ctx, driver)
+	if err != nil {
+		return err
+	}
+
+	for _, login := range driver.GenDbConfig.Logins {
+		err = dropLogin(ctx, conn, login.Name)
+		if err != nil {
+			return err
 		}
-		fmt.Println(" Done")
 	}
 
-	return err
+	for _, orphan := range orphans {
+		if !driver.Options.DropOrphanLogins {
+			report.Warn(ctx, "login maps to the dropped database but isn't configured; use -dropOrphanLogins to drop it", "db", driver.GenDbConfig.Name, "login", orphan)
+			continue
+		}
+		err = dropLogin(ctx, conn, orphan)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DropDatabase removes the [schemaConfig.gameDb.name] database and its snapshots only; server-level logins are left
+// in place.  Other sessions using the database are closed and their transactions rolled back.
+func DropDatabase(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+
+	exists, err := mssql.DatabaseExists(ctx, conn, dbName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		logging.FromContext(ctx).InfoContext(ctx, "database not found", "db", dbName)
+		return nil
+	}
+
+	// a database can't be dropped while it has snapshots, and they're of no use without it
+	snapshotNames, err := mssql.SnapshotNames(ctx, conn, dbName)
+	if err != nil {
+		return err
+	}
+	for _, snapshotName := range snapshotNames {
+		err = conn.Exec(fmt.Sprintf(dropDbSqlFmt, snapshotName)).Error
+		if err != nil {
+			return err
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "dropped snapshot", "db", dbName, "snapshotDb", snapshotName)
+	}
+
+	err = conn.Exec(fmt.Sprintf(singleUserSqlFmt, dbName)).Error
+	if err != nil {
+		return err
+	}
+	err = conn.Exec(fmt.Sprintf(dropDbSqlFmt, dbName)).Error
+	if err != nil {
+		if muErr := conn.Exec(fmt.Sprintf(multiUserSqlFmt, dbName)).Error; muErr != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to set database back to multi-user", "db", dbName, "error", muErr)
+		}
+		return err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "dropped database", "db", dbName)
+	return nil
+}
+
+// closeLoginSessions closes the sessions of the login named name that are using the database dbName, e.g. a game
+// server's.  Its sessions in other databases are left alone.
+func closeLoginSessions(ctx context.Context, conn *gorm.DB, name string, dbName string) error {
+	sessionIds := []int{}
+	err := conn.Raw(loginSessionsSql, name, dbName).Scan(&sessionIds).Error
+	if err != nil {
+		return err
+	}
+	for _, sessionId := range sessionIds {
+		err = conn.Exec(fmt.Sprintf(killSqlFmt, sessionId)).Error
+		if err != nil {
+			return fmt.Errorf("failed to close session %d of login %s: %w", sessionId, name, err)
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "closed login session", "login", name, "db", dbName, "session", sessionId)
+	}
+	return nil
+}
+
+// dropLogin drops the login named name if it exists
+func dropLogin(ctx context.Context, conn *gorm.DB, name string) error {
+	exists, err := mssql.LoginExists(ctx, conn, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		logging.FromContext(ctx).InfoContext(ctx, "login not found", "login", name)
+		return nil
+	}
+
+	err = conn.Exec(fmt.Sprintf(dropLoginSqlFmt, name)).Error
+	if err != nil {
+		return fmt.Errorf("failed to drop login %s; it can't be dropped while it's logged in to another database: %w", name, err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "dropped login", "login", name)
+	return nil
+}
+
+// findOrphanLogins returns the logins that map to the driver's database but aren't configured for any database
+func findOrphanLogins(ctx context.Context, conn *gorm.DB, driver *mssql.MssqlDbDriver) (orphans []string, err error) {
+	dbName := driver.GenDbConfig.Name
+	exists, err := mssql.DatabaseExists(ctx, conn, dbName)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	mapped := []string{}
+	err = conn.Raw(fmt.Sprintf(mappedLoginsSqlFmt, dbName), dbName).Scan(&mapped).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range mapped {
+		if !isConfiguredLogin(driver, name) {
+			orphans = append(orphans, name)
+		}
+	}
+	return orphans, nil
+}
+
+// isConfiguredLogin returns true if name is one of the driver's logins, or a login configured for another database
+func isConfiguredLogin(driver *mssql.MssqlDbDriver, name string) bool {
+	dbConfigs := append([]config.GenDbConfig{driver.GenDbConfig}, driver.Config.GenConfig.GameDbs...)
+	for _, dbConfig := range dbConfigs {
+		for _, login := range dbConfig.Logins {
+			if strings.EqualFold(login.Name, name) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -1,13 +1,17 @@
 package export
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Open-KO/kodb-godef/enums/tsql"
 	"github.com/Open-KO/kodb-godef/jsonSchema"
-	"kodb-util/config"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/report"
 	"os"
 	"path/filepath"
 	"slices"
@@ -15,21 +19,9 @@ import (
 )
 
 const (
-	// jsonSchemaDir is the sub-directory we interact with for exports
-	jsonSchemaDir = "jsonSchema"
-
-	jsonSchemaProcedures = "procedures"
-
 	// getTableNamesSql pulls a list of all our gameDb table names (dbo schema only) from the INFORMATION_SCHEMA
 	getTableNamesSql = `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = 'dbo' and TABLE_TYPE = 'BASE TABLE'`
 
-	// 1. table name
-	// jsonSchemaNameFmt output format for jsonSchema file names
-	jsonSchemaNameFmt = "%s.json"
-
-	// jsonSchemaSearchPattern is the pattern used to load files from the jsonSchemaDir
-	jsonSchemaSearchPattern = "*.json"
-
 	// getColumnDefSqlFmt selects column definition information from the INFORMATION_SCHEMA that we use to sync/create jsonSchema database-based properties
 	getColumnDefSqlFmt = `SELECT
 	cols.COLUMN_NAME,
@@ -63,9 +55,6 @@ WHERE
 	getIndexColumnsSqlFmt = `SELECT [COLUMN_NAME]
 FROM [INFORMATION_SCHEMA].[CONSTRAINT_COLUMN_USAGE]
 WHERE [CONSTRAINT_NAME] = '%s'`
-
-	// todoMarker is stubbed into new jsonSchema definitions that will need to have codegen-specific properties manually set
-	todoMarker = "MANUAL_TODO"
 )
 
 // DbColumnDef binds to the result of the getColumnDefSqlFmt query, and is used to map this information into the jsonSchema
@@ -81,13 +70,14 @@ type DbColumnDef struct {
 }
 
 // JsonSchema reads table/column definitions from INFORMATION_SCHEMA and updates/creates jsonSchema definitions with the results
-func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Exporting jsonSchema --")
+func JsonSchema(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting jsonSchema", "db", driver.GenDbConfig.Name)
 
 	gormConn, err := driver.GetConnection()
 	if err != nil {
 		return err
 	}
+	gormConn = gormConn.WithContext(ctx)
 	tableNames := []string{}
 	err = gormConn.Raw(getTableNamesSql).Scan(&tableNames).Error
 	if err != nil {
@@ -97,10 +87,10 @@ func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
 		return fmt.Errorf("no results from INFORMATION_SCHEMA.TABLES")
 	}
 
-	jsonSchemaPath := filepath.Join(config.GetConfig().GenConfig.SchemaDir, jsonSchemaDir)
+	jsonSchemaPath := artifacts.JsonSchemaPath(driver.SchemaDir())
 	for i := range tableNames {
-		schemaFileName := fmt.Sprintf(jsonSchemaNameFmt, strings.ToLower(tableNames[i]))
-		fmt.Println(fmt.Sprintf("Exporting %s to jsonSchema file %s", tableNames[i], schemaFileName))
+		schemaFileName := fmt.Sprintf(artifacts.JsonSchemaNameFmt, strings.ToLower(tableNames[i]))
+		logging.FromContext(ctx).InfoContext(ctx, "exporting table to jsonSchema", "table", tableNames[i], "file", schemaFileName)
 
 		// Check if the file already exists
 		schemaFilePath := filepath.Join(jsonSchemaPath, schemaFileName)
@@ -122,14 +112,15 @@ func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
 			if err != nil {
 				return err
 			}
+			report.FileRead(ctx, schemaFilePath)
 			err = json.Unmarshal(fileBytes, &jsonTableDef)
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal into TableDef: %v", err)
 			}
 		} else {
 			// Stub in default information
-			jsonTableDef.ClassName = todoMarker
-			jsonTableDef.Description = todoMarker
+			jsonTableDef.ClassName = artifacts.JsonSchemaTodoMarker
+			jsonTableDef.Description = artifacts.JsonSchemaTodoMarker
 		}
 
 		// make sure name case is in line with database
@@ -176,7 +167,7 @@ func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
 				}
 			}
 			if deletedCol {
-				fmt.Println(fmt.Sprintf("WARN: Removing column %s from jsonSchema as it is not part of the table definition", jsonTableDef.Columns[ix].Name))
+				report.Warn(ctx, "removing column from jsonSchema as it is not part of the table definition", "table", jsonTableDef.Name, "column", jsonTableDef.Columns[ix].Name)
 				jsonTableDef.Columns = append(jsonTableDef.Columns[:ix], jsonTableDef.Columns[ix+1:]...)
 			}
 		}
@@ -200,7 +191,7 @@ func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
 			}
 			jsonTableDef.Columns[ix].Type = dbColumns[ix].Type
 
-			jsonTableDef.Columns[ix].DefaultValue = parseDefaultValue(dbColumns[ix].DefaultVal)
+			jsonTableDef.Columns[ix].DefaultValue = parseDefaultValue(ctx, dbColumns[ix].DefaultVal)
 
 			if dbColumns[ix].Length > 8000 {
 				// DB using intMax for unspecified length (text/image types, usually)
@@ -230,15 +221,16 @@ func JsonSchema(driver *mssql.MssqlDbDriver) (err error) {
 
 		err = os.WriteFile(schemaFilePath, []byte(crlfJson), os.ModePerm)
 		if err != nil {
-			return fmt.Errorf("failed to write jsonTableDef to file: %v", err)
+			return &errs.FileSystemError{Op: "write", Path: schemaFilePath, Err: err}
 		}
+		report.FileWritten(ctx, schemaFilePath)
 	}
 
 	return nil
 }
 
 // parseDefaultValue cleans the parathesis wrapping that sql server adds
-func parseDefaultValue(def *string) string {
+func parseDefaultValue(ctx context.Context, def *string) string {
 	if def != nil && len(*def) > 0 {
 		origLen := len(*def)
 		// remove outer () wraps
@@ -249,7 +241,7 @@ func parseDefaultValue(def *string) string {
 			out = out[:newLen]
 		} else {
 			// our logic doesn't work with whatever mssql gave us
-			fmt.Println(fmt.Sprintf("WARN: Unable to unwrap default value %s", *def))
+			report.Warn(ctx, "unable to unwrap default value", "default", *def)
 			out = *def
 		}
 		return out
@@ -258,9 +250,9 @@ func parseDefaultValue(def *string) string {
 	return ""
 }
 
-// getDefaultColumn returns a column with non-database properties pre-filled with todoMarker
+// getDefaultColumn returns a column with non-database properties pre-filled with artifacts.JsonSchemaTodoMarker
 func getDefaultColumn() (col jsonSchema.Column) {
-	col.PropertyName = todoMarker
-	col.Description = todoMarker
+	col.PropertyName = artifacts.JsonSchemaTodoMarker
+	col.Description = artifacts.JsonSchemaTodoMarker
 	return col
 }
@@ -0,0 +1,378 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"kodb-util/artifacts"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// user schemas; 1-4 are dbo, guest, INFORMATION_SCHEMA, and sys, and 16384+ belong to the fixed roles
+	getSchemasSql = `SELECT [name] FROM [sys].[schemas] WHERE [schema_id] > 4 AND [schema_id] < 16384 ORDER BY [name]`
+
+	// users other than dbo, guest, INFORMATION_SCHEMA, and sys, with the login they're mapped to
+	getUsersSql = `SELECT dp.[name], ISNULL(dp.[default_schema_name], N'dbo') AS [schema], dp.[type], sp.[name] AS [login]
+FROM [sys].[database_principals] dp
+LEFT JOIN [sys].[server_principals] sp ON sp.[sid] = dp.[sid]
+WHERE dp.[type] IN ('S', 'U', 'G', 'E', 'X') AND dp.[principal_id] > 4
+ORDER BY dp.[name]`
+
+	// logins that map to a user in the database or use it as their default database.  sa, system logins (##name##),
+	// and the login kodb-util is connected with are excluded.  Passwords are never read.
+	getLoginsSql = `SELECT sp.[name], sp.[type], sp.[default_database_name] AS [defaultDatabase]
+FROM [sys].[server_principals] sp
+WHERE sp.[type] IN ('S', 'U', 'G') AND sp.[principal_id] <> 1 AND sp.[name] NOT LIKE '##%' AND sp.[name] <> SUSER_SNAME()
+AND (sp.[default_database_name] = DB_NAME() OR sp.[sid] IN (SELECT [sid] FROM [sys].[database_principals] WHERE [principal_id] > 4))
+ORDER BY sp.[name]`
+
+	// user-defined roles; public is principal 0
+	getRolesSql = `SELECT [name] FROM [sys].[database_principals] WHERE [type] = 'R' AND [is_fixed_role] = 0 AND [principal_id] > 0 ORDER BY [name]`
+
+	// role memberships, including the fixed roles, of principals other than dbo
+	getRoleMembersSql = `SELECT r.[name] AS [role], m.[name] AS [member]
+FROM [sys].[database_role_members] rm
+INNER JOIN [sys].[database_principals] r ON r.[principal_id] = rm.[role_principal_id]
+INNER JOIN [sys].[database_principals] m ON m.[principal_id] = rm.[member_principal_id]
+WHERE m.[principal_id] > 4
+ORDER BY r.[name], m.[name]`
+
+	// permissions on the database (class 0), objects (1), and schemas (3) granted or denied to users and roles other
+	// than public, dbo, guest, INFORMATION_SCHEMA, and sys
+	getPermissionsSql = `SELECT p.[class], p.[permission_name] AS [permission], p.[state], p.[minor_id] AS [minorId],
+	g.[name] AS [grantee], g.[type] AS [granteeType],
+	ISNULL(SCHEMA_NAME(o.[schema_id]), s.[name]) AS [schema], o.[name] AS [object]
+FROM [sys].[database_permissions] p
+INNER JOIN [sys].[database_principals] g ON g.[principal_id] = p.[grantee_principal_id]
+LEFT JOIN [sys].[objects] o ON p.[class] = 1 AND o.[object_id] = p.[major_id]
+LEFT JOIN [sys].[schemas] s ON p.[class] = 3 AND s.[schema_id] = p.[major_id]
+WHERE p.[class] IN (0, 1, 3) AND g.[principal_id] > 4 AND (p.[class] <> 1 OR o.[is_ms_shipped] = 0)
+ORDER BY g.[name], p.[class], [schema], [object], p.[permission_name]`
+
+	// 1. user name
+	// userGrantsRoleFmt names the role that permissions granted directly to a user are exported as
+	userGrantsRoleFmt = "%s_permissions"
+
+	// 1. login name
+	// loginPassEnvFmt is the environment variable the exported config reads a login's password from
+	loginPassEnvFmt = "${KODB_PASS_%s}"
+)
+
+var envNameReg = regexp.MustCompile(`[^A-Z0-9_]`)
+
+type catalogUser struct {
+	Name   string
+	Schema string
+	Type   string
+	Login  string
+}
+
+type catalogLogin struct {
+	Name            string
+	Type            string
+	DefaultDatabase string `gorm:"column:defaultDatabase"`
+}
+
+type catalogRoleMember struct {
+	Role   string
+	Member string
+}
+
+type catalogPermission struct {
+	Class       int
+	Permission  string
+	State       string
+	MinorId     int `gorm:"column:minorId"`
+	Grantee     string
+	GranteeType string `gorm:"column:granteeType"`
+	Schema      string
+	Object      string
+}
+
+// Security reads the schemas, users, logins, roles, role members, and permissions of the database from the server's
+// catalog, rather than from the config like Structure does, and exports them to OpenKO-db/ManualSetup:
+// 2_CreateSchema_[db]_*.sql
+// 3_CreateUser_*.sql
+// 4_CreateLogin_*.sql
+// 9_CreateRole_[db]_*.sql
+// GenDbConfig_[db].yaml, the equivalent genConfig.gameDb entry
+//
+// Login passwords aren't exported; the config reads them from ${KODB_PASS_[LOGIN]} environment variables.  What the
+// config can't express, e.g. DENY or column permissions, is reported as a warning and left out.
+func Security(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting security from the catalog", "db", driver.GenDbConfig.Name)
+	// ensure ManualSetup directory exists
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	err = os.MkdirAll(manualSetupPath, os.ModePerm)
+	if err != nil {
+		return &errs.FileSystemError{Op: "create", Path: manualSetupPath, Err: err}
+	}
+
+	dbConfig, err := readSecurityCatalog(ctx, driver)
+	if err != nil {
+		return err
+	}
+
+	// clean the old export files of this database; user and login files aren't named by database, so they're replaced
+	for _, pattern := range []string{artifacts.ExportSchemaFileNameFmt, artifacts.ExportRoleFileNameFmt} {
+		files, err := filepath.Glob(filepath.Join(manualSetupPath, fmt.Sprintf(pattern, driver.GenDbConfig.Name+"_*")))
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			if err = os.Remove(files[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// render the artifacts from the catalog's config with the same templates and generators import uses
+	catalogDriver := *driver
+	catalogDriver.GenDbConfig = dbConfig
+	for i := range dbConfig.Schemas {
+		script, err := artifacts.GetCreateSchemaScript(ctx, &catalogDriver, i)
+		if err != nil {
+			return err
+		}
+		err = artifacts.ExportSchemaArtifact(ctx, &catalogDriver, i, script)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range dbConfig.Users {
+		script, err := artifacts.GetCreateUserScript(ctx, &catalogDriver, i)
+		if err != nil {
+			return err
+		}
+		err = artifacts.ExportUserArtifact(ctx, &catalogDriver, i, script)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range dbConfig.Logins {
+		script, err := artifacts.GetCreateLoginScript(ctx, &catalogDriver, i)
+		if err != nil {
+			return err
+		}
+		script = fmt.Sprintf("-- the password isn't exported; replace %s before running this script\n", dbConfig.Logins[i].Pass) + script
+		err = artifacts.ExportLoginArtifact(ctx, &catalogDriver, i, script)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range dbConfig.Roles {
+		script, err := artifacts.GetCreateRoleScript(ctx, &catalogDriver, i)
+		if err != nil {
+			return err
+		}
+		err = artifacts.ExportRoleArtifact(ctx, &catalogDriver, i, script)
+		if err != nil {
+			return err
+		}
+	}
+
+	fragment, err := genDbConfigFragment(dbConfig)
+	if err != nil {
+		return err
+	}
+	return artifacts.ExportGenDbConfigArtifact(ctx, driver, fragment)
+}
+
+// readSecurityCatalog returns the database's schemas, users, logins, and roles as a GenDbConfig.  The driver's other
+// settings, e.g. isForbid* and create, are kept.
+func readSecurityCatalog(ctx context.Context, driver *mssql.MssqlDbDriver) (dbConfig config.GenDbConfig, err error) {
+	conn, err := driver.GetConnection()
+	if err != nil {
+		return dbConfig, err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+
+	dbConfig = driver.GenDbConfig
+	dbConfig.Schemas = []string{}
+	dbConfig.Users = []config.UserConfig{}
+	dbConfig.Logins = []config.LoginConfig{}
+	dbConfig.Roles = []config.RoleConfig{}
+
+	err = conn.Raw(getSchemasSql).Scan(&dbConfig.Schemas).Error
+	if err != nil {
+		return dbConfig, err
+	}
+
+	users := []catalogUser{}
+	err = conn.Raw(getUsersSql).Scan(&users).Error
+	if err != nil {
+		return dbConfig, err
+	}
+	for _, user := range users {
+		if user.Type == "E" || user.Type == "X" {
+			report.Warn(ctx, "Microsoft Entra users aren't supported by genConfig users; skipped", "db", dbName, "user", user.Name)
+			continue
+		}
+		if user.Login != "" && !strings.EqualFold(user.Login, user.Name) {
+			report.Warn(ctx, "user is mapped to a login with a different name; the CreateUser template may not reproduce it", "db", dbName, "user", user.Name, "login", user.Login)
+		}
+		dbConfig.Users = append(dbConfig.Users, config.UserConfig{Name: user.Name, Schema: user.Schema})
+	}
+
+	logins := []catalogLogin{}
+	err = conn.Raw(getLoginsSql).Scan(&logins).Error
+	if err != nil {
+		return dbConfig, err
+	}
+	for _, login := range logins {
+		if login.Type != "S" {
+			report.Warn(ctx, "Windows logins aren't supported by genConfig logins; skipped", "db", dbName, "login", login.Name)
+			continue
+		}
+		if !strings.EqualFold(login.DefaultDatabase, dbName) {
+			report.Warn(ctx, "login maps to a user in the database but its default database is another; list it under that database", "db", dbName, "login", login.Name, "defaultDatabase", login.DefaultDatabase)
+			continue
+		}
+		envName := envNameReg.ReplaceAllString(strings.ToUpper(login.Name), "_")
+		dbConfig.Logins = append(dbConfig.Logins, config.LoginConfig{Name: login.Name, Pass: fmt.Sprintf(loginPassEnvFmt, envName)})
+	}
+
+	roleNames := []string{}
+	err = conn.Raw(getRolesSql).Scan(&roleNames).Error
+	if err != nil {
+		return dbConfig, err
+	}
+	roles := map[string]*config.RoleConfig{}
+	roleOrder := []string{}
+	addRole := func(name string) *config.RoleConfig {
+		key := strings.ToLower(name)
+		if roles[key] == nil {
+			roles[key] = &config.RoleConfig{Name: name}
+			roleOrder = append(roleOrder, key)
+		}
+		return roles[key]
+	}
+	for _, name := range roleNames {
+		addRole(name)
+	}
+
+	members := []catalogRoleMember{}
+	err = conn.Raw(getRoleMembersSql).Scan(&members).Error
+	if err != nil {
+		return dbConfig, err
+	}
+	for _, member := range members {
+		role := addRole(member.Role)
+		role.Members = append(role.Members, member.Member)
+	}
+
+	permissions := []catalogPermission{}
+	err = conn.Raw(getPermissionsSql).Scan(&permissions).Error
+	if err != nil {
+		return dbConfig, err
+	}
+	// permissions by role, then by schema or object
+	grants := map[string]map[string][]string{}
+	for _, permission := range permissions {
+		switch {
+		case permission.Class == 0 && permission.Permission == "CONNECT":
+			// every user is granted CONNECT when it's created
+			continue
+		case permission.Class == 0:
+			report.Warn(ctx, "database permissions aren't supported by genConfig roles; skipped", "db", dbName, "grantee", permission.Grantee, "permission", permission.Permission)
+			continue
+		case permission.State == "D":
+			report.Warn(ctx, "DENY isn't supported by genConfig roles; skipped", "db", dbName, "grantee", permission.Grantee, "permission", permission.Permission, "on", securableName(permission))
+			continue
+		case permission.MinorId != 0:
+			report.Warn(ctx, "column permissions aren't supported by genConfig roles; skipped", "db", dbName, "grantee", permission.Grantee, "permission", permission.Permission, "on", securableName(permission))
+			continue
+		}
+
+		roleName := permission.Grantee
+		if permission.GranteeType != "R" {
+			roleName = fmt.Sprintf(userGrantsRoleFmt, permission.Grantee)
+			if roles[strings.ToLower(roleName)] == nil {
+				report.Warn(ctx, "permissions granted directly to a user are exported as a role with the user as its member", "db", dbName, "user", permission.Grantee, "role", roleName)
+				addRole(roleName).Members = []string{permission.Grantee}
+			}
+		}
+		role := addRole(roleName)
+		key := strings.ToLower(role.Name)
+		if grants[key] == nil {
+			grants[key] = map[string][]string{}
+		}
+		securable := securableName(permission)
+		grants[key][securable] = append(grants[key][securable], permission.Permission)
+	}
+
+	for _, key := range roleOrder {
+		role := roles[key]
+		role.Grants = groupGrants(grants[key])
+		if role.IsFixed() && len(role.Members) == 0 {
+			continue
+		}
+		dbConfig.Roles = append(dbConfig.Roles, *role)
+	}
+	return dbConfig, nil
+}
+
+// securableName returns SCHEMA::name for schema permissions, otherwise schema.object
+func securableName(permission catalogPermission) string {
+	if permission.Class == 3 {
+		return "SCHEMA::" + permission.Schema
+	}
+	return permission.Schema + "." + permission.Object
+}
+
+// groupGrants returns a GrantConfig for each schema, and one for each set of permissions on objects, in a stable order
+func groupGrants(securables map[string][]string) (grants []config.GrantConfig) {
+	names := make([]string, 0, len(securables))
+	for name := range securables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	// index in grants of the object grant for each set of permissions
+	objectGrants := map[string]int{}
+	for _, name := range names {
+		permissions := securables[name]
+		if schema, ok := strings.CutPrefix(name, "SCHEMA::"); ok {
+			grants = append(grants, config.GrantConfig{Permissions: permissions, Schema: schema})
+			continue
+		}
+		key := strings.Join(permissions, ",")
+		i, ok := objectGrants[key]
+		if !ok {
+			grants = append(grants, config.GrantConfig{Permissions: permissions})
+			i = len(grants) - 1
+			objectGrants[key] = i
+		}
+		grants[i].Objects = append(grants[i].Objects, name)
+	}
+	return grants
+}
+
+// genDbConfigFragment returns dbConfig's schemas, users, logins, and roles as a genConfig.gameDb list entry
+func genDbConfigFragment(dbConfig config.GenDbConfig) (string, error) {
+	fragment := []struct {
+		Name    string               `yaml:"name"`
+		Schemas []string             `yaml:"schemas"`
+		Logins  []config.LoginConfig `yaml:"logins"`
+		Users   []config.UserConfig  `yaml:"users"`
+		Roles   []config.RoleConfig  `yaml:"roles"`
+	}{{dbConfig.Name, dbConfig.Schemas, dbConfig.Logins, dbConfig.Users, dbConfig.Roles}}
+	yamlBytes, err := yaml.Marshal(fragment)
+	if err != nil {
+		return "", err
+	}
+	header := fmt.Sprintf("# genConfig.gameDb entry read from the catalog of %s by export security.\n", dbConfig.Name) +
+		"# Login passwords aren't exported; set the ${KODB_PASS_*} environment variables or replace them.\n"
+	return header + string(yamlBytes), nil
+}
@@ -1,13 +1,16 @@
 package export
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Open-KO/kodb-godef/jsonSchema"
 	"kodb-util/artifacts"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/report"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -44,17 +47,17 @@ type StoredProcDef struct {
 	ObjectId string `gorm:"column:objectId"`
 }
 
-func StoredProcedures(driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Exporting Stored Procedure --")
+func StoredProcedures(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting stored procedures", "db", driver.GenDbConfig.Name)
 	// ensure ManualSetup directory exists
-	err = os.MkdirAll(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), os.ModePerm)
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	err = os.MkdirAll(manualSetupPath, os.ModePerm)
 	if err != nil {
-		fmt.Printf("failed to create the ManualSetup directory: %v\n", err)
-		return
+		return &errs.FileSystemError{Op: "create", Path: manualSetupPath, Err: err}
 	}
 
 	// clean the old export files
-	files, err := filepath.Glob(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir, "[8][_]*.sql"))
+	files, err := filepath.Glob(filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir, "[8][_]*.sql"))
 	if err != nil {
 		return err
 	}
@@ -68,6 +71,7 @@ func StoredProcedures(driver *mssql.MssqlDbDriver) (err error) {
 	if err != nil {
 		return err
 	}
+	gormConn = gormConn.WithContext(ctx)
 
 	// pull the stored procs from the database
 	storedProcs := []StoredProcDef{}
@@ -93,23 +97,23 @@ func StoredProcedures(driver *mssql.MssqlDbDriver) (err error) {
 		procDefs = append(procDefs, procDef)
 
 		storedProcs[i].Proc = storedProcs[i].Proc + "\n"
-		err = artifacts.ExportStoredProcArtifact(driver, storedProcs[i].Name, storedProcs[i].Proc)
+		err = artifacts.ExportStoredProcArtifact(ctx, driver, storedProcs[i].Name, storedProcs[i].Proc)
 		if err != nil {
 			return err
 		}
 	}
 
-	return updateProcDefs(procDefs)
+	return updateProcDefs(ctx, driver, procDefs)
 }
 
 // updateProcDefs exports procedure structure to jsonSchema/procedures
-func updateProcDefs(procDefs []jsonSchema.ProcDef) (err error) {
-	fmt.Println("-- Exporting procedure jsonSchema --")
+func updateProcDefs(ctx context.Context, driver *mssql.MssqlDbDriver, procDefs []jsonSchema.ProcDef) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting procedure jsonSchema")
 
-	jsonSchemaProcPath := filepath.Join(config.GetConfig().GenConfig.SchemaDir, jsonSchemaDir, jsonSchemaProcedures)
+	jsonSchemaProcPath := artifacts.JsonSchemaProcPath(driver.SchemaDir())
 	for i := range procDefs {
-		schemaFileName := fmt.Sprintf(jsonSchemaNameFmt, strings.ToLower(procDefs[i].Name))
-		fmt.Println(fmt.Sprintf("Exporting %s to procedure json file %s", procDefs[i].Name, schemaFileName))
+		schemaFileName := fmt.Sprintf(artifacts.JsonSchemaNameFmt, strings.ToLower(procDefs[i].Name))
+		logging.FromContext(ctx).InfoContext(ctx, "exporting procedure to jsonSchema", "procedure", procDefs[i].Name, "file", schemaFileName)
 
 		// Check if the file already exists
 		schemaFilePath := filepath.Join(jsonSchemaProcPath, schemaFileName)
@@ -131,13 +135,14 @@ func updateProcDefs(procDefs []jsonSchema.ProcDef) (err error) {
 			if err != nil {
 				return err
 			}
+			report.FileRead(ctx, schemaFilePath)
 			err = json.Unmarshal(fileBytes, &jsonProcDef)
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal into TableDef: %v", err)
 			}
 		} else {
 			// Stub in default information
-			jsonProcDef.Description = todoMarker
+			jsonProcDef.Description = artifacts.JsonSchemaTodoMarker
 			jsonProcDef.ClassName = snakeToCamelCase(procDefs[i].Name)
 		}
 
@@ -159,7 +164,7 @@ func updateProcDefs(procDefs []jsonSchema.ProcDef) (err error) {
 				}
 			}
 			if deletedParam {
-				fmt.Println(fmt.Sprintf("WARN: Removing param %s from jsonSchema as it is not part of the procedure definition", jsonProcDef.Params[ix].Name))
+				report.Warn(ctx, "removing param from jsonSchema as it is not part of the procedure definition", "procedure", jsonProcDef.Name, "param", jsonProcDef.Params[ix].Name)
 				jsonProcDef.Params = append(jsonProcDef.Params[:ix], jsonProcDef.Params[ix+1:]...)
 			}
 		}
@@ -197,17 +202,18 @@ func updateProcDefs(procDefs []jsonSchema.ProcDef) (err error) {
 
 		err = os.WriteFile(schemaFilePath, []byte(crlfJson), os.ModePerm)
 		if err != nil {
-			return fmt.Errorf("failed to write jsonProcDef to file: %v", err)
+			return &errs.FileSystemError{Op: "write", Path: schemaFilePath, Err: err}
 		}
+		report.FileWritten(ctx, schemaFilePath)
 	}
 
 	return nil
 }
 
-// getDefaultParam returns a param with non-database properties pre-filled with todoMarker
+// getDefaultParam returns a param with non-database properties pre-filled with artifacts.JsonSchemaTodoMarker
 func getDefaultParam() (col jsonSchema.ParamDef) {
-	col.ParamName = todoMarker
-	col.Description = todoMarker
+	col.ParamName = artifacts.JsonSchemaTodoMarker
+	col.Description = artifacts.JsonSchemaTodoMarker
 	return col
 }
 
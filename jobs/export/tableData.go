@@ -1,11 +1,14 @@
 package export
 
 import (
-	"fmt"
+	"context"
 	"github.com/Open-KO/OpenKO-gorm/kogen"
 	"kodb-util/artifacts"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/progress"
+	"kodb-util/report"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,17 +16,17 @@ import (
 
 // TableData uses the openko-gorm model library to query all table data in a way that preserves original values
 // and uses those model objects to generate insert dumps as OpenKO-db/ManualSetup/6_InsertData_*.sql
-func TableData(driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Exporting Table Data --")
+func TableData(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting table data", "db", driver.GenDbConfig.Name)
 	// ensure ManualSetup directory exists
-	err = os.MkdirAll(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), os.ModePerm)
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	err = os.MkdirAll(manualSetupPath, os.ModePerm)
 	if err != nil {
-		fmt.Printf("failed to create the ManualSetup directory: %v\n", err)
-		return
+		return &errs.FileSystemError{Op: "create", Path: manualSetupPath, Err: err}
 	}
 
 	// clean the old export files
-	files, err := filepath.Glob(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir, "[6][_]*.sql"))
+	files, err := filepath.Glob(filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir, "[6][_]*.sql"))
 	if err != nil {
 		return err
 	}
@@ -37,9 +40,14 @@ func TableData(driver *mssql.MssqlDbDriver) (err error) {
 	if err != nil {
 		return err
 	}
+	gormConn = gormConn.WithContext(ctx)
+
+	progressReport := progress.New(ctx, "exporting table data", "tables", len(kogen.ModelList))
+	defer progressReport.Done()
 
 	// iterate over the tables in our schema and extract their data
 	for i := range kogen.ModelList {
+		progressReport.SetCurrent(kogen.ModelList[i].TableName())
 		var results []kogen.Model
 		results, err = kogen.ModelList[i].GetAllTableData(gormConn)
 		if err != nil {
@@ -58,11 +66,13 @@ func TableData(driver *mssql.MssqlDbDriver) (err error) {
 			}
 			// ensure EOF empty line
 			sb.WriteString("\n")
-			err = artifacts.ExportTableDataArtifact(driver, kogen.ModelList[i].TableName(), sb.String())
+			err = artifacts.ExportTableDataArtifact(ctx, driver, kogen.ModelList[i].TableName(), sb.String())
 			if err != nil {
 				return err
 			}
 		}
+		progressReport.Add(1, int64(len(results)))
+		report.AddRows(ctx, int64(len(results)))
 	}
 	return nil
 }
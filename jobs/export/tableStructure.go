@@ -1,10 +1,11 @@
 package export
 
 import (
-	"fmt"
+	"context"
 	"github.com/Open-KO/OpenKO-gorm/kogen"
 	"kodb-util/artifacts"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
 	"os"
 	"path/filepath"
@@ -17,17 +18,18 @@ import (
 // 3_CreateUser_[DbType]_*.sql
 // 4_CreateLogin_[DbType]_*.sql
 // 5_CreateTable_[DbType]_*.sql
-func Structure(driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Exporting Table Structures --")
+// 9_CreateRole_[DbType]_*.sql
+func Structure(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting table structures", "db", driver.GenDbConfig.Name)
 	// ensure ManualSetup directory exists
-	err = os.MkdirAll(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), os.ModePerm)
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	err = os.MkdirAll(manualSetupPath, os.ModePerm)
 	if err != nil {
-		fmt.Printf("failed to create the ManualSetup directory: %v\n", err)
-		return
+		return &errs.FileSystemError{Op: "create", Path: manualSetupPath, Err: err}
 	}
 
 	// clean old artifacts
-	files, err := filepath.Glob(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir, "[1-5][_]*.sql"))
+	files, err := filepath.Glob(filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir, "[1-59][_]*.sql"))
 	if err != nil {
 		return err
 	}
@@ -38,24 +40,24 @@ func Structure(driver *mssql.MssqlDbDriver) (err error) {
 	}
 
 	// Export Database as 1_CreateDatabase_%s_*.sql
-	script, err := artifacts.GetCreateDatabaseScript(driver)
+	script, err := artifacts.GetCreateDatabaseScript(ctx, driver)
 	if err != nil {
 		return err
 	}
 
-	err = artifacts.ExportDatabaseArtifact(driver, script)
+	err = artifacts.ExportDatabaseArtifact(ctx, driver, script)
 	if err != nil {
 		return err
 	}
 
 	// Export Schema as 2_CreateSchema_*.sql
 	for i := range driver.GenDbConfig.Schemas {
-		script, err = artifacts.GetCreateSchemaScript(driver, i)
+		script, err = artifacts.GetCreateSchemaScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
 
-		err = artifacts.ExportSchemaArtifact(driver, i, script)
+		err = artifacts.ExportSchemaArtifact(ctx, driver, i, script)
 		if err != nil {
 			return err
 		}
@@ -63,12 +65,12 @@ func Structure(driver *mssql.MssqlDbDriver) (err error) {
 
 	// Export Users as 3_CreateUser_*.sql
 	for i := range driver.GenDbConfig.Users {
-		script, err = artifacts.GetCreateUserScript(driver, i)
+		script, err = artifacts.GetCreateUserScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
 
-		err = artifacts.ExportUserArtifact(driver, i, script)
+		err = artifacts.ExportUserArtifact(ctx, driver, i, script)
 		if err != nil {
 			return err
 		}
@@ -76,12 +78,12 @@ func Structure(driver *mssql.MssqlDbDriver) (err error) {
 
 	// Export Logins as 4_CreateLogin_*.sql
 	for i := range driver.GenDbConfig.Logins {
-		script, err = artifacts.GetCreateLoginScript(driver, i)
+		script, err = artifacts.GetCreateLoginScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
 
-		err = artifacts.ExportLoginArtifact(driver, i, script)
+		err = artifacts.ExportLoginArtifact(ctx, driver, i, script)
 		if err != nil {
 			return err
 		}
@@ -90,7 +92,20 @@ func Structure(driver *mssql.MssqlDbDriver) (err error) {
 	// Export Tables as 5_CreateTable_*.sql
 	for i := range kogen.ModelList {
 		createTableSql := kogen.ModelList[i].GetCreateTableString()
-		err = artifacts.ExportTableArtifact(driver, kogen.ModelList[i].TableName(), createTableSql)
+		err = artifacts.ExportTableArtifact(ctx, driver, kogen.ModelList[i].TableName(), createTableSql)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Export Roles as 9_CreateRole_*.sql
+	for i := range driver.GenDbConfig.Roles {
+		script, err = artifacts.GetCreateRoleScript(ctx, driver, i)
+		if err != nil {
+			return err
+		}
+
+		err = artifacts.ExportRoleArtifact(ctx, driver, i, script)
 		if err != nil {
 			return err
 		}
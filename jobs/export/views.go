@@ -1,9 +1,10 @@
 package export
 
 import (
-	"fmt"
+	"context"
 	"kodb-util/artifacts"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
 	"os"
 	"path/filepath"
@@ -19,17 +20,17 @@ type ViewDef struct {
 	View string `gorm:"column:aView"`
 }
 
-func Views(driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Exporting Views --")
+func Views(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "exporting views", "db", driver.GenDbConfig.Name)
 	// ensure ManualSetup directory exists
-	err = os.MkdirAll(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), os.ModePerm)
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	err = os.MkdirAll(manualSetupPath, os.ModePerm)
 	if err != nil {
-		fmt.Printf("failed to create the ManualSetup directory: %v\n", err)
-		return
+		return &errs.FileSystemError{Op: "create", Path: manualSetupPath, Err: err}
 	}
 
 	// clean the old export files
-	files, err := filepath.Glob(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir, "[7][_]*.sql"))
+	files, err := filepath.Glob(filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir, "[7][_]*.sql"))
 	if err != nil {
 		return err
 	}
@@ -43,6 +44,7 @@ func Views(driver *mssql.MssqlDbDriver) (err error) {
 	if err != nil {
 		return err
 	}
+	gormConn = gormConn.WithContext(ctx)
 
 	// pull the views from the database
 	views := []ViewDef{}
@@ -54,7 +56,7 @@ func Views(driver *mssql.MssqlDbDriver) (err error) {
 	// write them to the output folder
 	for i := range views {
 		views[i].View = views[i].View + "\n"
-		err = artifacts.ExportViewArtifact(driver, views[i].Name, views[i].View)
+		err = artifacts.ExportViewArtifact(ctx, driver, views[i].Name, views[i].View)
 		if err != nil {
 			return err
 		}
@@ -6,18 +6,23 @@ import (
 	"github.com/Open-KO/OpenKO-gorm/kogen"
 	"gorm.io/gorm"
 	"kodb-util/artifacts"
-	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"kodb-util/mssql"
+	"kodb-util/progress"
+	"kodb-util/report"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 )
 
-var (
+const (
 
-	// ImportBatSize is used to set the number of insert records sent in each batch.  Valid values 2-999.
-	ImportBatSize = 16
+	// DefaultImportBatchSize is the number of insert records sent in each batch when the driver's
+	// Options.ImportBatchSize isn't a valid value (2-999)
+	DefaultImportBatchSize = 16
 
 	// this was benchmarked, changing it may cause performance issues:
 	//table data successfully imported in 1m37.0268984s; batch size 999
@@ -48,8 +53,25 @@ type ScriptArgs struct {
 	IsUseDefaultSystemDb bool
 
 	// IsDataDump set to true for loading one of our insert dumps; our dumps do not use "GO" batch separators and must be manually split
-	// this is done to keep our insert files diff-friendly and allow us to adjust the ImportBatSize for performance tuning
+	// this is done to keep our insert files diff-friendly and allow us to adjust the import batch size for performance tuning
 	IsDataDump bool
+
+	// ProgressLabel describes the scripts in the progress output.  Default "running scripts"
+	ProgressLabel string
+}
+
+// ImportArgs are arguments used in the ImportDbWithArgs function
+type ImportArgs struct {
+	// IsSkipLogins will skip creating the server-level logins in GenDbConfig.Logins when true.  Default false
+	// Logins are shared by every database on the server, so scratch imports shouldn't recreate them
+	IsSkipLogins bool
+}
+
+// defaultImportArgs returns an ImportArgs object with default values
+func defaultImportArgs() ImportArgs {
+	return ImportArgs{
+		IsSkipLogins: false,
+	}
 }
 
 // defaultScriptArgs returns a ScriptArgs object with default values
@@ -64,9 +86,14 @@ func defaultScriptArgs() ScriptArgs {
 // Database creation scripts execute against mssql.DefaultSysDbName, the rest should be
 // executed using the created database named in schemaConfig.GameDb.Name
 func ImportDb(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Import --")
+	return ImportDbWithArgs(ctx, driver, defaultImportArgs())
+}
+
+// ImportDbWithArgs is ImportDb with control over which steps are run
+func ImportDbWithArgs(ctx context.Context, driver *mssql.MssqlDbDriver, importArgs ImportArgs) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "importing database", "db", driver.GenDbConfig.Name)
 
-	err = importDbs(ctx, driver)
+	err = runStep(ctx, "import databases", importDbs, driver)
 	if err != nil {
 		return err
 	}
@@ -77,44 +104,56 @@ func ImportDb(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 		return err
 	}
 
-	err = importSchemas(ctx, driver)
-	if err != nil {
-		return err
-	}
-
-	err = importUsers(ctx, driver)
-	if err != nil {
-		return err
-	}
-
-	err = importLogins(ctx, driver)
-	if err != nil {
-		return err
+	skipLogins := ""
+	if importArgs.IsSkipLogins {
+		skipLogins = "logins are shared with the configured database"
 	}
-
-	err = importTables(ctx, driver)
-	if err != nil {
-		return err
+	skipRoles := ""
+	if len(driver.GenDbConfig.Roles) == 0 {
+		skipRoles = "no roles configured"
 	}
-
-	err = importViews(ctx, driver)
-	if err != nil {
-		return err
+	steps := []struct {
+		Name string
+		Run  func(context.Context, *mssql.MssqlDbDriver) error
+		Skip string
+	}{
+		{Name: "import schemas", Run: importSchemas},
+		{Name: "import users", Run: importUsers},
+		{Name: "import logins", Run: importLogins, Skip: skipLogins},
+		{Name: "import tables", Run: importTables},
+		{Name: "import data", Run: importTableData},
+		{Name: "import views", Run: importViews},
+		{Name: "import procs", Run: importStoredProcs},
+		{Name: "import roles", Run: importRoles, Skip: skipRoles},
 	}
-
-	err = importStoredProcs(ctx, driver)
-	if err != nil {
-		return err
+	for _, step := range steps {
+		if step.Skip != "" {
+			report.Skip(ctx, step.Name, step.Skip)
+			continue
+		}
+		err = runStep(ctx, step.Name, step.Run, driver)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// runStep runs a single import step as its own report phase
+func runStep(ctx context.Context, name string, step func(context.Context, *mssql.MssqlDbDriver) error, driver *mssql.MssqlDbDriver) (err error) {
+	ctx, phase := report.StartPhase(ctx, name)
+	defer func() {
+		phase.End(err)
+	}()
+	return step(ctx, driver)
+}
+
 // runScripts runs a related group of sql files.  Each file is broken down into batches (separated by the "GO" keyword)
 // and then executed/commited within a transaction fence.
 func runScripts(ctx context.Context, driver *mssql.MssqlDbDriver, scriptArgs ScriptArgs, sqlScripts ...Script) (err error) {
 	if len(sqlScripts) == 0 {
-		fmt.Println("WARN: No scripts to execute")
+		logging.FromContext(ctx).WarnContext(ctx, "no scripts to execute")
 		return nil
 	}
 
@@ -128,80 +167,134 @@ func runScripts(ctx context.Context, driver *mssql.MssqlDbDriver, scriptArgs Scr
 	if err != nil {
 		return err
 	}
+	gormConn = gormConn.WithContext(ctx)
 
+	// split everything up front so that the total number of batches is known for progress reporting
+	scriptBatches := make([][]string, len(sqlScripts))
+	totalBatches := 0
 	for i := range sqlScripts {
-		batches := []string{}
-		if scriptArgs.IsDataDump {
-
-			lines := strings.Split(sqlScripts[i].Sql, "\n")
-			// sliding window batches
-			l := 1
-			r := l + ImportBatSize
-
-			header := fmt.Sprintf("%s\n", lines[0])
-			for l < len(lines) {
-				// put r back on tail element if exceeded
-				if r >= len(lines) {
-					r = len(lines) - 1
-				}
-
-				// remove any trailing "," from previous batch
-				if len(batches) > 0 {
-					batches[len(batches)-1] = strings.TrimSpace(batches[len(batches)-1])
-					batches[len(batches)-1] = strings.TrimSuffix(batches[len(batches)-1], ",")
-				}
-
-				if l == r {
-					// make sure we didn't just land on the blank line at the end of the file
-					if strings.TrimSpace(lines[l]) == "" {
-						break
-					}
-				}
+		scriptBatches[i] = getBatches(sqlScripts[i], scriptArgs, importBatchSize(driver))
+		totalBatches += len(scriptBatches[i])
+	}
 
-				// capture current window as batch
-				// insert header
-				batch := header + strings.Join(lines[l:r+1], "\n")
-				batches = append(batches, batch)
-				l = r + 1
-				r += ImportBatSize
-			}
-		} else {
-			batches = splitBatches(sqlScripts[i].Sql)
-		}
+	label := scriptArgs.ProgressLabel
+	if label == "" {
+		label = "running scripts"
+	}
+	progressReport := progress.New(ctx, label, "batches", totalBatches)
+	defer progressReport.Done()
 
+	for i := range sqlScripts {
+		batches := scriptBatches[i]
+		progressReport.SetCurrent(sqlScripts[i].Name)
+		logging.FromContext(ctx).DebugContext(ctx, "running script", "file", sqlScripts[i].Name, "batches", len(batches))
 		for j := range batches {
+			// stop between batches if cancelled; the in-flight batch is cancelled by the driver
+			if err = ctx.Err(); err != nil {
+				return err
+			}
 			err = gormConn.Exec(batches[j]).Error
 			if err != nil {
-				if !isIgnoreErr(err) {
-					fmt.Printf("error executing batch [%d/%d] in %s: %v\n", j+1, len(batches), sqlScripts[i].Name, err)
-					fmt.Printf("batch sql: %s", batches[j])
-					return err
+				if !isIgnoreErr(err, batches[j]) {
+					progressReport.Done()
+					logging.FromContext(ctx).ErrorContext(ctx, "error executing batch", "file", sqlScripts[i].Name, "batch", j+1, "batches", len(batches), "error", err, "sql", logging.RedactSql(batches[j]))
+					number, _ := mssql.ErrorNumber(err)
+					return &errs.SqlBatchError{File: sqlScripts[i].Name, Batch: j + 1, Batches: len(batches), Number: number, Err: err}
 				} else {
+					logging.FromContext(ctx).DebugContext(ctx, "ignored batch error", "file", sqlScripts[i].Name, "batch", j+1, "error", err)
 					err = nil
 				}
 			}
+
+			rows := int64(0)
+			if scriptArgs.IsDataDump {
+				// one row per line after the INSERT header
+				rows = int64(strings.Count(batches[j], "\n"))
+			}
+			progressReport.Add(1, rows)
+			report.AddRows(ctx, rows)
 		}
 	}
 
 	return nil
 }
 
-// importDbs uses the CreateDatabase.sqltemplate to create the database configured in schemaConfig.gameDb
+// RunScripts runs scripts the same way the import steps do, e.g. for jobs that re-create some of the imported objects
+func RunScripts(ctx context.Context, driver *mssql.MssqlDbDriver, scriptArgs ScriptArgs, sqlScripts ...Script) error {
+	return runScripts(ctx, driver, scriptArgs, sqlScripts...)
+}
+
+// importBatchSize returns the number of insert records sent in each batch of a data dump
+func importBatchSize(driver *mssql.MssqlDbDriver) int {
+	if driver.Options.ImportBatchSize > 1 && driver.Options.ImportBatchSize < 1000 {
+		return driver.Options.ImportBatchSize
+	}
+	return DefaultImportBatchSize
+}
+
+// getBatches splits script into the batches that runScripts executes.  Data dumps are split every batchSize rows,
+// everything else on the "GO" batch terminator
+func getBatches(script Script, scriptArgs ScriptArgs, batchSize int) []string {
+	batches := []string{}
+	if scriptArgs.IsDataDump {
+
+		lines := strings.Split(script.Sql, "\n")
+		// sliding window batches
+		l := 1
+		r := l + batchSize
+
+		header := fmt.Sprintf("%s\n", lines[0])
+		for l < len(lines) {
+			// put r back on tail element if exceeded
+			if r >= len(lines) {
+				r = len(lines) - 1
+			}
+
+			// remove any trailing "," from previous batch
+			if len(batches) > 0 {
+				batches[len(batches)-1] = strings.TrimSpace(batches[len(batches)-1])
+				batches[len(batches)-1] = strings.TrimSuffix(batches[len(batches)-1], ",")
+			}
+
+			if l == r {
+				// make sure we didn't just land on the blank line at the end of the file
+				if strings.TrimSpace(lines[l]) == "" {
+					break
+				}
+			}
+
+			// capture current window as batch
+			// insert header
+			batch := header + strings.Join(lines[l:r+1], "\n")
+			batches = append(batches, batch)
+			l = r + 1
+			r += batchSize
+		}
+	} else {
+		batches = splitBatches(script.Sql)
+	}
+
+	return batches
+}
+
+// importDbs uses the CreateDatabase.sqltemplate to create the database configured in schemaConfig.gameDb, with its
+// create options
 func importDbs(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("databases successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "databases successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing databases --")
+	logging.FromContext(ctx).InfoContext(ctx, "importing databases")
 	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing databases"
 	sArgs.IsUseDefaultSystemDb = true
 
 	script := Script{
 		Name: fmt.Sprintf(artifacts.ExportDatabaseFileNameFmt, driver.GenDbConfig.Name),
 	}
 
-	script.Sql, err = artifacts.GetCreateDatabaseScript(driver)
+	script.Sql, err = artifacts.GetCreateDatabaseScript(ctx, driver)
 	if err != nil {
 		return err
 	}
@@ -213,17 +306,18 @@ func importDbs(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 func importSchemas(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("schemas successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "schemas successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing Schemas --")
+	logging.FromContext(ctx).InfoContext(ctx, "importing schemas")
 	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing schemas"
 	scripts := []Script{}
 	for i := range driver.GenDbConfig.Schemas {
 		script := Script{
 			Name: fmt.Sprintf(artifacts.ExportSchemaFileNameFmt, driver.GenDbConfig.Schemas[i]),
 		}
-		script.Sql, err = artifacts.GetCreateSchemaScript(driver, i)
+		script.Sql, err = artifacts.GetCreateSchemaScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
@@ -238,17 +332,18 @@ func importSchemas(ctx context.Context, driver *mssql.MssqlDbDriver) (err error)
 func importUsers(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("users successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "users successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing Users --")
+	logging.FromContext(ctx).InfoContext(ctx, "importing users")
 	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing users"
 	scripts := []Script{}
 	for i := range driver.GenDbConfig.Users {
 		script := Script{
 			Name: fmt.Sprintf(artifacts.ExportUserFileNameFmt, driver.GenDbConfig.Users[i].Name),
 		}
-		script.Sql, err = artifacts.GetCreateUserScript(driver, i)
+		script.Sql, err = artifacts.GetCreateUserScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
@@ -263,18 +358,19 @@ func importUsers(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 func importLogins(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("logins successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "logins successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing Logins --")
+	logging.FromContext(ctx).InfoContext(ctx, "importing logins")
 	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing logins"
 	sArgs.IsUseDefaultSystemDb = true
 	scripts := []Script{}
 	for i := range driver.GenDbConfig.Logins {
 		script := Script{
 			Name: fmt.Sprintf(artifacts.ExportLoginFileNameFmt, driver.GenDbConfig.Logins[i].Name),
 		}
-		script.Sql, err = artifacts.GetCreateLoginScript(driver, i)
+		script.Sql, err = artifacts.GetCreateLoginScript(ctx, driver, i)
 		if err != nil {
 			return err
 		}
@@ -285,10 +381,9 @@ func importLogins(ctx context.Context, driver *mssql.MssqlDbDriver) (err error)
 	return runScripts(ctx, driver, sArgs, scripts...)
 }
 
-// importTables uses the openko-gorm model library to run CREATE TABLE sql scripts, then
-// inserts the table data defined in OpenKO-db/ManualSetup/6_InsertData_*.sql
+// importTables uses the openko-gorm model library to run CREATE TABLE sql scripts
 func importTables(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
-	fmt.Println("-- Creating Tables --")
+	logging.FromContext(ctx).InfoContext(ctx, "creating tables")
 	scripts := []Script{}
 	for i := range kogen.ModelList {
 		script := Script{
@@ -298,18 +393,24 @@ func importTables(ctx context.Context, driver *mssql.MssqlDbDriver) (err error)
 		scripts = append(scripts, script)
 	}
 
-	err = runScripts(ctx, driver, defaultScriptArgs(), scripts...)
+	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "creating tables"
+	err = runScripts(ctx, driver, sArgs, scripts...)
 	if err != nil {
 		return err
 	}
-	fmt.Println("table structures successfully created")
+	logging.FromContext(ctx).InfoContext(ctx, "table structures successfully created")
+	return nil
+}
 
-	fmt.Println("-- Importing Table Data --")
-	fmt.Println("this may take several minutes")
+// importTableData inserts the table data defined in OpenKO-db/ManualSetup/6_InsertData_*.sql
+func importTableData(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "importing table data; this may take several minutes")
 	start := time.Now()
 	args := defaultScriptArgs()
 	args.IsDataDump = true
-	scripts, err = getSqlScriptsByPattern(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportTableDataFileNameFmt, "*"))
+	args.ProgressLabel = "importing table data"
+	scripts, err := getSqlScriptsByPattern(ctx, filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportTableDataFileNameFmt, "*"))
 	if err != nil {
 		return err
 	}
@@ -317,7 +418,7 @@ func importTables(ctx context.Context, driver *mssql.MssqlDbDriver) (err error)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("table data successfully imported in %.2f seconds; batch size %d\n", time.Since(start).Seconds(), ImportBatSize)
+	logging.FromContext(ctx).InfoContext(ctx, "table data successfully imported", "elapsed", time.Since(start), "batchSize", importBatchSize(driver))
 	return nil
 }
 
@@ -325,44 +426,74 @@ func importTables(ctx context.Context, driver *mssql.MssqlDbDriver) (err error)
 func importViews(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("views successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "views successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing Views --")
-	scripts, err := getSqlScriptsByPattern(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportViewFileNameFmt, "*"))
+	logging.FromContext(ctx).InfoContext(ctx, "importing views")
+	scripts, err := getSqlScriptsByPattern(ctx, filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportViewFileNameFmt, "*"))
 	if err != nil {
 		return err
 	}
 
-	return runScripts(ctx, driver, defaultScriptArgs(), scripts...)
+	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing views"
+	return runScripts(ctx, driver, sArgs, scripts...)
 }
 
 // importViews executes the *.sql scripts in OpenKO-db/StoredProcedures
 func importStoredProcs(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
 	defer func() {
 		if err == nil {
-			fmt.Println("stored procedures successfully imported")
+			logging.FromContext(ctx).InfoContext(ctx, "stored procedures successfully imported")
 		}
 	}()
-	fmt.Println("-- Importing Stored Procedures --")
-	scripts, err := getSqlScriptsByPattern(filepath.Join(config.GetConfig().GenConfig.SchemaDir, artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportStoredProcedureFileNameFmt, "*"))
+	logging.FromContext(ctx).InfoContext(ctx, "importing stored procedures")
+	scripts, err := getSqlScriptsByPattern(ctx, filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir), fmt.Sprintf(artifacts.ExportStoredProcedureFileNameFmt, "*"))
 	if err != nil {
 		return err
 	}
 
 	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing stored procedures"
+	return runScripts(ctx, driver, sArgs, scripts...)
+}
+
+// importRoles creates the roles defined in schemaConfig.gameDb.roles, adds their members, and grants their
+// permissions.  Runs after the views and stored procedures so that grants on them can be applied.
+func importRoles(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	defer func() {
+		if err == nil {
+			logging.FromContext(ctx).InfoContext(ctx, "roles successfully imported")
+		}
+	}()
+	logging.FromContext(ctx).InfoContext(ctx, "importing roles")
+	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing roles"
+	scripts := []Script{}
+	for i := range driver.GenDbConfig.Roles {
+		script := Script{
+			Name: fmt.Sprintf(artifacts.ExportRoleFileNameFmt, driver.GenDbConfig.Roles[i].Name),
+		}
+		script.Sql, err = artifacts.GetCreateRoleScript(ctx, driver, i)
+		if err != nil {
+			return err
+		}
+
+		scripts = append(scripts, script)
+	}
+
 	return runScripts(ctx, driver, sArgs, scripts...)
 }
 
 // getSqlScripts returns the list of *.sql files from a given directory loaded into an array of Scripts
-func getSqlScripts(dir string) (sqlScripts []Script, err error) {
-	return getSqlScriptsByPattern(dir, mssql.SqlExtPattern)
+func getSqlScripts(ctx context.Context, dir string) (sqlScripts []Script, err error) {
+	return getSqlScriptsByPattern(ctx, dir, mssql.SqlExtPattern)
 }
 
 // getSqlScriptsByPattern returns the list of files from a directory matching the given pattern
-func getSqlScriptsByPattern(dir string, pattern string) (sqlScripts []Script, err error) {
+func getSqlScriptsByPattern(ctx context.Context, dir string, pattern string) (sqlScripts []Script, err error) {
 	if _, err = os.Stat(dir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory %s does not exist", dir)
+		return nil, &errs.FileSystemError{Op: "read", Path: dir, Err: fmt.Errorf("directory does not exist")}
 	}
 	fileNames, err := filepath.Glob(filepath.Join(dir, pattern))
 	if err != nil {
@@ -373,8 +504,9 @@ func getSqlScriptsByPattern(dir string, pattern string) (sqlScripts []Script, er
 		//fmt.Println(fmt.Sprintf("Reading %s", fileNames[i]))
 		sqlBytes, err := os.ReadFile(fileNames[i])
 		if err != nil {
-			return nil, err
+			return nil, &errs.FileSystemError{Op: "read", Path: fileNames[i], Err: err}
 		}
+		report.FileRead(ctx, fileNames[i])
 		script := Script{
 			Name: fileNames[i],
 			Sql:  string(sqlBytes),
@@ -399,12 +531,26 @@ func splitBatches(sql string) (batches []string) {
 	return batches
 }
 
-// isIgnoreErr checks an error to see if it can be ignored; These are errors related to
-// failed DROP statements after a database clean or new setup
-func isIgnoreErr(err error) bool {
-	if strings.HasPrefix(err.Error(), "mssql: Cannot drop the view") ||
-		strings.HasPrefix(err.Error(), "mssql: Cannot drop the procedure") {
-		return true
+// dropStatementReg matches the kind of object dropped by each DROP statement in a batch
+var dropStatementReg = regexp.MustCompile(`(?i)\bDROP\s+([a-z]+)\b`)
+
+// isIgnoreErr checks an error from running batch to see if it can be ignored; These are errors related to
+// failed DROP statements of views and procedures after a database clean or new setup.  The object kind is taken from
+// the batch, so failed drops of other objects, e.g. tables, schemas, or users, or batches mixing them aren't ignored.
+func isIgnoreErr(err error, batch string) bool {
+	if !mssql.IsErrorNumber(err, mssql.ErrNumCannotDropObject) {
+		return false
+	}
+	drops := dropStatementReg.FindAllStringSubmatch(batch, -1)
+	if len(drops) == 0 {
+		return false
+	}
+	for _, drop := range drops {
+		switch strings.ToUpper(drop[1]) {
+		case "VIEW", "PROC", "PROCEDURE":
+		default:
+			return false
+		}
 	}
-	return false
+	return true
 }
@@ -0,0 +1,39 @@
+package importDb
+
+import (
+	"errors"
+	"fmt"
+	mssqldb "github.com/microsoft/go-mssqldb"
+	"kodb-util/mssql"
+	"testing"
+)
+
+func TestIsIgnoreErr(t *testing.T) {
+	// the message is left empty: only the error number and the batch decide
+	cannotDrop := mssqldb.Error{Number: mssql.ErrNumCannotDropObject}
+	tests := []struct {
+		name  string
+		err   error
+		batch string
+		want  bool
+	}{
+		{name: "view", err: cannotDrop, batch: "DROP VIEW [dbo].[V_ITEM]", want: true},
+		{name: "procedure", err: cannotDrop, batch: "drop procedure LOAD_CHAR", want: true},
+		{name: "proc", err: cannotDrop, batch: "DROP PROC [LOAD_CHAR]", want: true},
+		{name: "several views", err: cannotDrop, batch: "DROP VIEW [V_ITEM]\nDROP VIEW [V_MAGIC]", want: true},
+		{name: "wrapped", err: fmt.Errorf("batch 1: %w", cannotDrop), batch: "DROP VIEW [V_ITEM]", want: true},
+		{name: "table", err: cannotDrop, batch: "DROP TABLE [ITEM]", want: false},
+		{name: "schema", err: cannotDrop, batch: "DROP SCHEMA [knight]", want: false},
+		{name: "view and table", err: cannotDrop, batch: "DROP VIEW [V_ITEM]\nDROP TABLE [ITEM]", want: false},
+		{name: "no drop", err: cannotDrop, batch: "CREATE VIEW [V_ITEM] AS SELECT 1 AS [A]", want: false},
+		{name: "other number", err: mssqldb.Error{Number: 2714}, batch: "DROP VIEW [V_ITEM]", want: false},
+		{name: "not from the server", err: errors.New("mssql: Cannot drop the view 'V_ITEM'"), batch: "DROP VIEW [V_ITEM]", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isIgnoreErr(test.err, test.batch); got != test.want {
+				t.Errorf("isIgnoreErr(%v, %q) = %v, want %v", test.err, test.batch, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package importDb
+
+import (
+	"context"
+	"fmt"
+	"github.com/Open-KO/OpenKO-gorm/kogen"
+	"gorm.io/gorm"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/safety"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	truncateTableSqlFmt = "TRUNCATE TABLE [%s]"
+	deleteTableSqlFmt   = "DELETE FROM [%s]"
+
+	// countReferencingKeysSql counts the foreign keys that reference a table; referenced tables can't be truncated
+	countReferencingKeysSql = "SELECT COUNT(*) FROM [sys].[foreign_keys] WHERE [referenced_object_id] = OBJECT_ID(?)"
+)
+
+// ObjectScriptKind is a kind of ManualSetup script that creates a single named object.  The object can be replaced in
+// an existing database by dropping it before running the script.
+type ObjectScriptKind struct {
+	Name        string // used in log messages, e.g. "views"
+	FileNameFmt string // artifacts export file name format the object name is read from
+	DropSqlFmt  string // 1. object name
+}
+
+var (
+	ViewScripts = ObjectScriptKind{
+		Name:        "views",
+		FileNameFmt: artifacts.ExportViewFileNameFmt,
+		DropSqlFmt:  "DROP VIEW IF EXISTS [%s]",
+	}
+	StoredProcScripts = ObjectScriptKind{
+		Name:        "stored procedures",
+		FileNameFmt: artifacts.ExportStoredProcedureFileNameFmt,
+		DropSqlFmt:  "DROP PROCEDURE IF EXISTS [%s]",
+	}
+)
+
+// ObjectName returns the name of the object created by the script file fileName, e.g. 7_CreateView_[name].sql
+func (this ObjectScriptKind) ObjectName(fileName string) string {
+	prefix, suffix, _ := strings.Cut(this.FileNameFmt, "%s")
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fileName), prefix), suffix)
+}
+
+// ReplaceScript drops the object created by script and runs the script, in the driver's top-level transaction.  The
+// configured role grants are applied again, since dropping the object removed its permissions.
+func ReplaceScript(ctx context.Context, driver *mssql.MssqlDbDriver, kind ObjectScriptKind, script Script) (err error) {
+	tx, err := driver.GetTx()
+	if err != nil {
+		return err
+	}
+	err = tx.WithContext(ctx).Exec(fmt.Sprintf(kind.DropSqlFmt, kind.ObjectName(script.Name))).Error
+	if err != nil {
+		return err
+	}
+	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "running " + filepath.Base(script.Name)
+	err = runScripts(ctx, driver, sArgs, script)
+	if err != nil {
+		return err
+	}
+	return reapplyRoles(ctx, driver)
+}
+
+// ImportViews replaces the views in an existing database with OpenKO-db/ManualSetup/7_CreateView_*.sql.  Nothing
+// else in the database is touched, apart from the configured role grants being applied again.
+func ImportViews(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	return replaceObjects(ctx, driver, ViewScripts)
+}
+
+// ImportStoredProcs replaces the stored procedures in an existing database with
+// OpenKO-db/ManualSetup/8_CreateStoredProc_*.sql.  Nothing else in the database is touched, apart from the configured
+// role grants being applied again.
+func ImportStoredProcs(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	return replaceObjects(ctx, driver, StoredProcScripts)
+}
+
+// replaceObjects drops every object with a script of kind, then runs the scripts and applies the role grants again, in
+// the driver's top-level transaction
+func replaceObjects(ctx context.Context, driver *mssql.MssqlDbDriver, kind ObjectScriptKind) (err error) {
+	defer func() {
+		if err == nil {
+			logging.FromContext(ctx).InfoContext(ctx, kind.Name+" successfully replaced")
+		}
+	}()
+	logging.FromContext(ctx).InfoContext(ctx, "replacing "+kind.Name, "db", driver.GenDbConfig.Name)
+	scripts, err := getSqlScriptsByPattern(ctx, filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir), fmt.Sprintf(kind.FileNameFmt, "*"))
+	if err != nil {
+		return err
+	}
+
+	tx, err := driver.GetTx()
+	if err != nil {
+		return err
+	}
+	tx = tx.WithContext(ctx)
+	// drop everything first so that scripts can reference objects created by later scripts
+	for i := range scripts {
+		err = tx.Exec(fmt.Sprintf(kind.DropSqlFmt, kind.ObjectName(scripts[i].Name))).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	sArgs := defaultScriptArgs()
+	sArgs.ProgressLabel = "importing " + kind.Name
+	err = runScripts(ctx, driver, sArgs, scripts...)
+	if err != nil {
+		return err
+	}
+	return reapplyRoles(ctx, driver)
+}
+
+// reapplyRoles runs the configured role scripts again after objects were replaced, restoring the grants on them
+func reapplyRoles(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+	if len(driver.GenDbConfig.Roles) == 0 {
+		return nil
+	}
+	return importRoles(ctx, driver)
+}
+
+// ImportData replaces the data of tables in an existing database with OpenKO-db/ManualSetup/6_InsertData_*.sql.  Each
+// table is emptied before its data is inserted, within the driver's top-level transaction.  Tables without an insert
+// dump are left empty.  Every table in the models is replaced when tables is empty.
+func ImportData(ctx context.Context, driver *mssql.MssqlDbDriver, tables []string) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "replacing table data", "db", driver.GenDbConfig.Name, "tables", len(tables))
+	start := time.Now()
+	tableNames, err := getModelTableNames(tables)
+	if err != nil {
+		return err
+	}
+	err = safety.CheckGuardedRows(ctx, driver, "import data", tableNames...)
+	if err != nil {
+		return err
+	}
+
+	tx, err := driver.GetTx()
+	if err != nil {
+		return err
+	}
+	tx = tx.WithContext(ctx)
+
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	scripts := []Script{}
+	for _, tableName := range tableNames {
+		err = clearTable(ctx, tx, tableName)
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.Join(manualSetupPath, fmt.Sprintf(artifacts.ExportTableDataFileNameFmt, tableName))
+		if _, statErr := os.Stat(fileName); os.IsNotExist(statErr) {
+			logging.FromContext(ctx).DebugContext(ctx, "no data to insert", "table", tableName)
+			continue
+		}
+		tableScripts, err := getSqlScriptsByPattern(ctx, manualSetupPath, filepath.Base(fileName))
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, tableScripts...)
+	}
+
+	args := defaultScriptArgs()
+	args.IsDataDump = true
+	args.ProgressLabel = "importing table data"
+	err = runScripts(ctx, driver, args, scripts...)
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "table data successfully replaced", "tables", len(tableNames), "elapsed", time.Since(start), "batchSize", importBatchSize(driver))
+	return nil
+}
+
+// getModelTableNames returns the model table names matching tables, ignoring case, or every model table if tables is
+// empty.  Names that don't match a model are an error.
+func getModelTableNames(tables []string) (tableNames []string, err error) {
+	if len(tables) == 0 {
+		for i := range kogen.ModelList {
+			tableNames = append(tableNames, kogen.ModelList[i].TableName())
+		}
+		return tableNames, nil
+	}
+
+	unknown := []string{}
+	for _, table := range tables {
+		found := false
+		for i := range kogen.ModelList {
+			if strings.EqualFold(kogen.ModelList[i].TableName(), table) {
+				tableNames = append(tableNames, kogen.ModelList[i].TableName())
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, table)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &errs.ArgsError{Err: fmt.Errorf("unknown tables: %s", strings.Join(unknown, ", "))}
+	}
+	return tableNames, nil
+}
+
+// clearTable removes every row from tableName.  TRUNCATE is used unless a foreign key references the table, which
+// SQL Server doesn't allow, in which case the rows are deleted.
+func clearTable(ctx context.Context, tx *gorm.DB, tableName string) (err error) {
+	var referencingKeys int
+	err = tx.Raw(countReferencingKeysSql, tableName).Scan(&referencingKeys).Error
+	if err != nil {
+		return err
+	}
+
+	if referencingKeys > 0 {
+		result := tx.Exec(fmt.Sprintf(deleteTableSqlFmt, tableName))
+		if result.Error != nil {
+			return result.Error
+		}
+		logging.FromContext(ctx).DebugContext(ctx, "deleted table rows", "table", tableName, "rows", result.RowsAffected)
+		return nil
+	}
+
+	err = tx.Exec(fmt.Sprintf(truncateTableSqlFmt, tableName)).Error
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "truncated table", "table", tableName)
+	return nil
+}
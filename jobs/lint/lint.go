@@ -0,0 +1,285 @@
+package lint
+
+import (
+	"context"
+	"fmt"
+	"github.com/Open-KO/kodb-godef/enums/tsql"
+	"go/token"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxFixedLength is the largest explicit length MSSQL accepts for char/varchar/binary/varbinary
+	maxFixedLength = 8000
+
+	// maxUnicodeLength is the largest explicit length MSSQL accepts for nchar/nvarchar
+	maxUnicodeLength = 4000
+
+	// maxLength is the length the database reports, and the exporter keeps, for varchar/nvarchar/varbinary(max)
+	maxLength = -1
+)
+
+// Problem is a single lint finding against a jsonSchema file
+type Problem struct {
+	File    string
+	Message string
+}
+
+func (this Problem) String() string {
+	if this.File == "" {
+		return this.Message
+	}
+	return fmt.Sprintf("%s: %s", filepath.Base(this.File), this.Message)
+}
+
+// JsonSchema loads every jsonSchema/*.json and jsonSchema/procedures/*.json file of the OpenKO-db project in
+// schemaDir and checks them for problems that would break or degrade code generation.  Does not require a database
+// connection.  Returns an error if any problems were found.
+func JsonSchema(ctx context.Context, schemaDir string) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "linting jsonSchema", "dir", artifacts.JsonSchemaPath(schemaDir))
+
+	problems, err := CheckJsonSchema(schemaDir)
+	if err != nil {
+		return err
+	}
+
+	for i := range problems {
+		logging.FromContext(ctx).WarnContext(ctx, "lint problem", "file", problems[i].File, "problem", problems[i].Message)
+	}
+
+	if len(problems) > 0 {
+		return &errs.ValidationError{Check: "lintSchema", Problems: len(problems), Err: fmt.Errorf("jsonSchema lint found %d problem(s)", len(problems))}
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "jsonSchema lint passed")
+	return nil
+}
+
+// CheckJsonSchema returns the list of lint problems found in the jsonSchema directory of schemaDir
+func CheckJsonSchema(schemaDir string) (problems []Problem, err error) {
+	tableDefs, parseErrs, err := artifacts.LoadTableDefs(schemaDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range parseErrs {
+		problems = append(problems, Problem{Message: parseErrs[i].Error()})
+	}
+	if len(tableDefs) == 0 && len(parseErrs) == 0 {
+		return nil, fmt.Errorf("no jsonSchema files found in %s", artifacts.JsonSchemaPath(schemaDir))
+	}
+
+	procDefs, parseErrs, err := artifacts.LoadProcDefs(schemaDir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range parseErrs {
+		problems = append(problems, Problem{Message: parseErrs[i].Error()})
+	}
+
+	// class names end up as type names in generated code; they must be unique per definition type
+	tableClassNames := map[string]string{}
+	for i := range tableDefs {
+		problems = append(problems, checkTableDef(tableDefs[i])...)
+		problems = append(problems, checkDuplicate(tableClassNames, tableDefs[i].Path, "className", tableDefs[i].Def.ClassName)...)
+	}
+
+	procClassNames := map[string]string{}
+	for i := range procDefs {
+		problems = append(problems, checkProcDef(procDefs[i])...)
+		problems = append(problems, checkDuplicate(procClassNames, procDefs[i].Path, "className", procDefs[i].Def.ClassName)...)
+	}
+
+	return problems, nil
+}
+
+// checkTableDef runs the per-file checks for a table definition
+func checkTableDef(file artifacts.TableDefFile) (problems []Problem) {
+	def := file.Def
+	add := func(format string, a ...any) {
+		problems = append(problems, Problem{File: file.Path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if def.Name == "" {
+		add("table name is empty")
+	}
+	checkTodo(add, "className", def.ClassName)
+	checkTodo(add, "description", def.Description)
+	checkIdentifier(add, "className", def.ClassName)
+
+	if len(def.Columns) == 0 {
+		add("table has no columns")
+	}
+
+	columnNames := map[string]bool{}
+	propertyNames := map[string]string{}
+	for i := range def.Columns {
+		col := def.Columns[i]
+		label := fmt.Sprintf("column %s", col.Name)
+
+		lowerName := strings.ToLower(col.Name)
+		if columnNames[lowerName] {
+			add("%s is defined more than once", label)
+		}
+		columnNames[lowerName] = true
+
+		checkTodo(add, label+" propertyName", col.PropertyName)
+		checkTodo(add, label+" description", col.Description)
+		checkIdentifier(add, label+" propertyName", col.PropertyName)
+		if prev, ok := propertyNames[col.PropertyName]; ok && col.PropertyName != artifacts.JsonSchemaTodoMarker {
+			add("%s propertyName %s duplicates column %s", label, col.PropertyName, prev)
+		} else {
+			propertyNames[col.PropertyName] = col.Name
+		}
+
+		if msg := checkTypeLength(col.Type, col.Length); msg != "" {
+			add("%s %s", label, msg)
+		}
+	}
+
+	// unions generate properties alongside the columns, so share the same namespace
+	for i := range def.Unions {
+		label := fmt.Sprintf("union %s", def.Unions[i].ColumnPattern)
+		checkTodo(add, label+" propertyName", def.Unions[i].PropertyName)
+		checkIdentifier(add, label+" propertyName", def.Unions[i].PropertyName)
+		if prev, ok := propertyNames[def.Unions[i].PropertyName]; ok {
+			add("%s propertyName %s duplicates column %s", label, def.Unions[i].PropertyName, prev)
+		} else {
+			propertyNames[def.Unions[i].PropertyName] = def.Unions[i].ColumnPattern
+		}
+	}
+
+	for i := range def.Indexes {
+		if len(def.Indexes[i].Columns) == 0 {
+			add("index %s has no columns", def.Indexes[i].Name)
+		}
+		for j := range def.Indexes[i].Columns {
+			if !columnNames[strings.ToLower(def.Indexes[i].Columns[j])] {
+				add("index %s references unknown column %s", def.Indexes[i].Name, def.Indexes[i].Columns[j])
+			}
+		}
+	}
+
+	for i := range def.Exports {
+		for _, colName := range slices.Concat(def.Exports[i].Columns, def.Exports[i].Exclude) {
+			if !columnNames[strings.ToLower(colName)] {
+				add("export %s references unknown column %s", def.Exports[i].Namespace, colName)
+			}
+		}
+	}
+
+	return problems
+}
+
+// checkProcDef runs the per-file checks for a stored procedure definition
+func checkProcDef(file artifacts.ProcDefFile) (problems []Problem) {
+	def := file.Def
+	add := func(format string, a ...any) {
+		problems = append(problems, Problem{File: file.Path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if def.Name == "" {
+		add("procedure name is empty")
+	}
+	checkTodo(add, "className", def.ClassName)
+	checkTodo(add, "description", def.Description)
+	checkIdentifier(add, "className", def.ClassName)
+
+	paramNames := map[string]string{}
+	for i := range def.Params {
+		param := def.Params[i]
+		label := fmt.Sprintf("param %s", param.Name)
+
+		checkTodo(add, label+" paramName", param.ParamName)
+		checkTodo(add, label+" description", param.Description)
+		checkIdentifier(add, label+" paramName", param.ParamName)
+		if prev, ok := paramNames[param.ParamName]; ok && param.ParamName != artifacts.JsonSchemaTodoMarker {
+			add("%s paramName %s duplicates param %s", label, param.ParamName, prev)
+		} else {
+			paramNames[param.ParamName] = param.Name
+		}
+
+		// sys.parameters reports max_length in bytes for every type, so only the type itself can be checked here
+		if !isKnownType(param.Type) {
+			add("%s has unsupported type %q", label, param.Type)
+		}
+	}
+
+	return problems
+}
+
+// checkDuplicate records value in seen and returns a problem if another file already used it
+func checkDuplicate(seen map[string]string, path string, field string, value string) []Problem {
+	if value == "" || value == artifacts.JsonSchemaTodoMarker {
+		return nil
+	}
+	if prev, ok := seen[value]; ok {
+		return []Problem{{File: path, Message: fmt.Sprintf("%s %s duplicates %s", field, value, filepath.Base(prev))}}
+	}
+	seen[value] = path
+	return nil
+}
+
+// checkTodo reports values that still contain the exporter's stub marker
+func checkTodo(add func(string, ...any), field string, value string) {
+	if strings.Contains(value, artifacts.JsonSchemaTodoMarker) {
+		add("%s is %s", field, artifacts.JsonSchemaTodoMarker)
+	}
+}
+
+// checkIdentifier reports values that can't be used as a Go identifier
+func checkIdentifier(add func(string, ...any), field string, value string) {
+	if value == "" {
+		add("%s is empty", field)
+		return
+	}
+	if !token.IsIdentifier(value) {
+		add("%s %q is not a valid Go identifier", field, value)
+	}
+}
+
+// checkTypeLength returns a description of the problem if the length isn't valid for the type, or "" if it is
+func checkTypeLength(sqlType tsql.TSqlType, length int) string {
+	switch sqlType {
+	case tsql.Char, tsql.Binary:
+		if length <= 0 || length > maxFixedLength {
+			return fmt.Sprintf("type %s requires a length in the range [1-%d], got %d", sqlType, maxFixedLength, length)
+		}
+	case tsql.Varchar, tsql.VarBinary:
+		if length != maxLength && (length <= 0 || length > maxFixedLength) {
+			return fmt.Sprintf("type %s requires a length in the range [1-%d] or %d for (max), got %d", sqlType, maxFixedLength, maxLength, length)
+		}
+	case tsql.NChar:
+		if length <= 0 || length > maxUnicodeLength {
+			return fmt.Sprintf("type %s requires a length in the range [1-%d], got %d", sqlType, maxUnicodeLength, length)
+		}
+	case tsql.NVarchar:
+		if length != maxLength && (length <= 0 || length > maxUnicodeLength) {
+			return fmt.Sprintf("type %s requires a length in the range [1-%d] or %d for (max), got %d", sqlType, maxUnicodeLength, maxLength, length)
+		}
+	case tsql.TinyInt, tsql.SmallInt, tsql.Int, tsql.BigInt, tsql.Float, tsql.Real,
+		tsql.SmallDateTime, tsql.DateTime, tsql.Text, tsql.Image:
+		if length != 0 {
+			return fmt.Sprintf("type %s does not take a length, got %d", sqlType, length)
+		}
+	default:
+		return fmt.Sprintf("has unsupported type %q", sqlType)
+	}
+
+	return ""
+}
+
+// isKnownType returns true if sqlType is one of the tsql.TSqlType values code generation supports
+func isKnownType(sqlType tsql.TSqlType) bool {
+	switch sqlType {
+	case tsql.TinyInt, tsql.SmallInt, tsql.Int, tsql.BigInt, tsql.Float, tsql.Real,
+		tsql.Char, tsql.Varchar, tsql.NChar, tsql.NVarchar, tsql.Binary, tsql.VarBinary,
+		tsql.SmallDateTime, tsql.DateTime, tsql.Text, tsql.Image:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package lint
+
+import (
+	"github.com/Open-KO/kodb-godef/enums/tsql"
+	"testing"
+)
+
+func TestCheckTypeLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		sqlType tsql.TSqlType
+		length  int
+		wantOk  bool
+	}{
+		{name: "char", sqlType: tsql.Char, length: 21, wantOk: true},
+		{name: "char without length", sqlType: tsql.Char, length: 0},
+		{name: "char max", sqlType: tsql.Char, length: maxLength},
+		{name: "varchar max", sqlType: tsql.Varchar, length: maxLength, wantOk: true},
+		{name: "varchar too long", sqlType: tsql.Varchar, length: maxFixedLength + 1},
+		{name: "varbinary max", sqlType: tsql.VarBinary, length: maxLength, wantOk: true},
+		{name: "binary max", sqlType: tsql.Binary, length: maxLength},
+		{name: "nvarchar", sqlType: tsql.NVarchar, length: maxUnicodeLength, wantOk: true},
+		{name: "nvarchar max", sqlType: tsql.NVarchar, length: maxLength, wantOk: true},
+		{name: "nvarchar too long", sqlType: tsql.NVarchar, length: maxUnicodeLength + 1},
+		{name: "nchar max", sqlType: tsql.NChar, length: maxLength},
+		{name: "int", sqlType: tsql.Int, length: 0, wantOk: true},
+		{name: "int with length", sqlType: tsql.Int, length: 4},
+		{name: "unsupported", sqlType: tsql.TSqlType("xml"), length: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problem := checkTypeLength(test.sqlType, test.length)
+			if (problem == "") != test.wantOk {
+				t.Errorf("checkTypeLength(%s, %d) = %q, want ok %v", test.sqlType, test.length, problem, test.wantOk)
+			}
+		})
+	}
+}
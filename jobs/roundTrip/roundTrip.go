@@ -0,0 +1,250 @@
+package roundTrip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/jobs/clean"
+	"kodb-util/jobs/export"
+	"kodb-util/jobs/importDb"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// 1: Configured database name
+	// 2: Unix timestamp
+	// scratchDbNameFmt is used to generate the name of the database the round trip imports into
+	scratchDbNameFmt = "%s_RoundTrip_%d"
+
+	// tempDirPattern is passed to os.MkdirTemp for the export output directory
+	tempDirPattern = "kodb-util-roundtrip-*"
+)
+
+// compareDirs are the OpenKO-db directories (relative to SchemaDir) and file patterns produced by the exporters
+var compareDirs = []struct {
+	Dir     string
+	Pattern string
+}{
+	{Dir: artifacts.ManualSetupDir, Pattern: "[1-9][_]*.sql"},
+	{Dir: artifacts.JsonSchemaDir, Pattern: artifacts.JsonSchemaSearchPattern},
+	{Dir: filepath.Join(artifacts.JsonSchemaDir, artifacts.JsonSchemaProceduresDir), Pattern: artifacts.JsonSchemaSearchPattern},
+}
+
+// RoundTrip imports OpenKO-db into a scratch database, exports everything into a temporary directory, and byte-compares
+// the files the exporters wrote with the source ManualSetup and jsonSchema trees.  The scratch database is always dropped afterwards;
+// the temporary directory is kept when differences are found so that they can be inspected.
+// Server-level logins are shared with the configured database, so they are neither created nor dropped.
+func RoundTrip(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "starting round trip", "db", driver.GenDbConfig.Name)
+
+	sourceDir := driver.SchemaDir()
+	scratchConfig := driver.GenDbConfig
+	scratchConfig.Name = fmt.Sprintf(scratchDbNameFmt, driver.GenDbConfig.Name, time.Now().Unix())
+	// the exporters write to the temporary directory through a copy of the configuration, leaving the run's untouched
+	exportConf := *driver.Config
+	scratchDriver := mssql.NewMssqlDbDriver(ctx, &exportConf, scratchConfig, driver.DbType, driver.Options)
+
+	tempDir, err := os.MkdirTemp("", tempDirPattern)
+	if err != nil {
+		return err
+	}
+
+	isDiff := false
+	defer func() {
+		// put everything back the way processDb left it
+		mssql.SetModelDbName(driver.GenDbConfig.Name)
+
+		// an open transaction or connection would keep the database in use; nothing to rollback on success
+		_ = scratchDriver.RollbackTx()
+		scratchDriver.CloseConnection()
+		// the scratch database should still be dropped when the run was cancelled
+		dropErr := clean.DropDatabase(context.WithoutCancel(ctx), scratchDriver)
+		if dropErr != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to drop scratch database", "db", scratchConfig.Name, "error", dropErr)
+			if err == nil {
+				err = dropErr
+			}
+		}
+
+		if isDiff {
+			logging.FromContext(ctx).InfoContext(ctx, "export output kept", "dir", tempDir)
+		} else if rmErr := os.RemoveAll(tempDir); rmErr != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to remove temporary directory", "dir", tempDir, "error", rmErr)
+		}
+	}()
+
+	mssql.SetModelDbName(scratchConfig.Name)
+
+	importArgs := importDb.ImportArgs{IsSkipLogins: true}
+	err = importDb.ImportDbWithArgs(ctx, scratchDriver, importArgs)
+	if err != nil {
+		return err
+	}
+	err = scratchDriver.CommitTx()
+	if err != nil {
+		return err
+	}
+
+	// the jsonSchema exporter merges into existing files and the structure exporter reads templates, so seed
+	// the output directory with the source copies of both.  Only the files the exporters report writing are compared,
+	// so a seeded jsonSchema file that export no longer writes isn't mistaken for its output.
+	err = copyDir(filepath.Join(sourceDir, artifacts.TemplatesDir), filepath.Join(tempDir, artifacts.TemplatesDir))
+	if err != nil {
+		return err
+	}
+	err = copyDir(filepath.Join(sourceDir, artifacts.JsonSchemaDir), filepath.Join(tempDir, artifacts.JsonSchemaDir))
+	if err != nil {
+		return err
+	}
+
+	exportConf.GenConfig.SchemaDir = tempDir
+	exportCtx, writes := report.WithWrites(ctx)
+	exportJobs := []func(context.Context, *mssql.MssqlDbDriver) error{
+		export.JsonSchema,
+		export.Structure,
+		export.TableData,
+		export.Views,
+		export.StoredProcedures,
+	}
+	for i := range exportJobs {
+		err = exportJobs[i](exportCtx, scratchDriver)
+		if err != nil {
+			return err
+		}
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "comparing round trip output")
+	diffs, err := compareTrees(sourceDir, tempDir, writes.Paths(), scratchConfig.Name, driver.GenDbConfig.Name)
+	if err != nil {
+		return err
+	}
+	for i := range diffs {
+		report.Warn(ctx, "round trip difference", "diff", diffs[i])
+	}
+	if len(diffs) > 0 {
+		isDiff = true
+		return &errs.ValidationError{Check: "roundTrip", Problems: len(diffs), Err: fmt.Errorf("round trip found %d file(s) that differ", len(diffs))}
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "round trip output matches source")
+	return nil
+}
+
+// compareTrees compares the exporter output in exportDir, the written files, against sourceDir.  The scratch database
+// name is replaced with the configured name in exported file names and contents before comparing.
+func compareTrees(sourceDir string, exportDir string, written []string, scratchName string, dbName string) (diffs []string, err error) {
+	for _, cmp := range compareDirs {
+		sourceFiles, err := listFiles(filepath.Join(sourceDir, cmp.Dir), cmp.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		exportFiles, err := listFiles(filepath.Join(exportDir, cmp.Dir), cmp.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		exportByName := map[string]string{}
+		for i := range exportFiles {
+			if !slices.Contains(written, filepath.Join(exportDir, cmp.Dir, exportFiles[i])) {
+				// seeded from the source, but not written by export
+				continue
+			}
+			exportByName[strings.ReplaceAll(exportFiles[i], scratchName, dbName)] = exportFiles[i]
+		}
+
+		names := slices.Clone(sourceFiles)
+		for name := range exportByName {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			relPath := filepath.Join(cmp.Dir, name)
+			exportName, isExported := exportByName[name]
+			if !isExported {
+				diffs = append(diffs, fmt.Sprintf("%s: not produced by export", relPath))
+				continue
+			}
+			if !slices.Contains(sourceFiles, name) {
+				diffs = append(diffs, fmt.Sprintf("%s: produced by export but missing from source", relPath))
+				continue
+			}
+
+			sourceBytes, err := os.ReadFile(filepath.Join(sourceDir, cmp.Dir, name))
+			if err != nil {
+				return nil, err
+			}
+			exportBytes, err := os.ReadFile(filepath.Join(exportDir, cmp.Dir, exportName))
+			if err != nil {
+				return nil, err
+			}
+			exportBytes = bytes.ReplaceAll(exportBytes, []byte(scratchName), []byte(dbName))
+
+			if !bytes.Equal(sourceBytes, exportBytes) {
+				diffs = append(diffs, fmt.Sprintf("%s: differs at line %d", relPath, firstDiffLine(sourceBytes, exportBytes)))
+			}
+		}
+	}
+
+	return diffs, nil
+}
+
+// listFiles returns the base names of the files in dir matching pattern; a missing dir has no files
+func listFiles(dir string, pattern string) (names []string, err error) {
+	fileNames, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i := range fileNames {
+		names = append(names, filepath.Base(fileNames[i]))
+	}
+	return names, nil
+}
+
+// firstDiffLine returns the 1-based line number of the first difference between a and b
+func firstDiffLine(a []byte, b []byte) int {
+	line := 1
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return line
+		}
+		if a[i] == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+// copyDir recursively copies the contents of src into dst
+func copyDir(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		fileBytes, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, fileBytes, 0644)
+	})
+}
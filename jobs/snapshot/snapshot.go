@@ -0,0 +1,210 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"kodb-util/safety"
+	"strings"
+	"time"
+)
+
+const (
+	// 1. database name 2. snapshot name
+	snapshotDbNameFmt = "%s_snapshot_%s"
+
+	// 1. snapshot database name 2. logical file name
+	snapshotFileNameFmt = "%s_%s.ss"
+
+	// a snapshot needs a sparse file for each of the source database's data files; log files aren't included
+	dataFilesSql = "SELECT [name], [physical_name] FROM [sys].[master_files] WHERE [database_id] = DB_ID(?) AND [type] = 0"
+
+	listSnapshotsSql = "SELECT [name], [create_date] FROM [sys].[databases] WHERE [source_database_id] = DB_ID(?) ORDER BY [create_date]"
+
+	// 1. snapshot database name 2. file list 3. database name
+	createSnapshotSqlFmt = "CREATE DATABASE [%s] ON %s AS SNAPSHOT OF [%s]"
+
+	// 1. logical file name 2. file literal
+	snapshotFileSqlFmt = "(NAME = [%s], FILENAME = %s)"
+
+	// 1. database name 2. snapshot database name literal
+	revertSqlFmt = "RESTORE DATABASE [%s] FROM DATABASE_SNAPSHOT = %s"
+
+	// 1. database name
+	singleUserSqlFmt = "ALTER DATABASE [%s] SET SINGLE_USER WITH ROLLBACK IMMEDIATE"
+	multiUserSqlFmt  = "ALTER DATABASE [%s] SET MULTI_USER"
+
+	// 1. snapshot database name
+	dropSnapshotSqlFmt = "DROP DATABASE [%s]"
+)
+
+// dataFile is a row of sys.master_files
+type dataFile struct {
+	Name         string
+	PhysicalName string
+}
+
+// Snapshot is a database snapshot of a configured database
+type Snapshot struct {
+	Name       string // name given to -snapshot; the full database name if it wasn't created by kodb-util
+	Database   string // name of the snapshot database
+	CreateDate time.Time
+}
+
+// DatabaseName returns the name of the snapshot database for the driver's database and snapshot name
+func DatabaseName(dbName string, name string) string {
+	return fmt.Sprintf(snapshotDbNameFmt, dbName, name)
+}
+
+// Create takes a snapshot of the driver's database named name.  The snapshot's sparse files are created next to the
+// database's data files.
+func Create(ctx context.Context, driver *mssql.MssqlDbDriver, name string) (err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+	snapshotDbName := DatabaseName(dbName, name)
+
+	files := []dataFile{}
+	err = conn.Raw(dataFilesSql, dbName).Scan(&files).Error
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("database %s does not exist", dbName)
+	}
+	fileSql := []string{}
+	for _, file := range files {
+		path := mssql.JoinServerPath(mssql.ServerPathDir(file.PhysicalName), fmt.Sprintf(snapshotFileNameFmt, snapshotDbName, file.Name))
+		fileSql = append(fileSql, fmt.Sprintf(snapshotFileSqlFmt, file.Name, mssql.QuoteString(path)))
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "creating snapshot", "db", dbName, "snapshot", name)
+	err = conn.Exec(fmt.Sprintf(createSnapshotSqlFmt, snapshotDbName, strings.Join(fileSql, ", "), dbName)).Error
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot %s of %s: %w", name, dbName, err)
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "created snapshot", "db", dbName, "snapshot", name, "snapshotDb", snapshotDbName)
+	return nil
+}
+
+// Revert replaces the driver's database with the snapshot named name.  The snapshot is kept, so the database can be
+// reverted to it again.  SQL Server can't revert a database that has more than one snapshot.
+func Revert(ctx context.Context, driver *mssql.MssqlDbDriver, name string) (err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+	snapshotDbName := DatabaseName(dbName, name)
+
+	snapshotNames, err := mssql.SnapshotNames(ctx, conn, dbName)
+	if err != nil {
+		return err
+	}
+	found := false
+	others := []string{}
+	for _, snapshotName := range snapshotNames {
+		if strings.EqualFold(snapshotName, snapshotDbName) {
+			found = true
+		} else {
+			others = append(others, snapshotName)
+		}
+	}
+	if !found {
+		return fmt.Errorf("database %s has no snapshot named %s", dbName, name)
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("cannot revert %s while it has other snapshots; drop them first: %s", dbName, strings.Join(others, ", "))
+	}
+
+	err = safety.CheckGuardedRows(ctx, driver, "revert")
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "reverting database to snapshot", "db", dbName, "snapshot", name)
+	start := time.Now()
+	// reverting needs exclusive access; open transactions are rolled back
+	err = conn.Exec(fmt.Sprintf(singleUserSqlFmt, dbName)).Error
+	if err != nil {
+		return err
+	}
+	revertErr := conn.Exec(fmt.Sprintf(revertSqlFmt, dbName, mssql.QuoteString(snapshotDbName))).Error
+	err = conn.Exec(fmt.Sprintf(multiUserSqlFmt, dbName)).Error
+	if revertErr != nil {
+		if err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to set database back to multi-user", "db", dbName, "error", err)
+		}
+		return fmt.Errorf("failed to revert %s to snapshot %s: %w", dbName, name, revertErr)
+	}
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "reverted database to snapshot", "db", dbName, "snapshot", name, "elapsed", time.Since(start))
+	return nil
+}
+
+// List returns the snapshots of the driver's database, oldest first, and logs each one
+func List(ctx context.Context, driver *mssql.MssqlDbDriver) (snapshots []Snapshot, err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return nil, err
+	}
+	dbName := driver.GenDbConfig.Name
+
+	rows := []struct {
+		Name       string
+		CreateDate time.Time
+	}{}
+	err = conn.WithContext(ctx).Raw(listSnapshotsSql, dbName).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	prefix := DatabaseName(dbName, "")
+	for _, row := range rows {
+		snapshot := Snapshot{Name: row.Name, Database: row.Name, CreateDate: row.CreateDate}
+		if len(row.Name) > len(prefix) && strings.EqualFold(row.Name[:len(prefix)], prefix) {
+			snapshot.Name = row.Name[len(prefix):]
+		}
+		snapshots = append(snapshots, snapshot)
+		logging.FromContext(ctx).InfoContext(ctx, "snapshot", "db", dbName, "snapshot", snapshot.Name, "snapshotDb", snapshot.Database, "created", snapshot.CreateDate)
+	}
+	if len(snapshots) == 0 {
+		logging.FromContext(ctx).InfoContext(ctx, "database has no snapshots", "db", dbName)
+	}
+	return snapshots, nil
+}
+
+// Drop removes the snapshot named name of the driver's database
+func Drop(ctx context.Context, driver *mssql.MssqlDbDriver, name string) (err error) {
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+	snapshotDbName := DatabaseName(dbName, name)
+
+	snapshotNames, err := mssql.SnapshotNames(ctx, conn, dbName)
+	if err != nil {
+		return err
+	}
+	for _, snapshotName := range snapshotNames {
+		if strings.EqualFold(snapshotName, snapshotDbName) {
+			err = conn.Exec(fmt.Sprintf(dropSnapshotSqlFmt, snapshotName)).Error
+			if err != nil {
+				return err
+			}
+			logging.FromContext(ctx).InfoContext(ctx, "dropped snapshot", "db", dbName, "snapshot", name)
+			return nil
+		}
+	}
+	report.Warn(ctx, "snapshot not found", "db", dbName, "snapshot", name)
+	return nil
+}
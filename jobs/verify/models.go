@@ -0,0 +1,550 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"github.com/Open-KO/OpenKO-gorm/kogen"
+	"gorm.io/gorm"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// source labels used in the mismatch report
+	srcModel      = "kogen"
+	srcJsonSchema = "jsonSchema"
+	srcDb         = "db"
+
+	// getTableNamesSql pulls a list of all our gameDb table names (dbo schema only) from the INFORMATION_SCHEMA
+	getTableNamesSql = `SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = 'dbo' and TABLE_TYPE = 'BASE TABLE'`
+
+	// 1: Table name
+	// getColumnsSqlFmt selects the column properties we compare against the models and jsonSchema
+	getColumnsSqlFmt = `SELECT
+	cols.COLUMN_NAME,
+	cols.COLUMN_DEFAULT,
+	cols.IS_NULLABLE,
+	cols.DATA_TYPE,
+	cols.CHARACTER_MAXIMUM_LENGTH
+FROM INFORMATION_SCHEMA.COLUMNS as cols
+where
+	cols.TABLE_SCHEMA = 'dbo' and
+	cols.TABLE_NAME = '%s'
+ORDER BY ORDINAL_POSITION`
+
+	// 1: Table name
+	// getIndexesSqlFmt selects index information for a given table
+	getIndexesSqlFmt = `SELECT
+	[index_id],
+	[name],
+	[type_desc],
+	[is_unique],
+	[is_primary_key]
+FROM [sys].[indexes]
+WHERE
+	[type_desc] <> 'HEAP' and
+	[object_id] = OBJECT_ID('[dbo].[%s]')`
+
+	// 1: Table name
+	// 2: Index id
+	// getIndexColumnsSqlFmt returns the key columns of an index in key order.  Unlike CONSTRAINT_COLUMN_USAGE this
+	// also covers indexes that aren't backed by a constraint.
+	getIndexColumnsSqlFmt = `SELECT [c].[name]
+FROM [sys].[index_columns] as [ic]
+	INNER JOIN [sys].[columns] as [c] on [c].[object_id] = [ic].[object_id] and [c].[column_id] = [ic].[column_id]
+WHERE
+	[ic].[object_id] = OBJECT_ID('[dbo].[%[1]s]') and
+	[ic].[index_id] = %[2]d and
+	[ic].[is_included_column] = 0
+ORDER BY [ic].[key_ordinal]`
+
+	// maxSpecifiedLength is the largest length MSSQL reports for a sized column; anything above is a max/blob type
+	maxSpecifiedLength = 8000
+)
+
+var (
+	// regex used to pull the table shape out of kogen's GetCreateTableString output
+	createTableReg   = regexp.MustCompile(`^CREATE TABLE \[([^\]]+)\]`)
+	columnLineReg    = regexp.MustCompile(`^\t\[([^\]]+)\] ([a-zA-Z]+)(?:\((\d+|max)\))?(?: COLLATE \S+)?( NOT NULL)?,?$`)
+	primaryKeyReg    = regexp.MustCompile(`^\tCONSTRAINT \[([^\]]+)\] PRIMARY KEY (CLUSTERED|NONCLUSTERED) \((.*)\)$`)
+	defaultReg       = regexp.MustCompile(`^ALTER TABLE \[[^\]]+\] ADD CONSTRAINT \[[^\]]+\] DEFAULT (.*) FOR \[([^\]]+)\]$`)
+	createIndexReg   = regexp.MustCompile(`^CREATE (UNIQUE )?(CLUSTERED |NONCLUSTERED )?INDEX \[([^\]]+)\] ON \[[^\]]+\] \((.*)\)`)
+	bracketedNameReg = regexp.MustCompile(`\[([^\]]+)\]`)
+)
+
+// tableShape is the normalized description of a table that each source is converted into for comparison
+type tableShape struct {
+	Name    string
+	Columns []columnShape
+	Indexes []indexShape
+}
+
+type columnShape struct {
+	Name      string
+	Type      string
+	Length    int
+	AllowNull bool
+	Default   string
+}
+
+type indexShape struct {
+	Name         string
+	Type         string
+	IsUnique     bool
+	IsPrimaryKey bool
+	Columns      []string
+}
+
+// dbColumn binds to the result of the getColumnsSqlFmt query
+type dbColumn struct {
+	Name       string  `gorm:"column:COLUMN_NAME"`
+	DefaultVal *string `gorm:"column:COLUMN_DEFAULT"`
+	AllowNull  string  `gorm:"column:IS_NULLABLE"`
+	Type       string  `gorm:"column:DATA_TYPE"`
+	Length     *int    `gorm:"column:CHARACTER_MAXIMUM_LENGTH"`
+}
+
+// dbIndex binds to the result of the getIndexesSqlFmt query
+type dbIndex struct {
+	IndexId      int    `gorm:"column:index_id"`
+	Name         string `gorm:"column:name"`
+	Type         string `gorm:"column:type_desc"`
+	IsUnique     bool   `gorm:"column:is_unique"`
+	IsPrimaryKey bool   `gorm:"column:is_primary_key"`
+}
+
+// Models compares the table definitions implied by kogen.ModelList, OpenKO-db/jsonSchema, and the live database and
+// prints a per-table mismatch report.  Returns an error if any table doesn't agree across all three sources.
+func Models(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "verifying models", "db", driver.GenDbConfig.Name)
+
+	gormConn, err := driver.GetConnection()
+	if err != nil {
+		return err
+	}
+	gormConn = gormConn.WithContext(ctx)
+
+	modelShapes, err := getModelShapes()
+	if err != nil {
+		return err
+	}
+
+	jsonShapes, err := getJsonSchemaShapes(driver.SchemaDir())
+	if err != nil {
+		return err
+	}
+
+	dbShapes, err := getDbShapes(gormConn)
+	if err != nil {
+		return err
+	}
+
+	// build a sorted, case-insensitive union of the table names in every source
+	tableKeys := []string{}
+	for _, shapes := range []map[string]tableShape{modelShapes, jsonShapes, dbShapes} {
+		for key := range shapes {
+			if !slices.Contains(tableKeys, key) {
+				tableKeys = append(tableKeys, key)
+			}
+		}
+	}
+	slices.Sort(tableKeys)
+
+	mismatchedTables := 0
+	for _, key := range tableKeys {
+		sources := map[string]*tableShape{}
+		if shape, ok := modelShapes[key]; ok {
+			sources[srcModel] = &shape
+		}
+		if shape, ok := jsonShapes[key]; ok {
+			sources[srcJsonSchema] = &shape
+		}
+		if shape, ok := dbShapes[key]; ok {
+			sources[srcDb] = &shape
+		}
+
+		mismatches := compareTable(sources)
+		if len(mismatches) == 0 {
+			continue
+		}
+
+		mismatchedTables++
+		for i := range mismatches {
+			report.Warn(ctx, "model mismatch", "table", tableDisplayName(sources), "mismatch", mismatches[i])
+		}
+	}
+
+	if mismatchedTables > 0 {
+		return &errs.ValidationError{Check: "verifyModels", Problems: mismatchedTables, Err: fmt.Errorf("model verification found %d of %d table(s) with mismatches", mismatchedTables, len(tableKeys))}
+	}
+
+	logging.FromContext(ctx).InfoContext(ctx, "no model mismatches found", "tables", len(tableKeys))
+	return nil
+}
+
+// compareTable returns a description of every difference between the table shapes in sources
+func compareTable(sources map[string]*tableShape) (mismatches []string) {
+	srcNames := []string{srcModel, srcJsonSchema, srcDb}
+	for _, src := range srcNames {
+		if sources[src] == nil {
+			mismatches = append(mismatches, fmt.Sprintf("table missing from %s", src))
+		}
+	}
+
+	// column names
+	columnKeys := []string{}
+	for _, src := range srcNames {
+		if sources[src] == nil {
+			continue
+		}
+		for i := range sources[src].Columns {
+			key := strings.ToLower(sources[src].Columns[i].Name)
+			if !slices.Contains(columnKeys, key) {
+				columnKeys = append(columnKeys, key)
+			}
+		}
+	}
+
+	for _, colKey := range columnKeys {
+		cols := map[string]*columnShape{}
+		for _, src := range srcNames {
+			if sources[src] == nil {
+				continue
+			}
+			for i := range sources[src].Columns {
+				if strings.ToLower(sources[src].Columns[i].Name) == colKey {
+					cols[src] = &sources[src].Columns[i]
+				}
+			}
+		}
+
+		colName := colKey
+		for _, src := range srcNames {
+			if cols[src] != nil {
+				colName = cols[src].Name
+				break
+			}
+		}
+
+		for _, src := range srcNames {
+			if sources[src] != nil && cols[src] == nil {
+				mismatches = append(mismatches, fmt.Sprintf("column %s missing from %s", colName, src))
+			}
+		}
+
+		mismatches = appendDiff(mismatches, "column "+colName+" type", cols, func(c *columnShape) string {
+			if c.Length != 0 {
+				return fmt.Sprintf("%s(%d)", c.Type, c.Length)
+			}
+			return c.Type
+		})
+		mismatches = appendDiff(mismatches, "column "+colName+" allowNull", cols, func(c *columnShape) string {
+			return strconv.FormatBool(c.AllowNull)
+		})
+		mismatches = appendDiff(mismatches, "column "+colName+" default", cols, func(c *columnShape) string {
+			return c.Default
+		})
+	}
+
+	// indexes
+	indexKeys := []string{}
+	for _, src := range srcNames {
+		if sources[src] == nil {
+			continue
+		}
+		for i := range sources[src].Indexes {
+			key := strings.ToLower(sources[src].Indexes[i].Name)
+			if !slices.Contains(indexKeys, key) {
+				indexKeys = append(indexKeys, key)
+			}
+		}
+	}
+
+	for _, ixKey := range indexKeys {
+		indexes := map[string]*indexShape{}
+		for _, src := range srcNames {
+			if sources[src] == nil {
+				continue
+			}
+			for i := range sources[src].Indexes {
+				if strings.ToLower(sources[src].Indexes[i].Name) == ixKey {
+					indexes[src] = &sources[src].Indexes[i]
+				}
+			}
+		}
+
+		ixName := ixKey
+		for _, src := range srcNames {
+			if indexes[src] != nil {
+				ixName = indexes[src].Name
+				break
+			}
+		}
+
+		for _, src := range srcNames {
+			if sources[src] != nil && indexes[src] == nil {
+				mismatches = append(mismatches, fmt.Sprintf("index %s missing from %s", ixName, src))
+			}
+		}
+
+		mismatches = appendDiff(mismatches, "index "+ixName+" type", indexes, func(ix *indexShape) string {
+			return ix.Type
+		})
+		mismatches = appendDiff(mismatches, "index "+ixName+" isUnique", indexes, func(ix *indexShape) string {
+			return strconv.FormatBool(ix.IsUnique)
+		})
+		mismatches = appendDiff(mismatches, "index "+ixName+" isPrimaryKey", indexes, func(ix *indexShape) string {
+			return strconv.FormatBool(ix.IsPrimaryKey)
+		})
+		mismatches = appendDiff(mismatches, "index "+ixName+" columns", indexes, func(ix *indexShape) string {
+			return strings.ToLower(strings.Join(ix.Columns, ", "))
+		})
+	}
+
+	return mismatches
+}
+
+// appendDiff compares the value of a property across sources and appends a mismatch if they don't all agree
+func appendDiff[T any](mismatches []string, label string, items map[string]*T, valFn func(*T) string) []string {
+	srcNames := []string{srcModel, srcJsonSchema, srcDb}
+	vals := []string{}
+	isDiff := false
+	first := ""
+	for _, src := range srcNames {
+		if items[src] == nil {
+			continue
+		}
+		val := valFn(items[src])
+		if len(vals) == 0 {
+			first = val
+		} else if val != first {
+			isDiff = true
+		}
+		vals = append(vals, fmt.Sprintf("%s=%q", src, val))
+	}
+
+	if isDiff {
+		mismatches = append(mismatches, fmt.Sprintf("%s: %s", label, strings.Join(vals, " ")))
+	}
+	return mismatches
+}
+
+// tableDisplayName returns the table name as written by the first source that has it
+func tableDisplayName(sources map[string]*tableShape) string {
+	for _, src := range []string{srcModel, srcJsonSchema, srcDb} {
+		if sources[src] != nil {
+			return sources[src].Name
+		}
+	}
+	return ""
+}
+
+// getModelShapes parses kogen.ModelList's create table scripts into tableShapes keyed by lower-case table name
+func getModelShapes() (shapes map[string]tableShape, err error) {
+	shapes = map[string]tableShape{}
+	for i := range kogen.ModelList {
+		shape, err := parseCreateTable(kogen.ModelList[i].GetCreateTableString())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse create table script for %s: %v", kogen.ModelList[i].TableName(), err)
+		}
+		shapes[strings.ToLower(shape.Name)] = shape
+	}
+	return shapes, nil
+}
+
+// parseCreateTable builds a tableShape from the sql returned by kogen.Model.GetCreateTableString
+func parseCreateTable(sql string) (shape tableShape, err error) {
+	lines := strings.Split(strings.ReplaceAll(sql, "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		if match := createTableReg.FindStringSubmatch(line); match != nil {
+			shape.Name = match[1]
+		} else if match := columnLineReg.FindStringSubmatch(line); match != nil {
+			col := columnShape{
+				Name:      match[1],
+				Type:      strings.ToLower(match[2]),
+				AllowNull: match[4] == "",
+			}
+			if match[3] != "" && match[3] != "max" {
+				col.Length, _ = strconv.Atoi(match[3])
+			}
+			shape.Columns = append(shape.Columns, col)
+		} else if match := primaryKeyReg.FindStringSubmatch(line); match != nil {
+			shape.Indexes = append(shape.Indexes, indexShape{
+				Name:         match[1],
+				Type:         match[2],
+				IsUnique:     true,
+				IsPrimaryKey: true,
+				Columns:      parseBracketedNames(match[3]),
+			})
+		} else if match := defaultReg.FindStringSubmatch(line); match != nil {
+			for i := range shape.Columns {
+				if strings.EqualFold(shape.Columns[i].Name, match[2]) {
+					shape.Columns[i].Default = normalizeDefault(match[1])
+				}
+			}
+		} else if match := createIndexReg.FindStringSubmatch(line); match != nil {
+			ixType := strings.TrimSpace(match[2])
+			if ixType == "" {
+				ixType = "NONCLUSTERED"
+			}
+			shape.Indexes = append(shape.Indexes, indexShape{
+				Name:     match[3],
+				Type:     ixType,
+				IsUnique: match[1] != "",
+				Columns:  parseBracketedNames(match[4]),
+			})
+		}
+	}
+
+	if shape.Name == "" {
+		return shape, fmt.Errorf("no CREATE TABLE statement found")
+	}
+	if len(shape.Columns) == 0 {
+		return shape, fmt.Errorf("no columns found for table %s", shape.Name)
+	}
+	return shape, nil
+}
+
+// getJsonSchemaShapes converts OpenKO-db/jsonSchema table definitions into tableShapes keyed by lower-case table name
+func getJsonSchemaShapes(schemaDir string) (shapes map[string]tableShape, err error) {
+	defs, parseErrs, err := artifacts.LoadTableDefs(schemaDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(parseErrs) > 0 {
+		return nil, parseErrs[0]
+	}
+
+	shapes = map[string]tableShape{}
+	for i := range defs {
+		def := defs[i].Def
+		shape := tableShape{Name: def.Name}
+		for j := range def.Columns {
+			shape.Columns = append(shape.Columns, columnShape{
+				Name:      def.Columns[j].Name,
+				Type:      strings.ToLower(string(def.Columns[j].Type)),
+				Length:    normalizeLength(def.Columns[j].Length),
+				AllowNull: def.Columns[j].AllowNull,
+				Default:   normalizeDefault(def.Columns[j].DefaultValue),
+			})
+		}
+		for j := range def.Indexes {
+			shape.Indexes = append(shape.Indexes, indexShape{
+				Name:         def.Indexes[j].Name,
+				Type:         def.Indexes[j].Type,
+				IsUnique:     def.Indexes[j].IsUnique,
+				IsPrimaryKey: def.Indexes[j].IsPrimaryKey,
+				Columns:      def.Indexes[j].Columns,
+			})
+		}
+		shapes[strings.ToLower(def.Name)] = shape
+	}
+	return shapes, nil
+}
+
+// getDbShapes reads the table definitions from the live catalog into tableShapes keyed by lower-case table name
+func getDbShapes(gormConn *gorm.DB) (shapes map[string]tableShape, err error) {
+	tableNames := []string{}
+	err = gormConn.Raw(getTableNamesSql).Scan(&tableNames).Error
+	if err != nil {
+		return nil, err
+	}
+
+	shapes = map[string]tableShape{}
+	for i := range tableNames {
+		shape := tableShape{Name: tableNames[i]}
+
+		var columns []dbColumn
+		err = gormConn.Raw(fmt.Sprintf(getColumnsSqlFmt, tableNames[i])).Scan(&columns).Error
+		if err != nil {
+			return nil, err
+		}
+		for j := range columns {
+			col := columnShape{
+				Name:      columns[j].Name,
+				Type:      strings.ToLower(columns[j].Type),
+				AllowNull: columns[j].AllowNull == "YES",
+			}
+			if columns[j].Length != nil {
+				col.Length = normalizeLength(*columns[j].Length)
+			}
+			if columns[j].DefaultVal != nil {
+				col.Default = normalizeDefault(*columns[j].DefaultVal)
+			}
+			shape.Columns = append(shape.Columns, col)
+		}
+
+		var indexes []dbIndex
+		err = gormConn.Raw(fmt.Sprintf(getIndexesSqlFmt, tableNames[i])).Scan(&indexes).Error
+		if err != nil {
+			return nil, err
+		}
+		for j := range indexes {
+			ix := indexShape{
+				Name:         indexes[j].Name,
+				Type:         indexes[j].Type,
+				IsUnique:     indexes[j].IsUnique,
+				IsPrimaryKey: indexes[j].IsPrimaryKey,
+			}
+			err = gormConn.Raw(fmt.Sprintf(getIndexColumnsSqlFmt, tableNames[i], indexes[j].IndexId)).Scan(&ix.Columns).Error
+			if err != nil {
+				return nil, err
+			}
+			shape.Indexes = append(shape.Indexes, ix)
+		}
+
+		shapes[strings.ToLower(tableNames[i])] = shape
+	}
+	return shapes, nil
+}
+
+// parseBracketedNames returns the names from a list like "[col1], [col2]"
+func parseBracketedNames(list string) (names []string) {
+	for _, match := range bracketedNameReg.FindAllStringSubmatch(list, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// normalizeLength returns 0 for the lengths that aren't specified in the models: -1 for (max) types and int32 max for
+// text/image.  The database reports them, and the jsonSchema exporter keeps -1.
+func normalizeLength(length int) int {
+	if length <= 0 || length > maxSpecifiedLength {
+		return 0
+	}
+	return length
+}
+
+// normalizeDefault strips the parenthesis wrapping that sql server adds to default definitions and lower-cases the
+// result so that ((0)), (0), and 0 compare equal
+func normalizeDefault(def string) string {
+	def = strings.TrimSpace(def)
+	for len(def) >= 2 && def[0] == '(' && def[len(def)-1] == ')' && isWrapped(def) {
+		def = strings.TrimSpace(def[1 : len(def)-1])
+	}
+	return strings.ToLower(def)
+}
+
+// isWrapped returns true if the opening parenthesis at def[0] is closed by the final character of def
+func isWrapped(def string) bool {
+	depth := 0
+	for i := range def {
+		switch def[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(def)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
@@ -0,0 +1,26 @@
+package verify
+
+import (
+	"testing"
+)
+
+func TestNormalizeLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		want   int
+	}{
+		{name: "unspecified", length: 0, want: 0},
+		{name: "sized", length: 50, want: 50},
+		{name: "largest size", length: maxSpecifiedLength, want: maxSpecifiedLength},
+		{name: "max", length: -1, want: 0},
+		{name: "text", length: 2147483647, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := normalizeLength(test.length); got != test.want {
+				t.Errorf("normalizeLength(%d) = %d, want %d", test.length, got, test.want)
+			}
+		})
+	}
+}
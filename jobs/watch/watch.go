@@ -0,0 +1,165 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"kodb-util/artifacts"
+	"kodb-util/errs"
+	"kodb-util/jobs/importDb"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// pollInterval is how often ManualSetup is checked for changes.  A change is applied once the file has stayed the
+	// same for a whole interval, so that editors that save in several writes don't apply half a script.
+	pollInterval = time.Second
+)
+
+// watchedKinds are the kinds of ManualSetup script that are re-run when they change
+var watchedKinds = []importDb.ObjectScriptKind{importDb.ViewScripts, importDb.StoredProcScripts}
+
+// fileStat is compared between polls to find files that may have changed
+type fileStat struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// watchedFile is the state of a script as of the last time it was applied (or first seen)
+type watchedFile struct {
+	kind importDb.ObjectScriptKind
+	stat fileStat
+	hash [sha256.Size]byte
+}
+
+// Watch polls OpenKO-db/ManualSetup and re-runs view and stored procedure scripts against the database when their
+// contents change.  Each changed script is applied in its own transaction: the existing object is dropped and the
+// script re-creates it, so a script that fails leaves the previous version in place.  Failures are logged and the
+// watch carries on; it only returns when ctx is cancelled, or if the database can't be reached at startup.
+func Watch(ctx context.Context, driver *mssql.MssqlDbDriver) (err error) {
+	manualSetupPath := filepath.Join(driver.SchemaDir(), artifacts.ManualSetupDir)
+	if _, err = os.Stat(manualSetupPath); err != nil {
+		return &errs.FileSystemError{Op: "read", Path: manualSetupPath, Err: err}
+	}
+	// fail fast if the database can't be reached; later connection errors are logged like any other failure
+	_, err = driver.GetConnection()
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*watchedFile{}
+	err = scan(manualSetupPath, func(path string, kind importDb.ObjectScriptKind, stat fileStat) {
+		file := &watchedFile{kind: kind, stat: stat}
+		if sqlBytes, readErr := os.ReadFile(path); readErr == nil {
+			file.hash = sha256.Sum256(sqlBytes)
+		}
+		files[path] = file
+	})
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "watching for view and stored procedure changes", "dir", manualSetupPath, "db", driver.GenDbConfig.Name, "scripts", len(files))
+
+	// stat of files that changed in the last poll; they're applied if they're unchanged in the next one
+	pending := map[string]fileStat{}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logging.FromContext(ctx).InfoContext(ctx, "stopped watching", "db", driver.GenDbConfig.Name)
+			return nil
+		case <-ticker.C:
+		}
+
+		seen := map[string]bool{}
+		err = scan(manualSetupPath, func(path string, kind importDb.ObjectScriptKind, stat fileStat) {
+			seen[path] = true
+			file, ok := files[path]
+			if ok && file.stat == stat {
+				delete(pending, path)
+				return
+			}
+			if pendingStat, ok := pending[path]; !ok || pendingStat != stat {
+				pending[path] = stat
+				return
+			}
+			delete(pending, path)
+
+			sqlBytes, readErr := os.ReadFile(path)
+			if readErr != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "failed to read changed script", "file", path, "error", readErr)
+				return
+			}
+			hash := sha256.Sum256(sqlBytes)
+			if ok && file.hash == hash {
+				// touched, but the contents are the same
+				file.stat = stat
+				return
+			}
+			// recorded whether or not it applies, so that a failing script is retried on its next change only
+			files[path] = &watchedFile{kind: kind, stat: stat, hash: hash}
+			apply(ctx, driver, kind, path, string(sqlBytes))
+		})
+		if err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "failed to scan for changes", "dir", manualSetupPath, "error", err)
+			continue
+		}
+
+		for path := range files {
+			if !seen[path] {
+				logging.FromContext(ctx).WarnContext(ctx, "script removed; its object was left in the database", "file", path)
+				delete(files, path)
+				delete(pending, path)
+			}
+		}
+	}
+}
+
+// scan calls fn for each watched script in dir
+func scan(dir string, fn func(path string, kind importDb.ObjectScriptKind, stat fileStat)) error {
+	for _, kind := range watchedKinds {
+		paths, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf(kind.FileNameFmt, "*")))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				// removed between the glob and the stat; picked up as removed on the next scan
+				continue
+			}
+			fn(path, kind, fileStat{ModTime: info.ModTime(), Size: info.Size()})
+		}
+	}
+	return nil
+}
+
+// apply drops and re-creates the object in a script within a transaction of its own.  Errors are logged rather than
+// returned so that the watch carries on.
+func apply(ctx context.Context, driver *mssql.MssqlDbDriver, kind importDb.ObjectScriptKind, path string, sql string) {
+	objectName := kind.ObjectName(path)
+	logging.FromContext(ctx).InfoContext(ctx, "applying changed script", "file", path, "object", objectName)
+	start := time.Now()
+
+	err := importDb.ReplaceScript(ctx, driver, kind, importDb.Script{Name: path, Sql: sql})
+	if err != nil {
+		if driver.HasTx() {
+			if rErr := driver.RollbackTx(); rErr != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "failed to rollback transaction", "db", driver.GenDbConfig.Name, "error", rErr)
+			}
+		}
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to apply script; the previous version was kept", "file", path, "object", objectName, "error", err)
+		return
+	}
+	err = driver.CommitTx()
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to commit script", "file", path, "object", objectName, "error", err)
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "applied script", "file", path, "object", objectName, "elapsed", time.Since(start))
+}
@@ -2,20 +2,42 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"errors"
 	"fmt"
-	"github.com/Open-KO/OpenKO-gorm/kogen"
 	"github.com/Open-KO/kodb-godef/enums/dbType"
-	"gorm.io/gorm"
 	"kodb-util/arg"
 	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/jobs/backup"
 	"kodb-util/jobs/clean"
 	"kodb-util/jobs/export"
 	"kodb-util/jobs/importDb"
+	"kodb-util/jobs/lint"
+	"kodb-util/jobs/roundTrip"
+	"kodb-util/jobs/snapshot"
+	"kodb-util/jobs/verify"
+	"kodb-util/jobs/watch"
+	"kodb-util/kodb"
+	"kodb-util/logging"
 	"kodb-util/mssql"
-	"log"
+	"kodb-util/preflight"
+	"kodb-util/report"
+	"kodb-util/safety"
+	"kodb-util/server"
+	"log/slog"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 )
 
+// configTemplate is the starting point of the config file written by config init
+//
+//go:embed kodb-util-config.yaml.template
+var configTemplate []byte
+
 const (
 	appTitle    = "OpenKO Database Utilities"
 	outputWidth = 120
@@ -34,7 +56,8 @@ func main() {
 	defer func() {
 		// catch-all panic error
 		if r := recover(); r != nil {
-			log.Printf("Recovered from panic: %v", r)
+			slog.Error("recovered from panic", "panic", r)
+			os.Exit(errs.ExitUnknown)
 		}
 	}()
 
@@ -44,35 +67,140 @@ func main() {
 	fmt.Println(fmt.Sprintf("%[2]s%[1]s%[2]s", appTitle, strings.Repeat(" ", titlePad)))
 	printHeaderRow()
 
-	args := arg.GetArgs()
-	if err := args.Validate(); err != nil {
-		fmt.Printf("arguments error: %v, closing.", err)
+	args, err := arg.GetArgs()
+	if errors.Is(err, arg.ErrHelp) {
+		return
+	}
+	if err == nil {
+		err = args.Validate()
+	}
+	if err != nil {
+		fmt.Printf("arguments error: %v, closing.\n", err)
+		os.Exit(errs.ExitArgs)
+	}
+
+	closeLog, err := logging.Setup(logging.Options{
+		Level:  args.LogLevel,
+		Format: args.LogFormat,
+		File:   args.LogFile,
+	})
+	if err != nil {
+		fmt.Printf("arguments error: %v, closing.\n", err)
+		os.Exit(errs.ExitArgs)
+	}
+	defer closeLog()
+	for i := range args.Deprecated {
+		slog.Warn(args.Deprecated[i])
+	}
+
+	// init writes the config file, so it runs before it's loaded
+	if args.Init {
+		initCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = preflight.Init(initCtx, args.ConfigPath, configTemplate)
+		stop()
+		if err != nil {
+			slog.Error("config init failed", "error", err)
+			os.Exit(errs.ExitCode(err))
+		}
 		return
 	}
 
-	// loading config for the first time can throw a panic, so let's do it here
-	// uses a singleton pattern, so once loaded from disk it's in memory
-	fmt.Print("Loading config...")
-	conf := config.GetConfig()
+	var runReport *report.Report
+	if args.Report != "" {
+		runReport = report.New()
+	}
+	// writeReport records the error the run ended with and writes the -report file, if requested
+	writeReport := func(err error) {
+		if runReport == nil {
+			return
+		}
+		runReport.Finish(err)
+		if wErr := runReport.WriteFile(args.Report); wErr != nil {
+			slog.Error("failed to write report", "file", args.Report, "error", wErr)
+		}
+	}
+
+	slog.Info("loading config", "path", args.ConfigPath)
+	conf, err := config.Load(args.ConfigPath, args.Profile)
+	if err != nil {
+		err = &errs.ConfigError{File: args.ConfigPath, Err: err}
+		slog.Error("config error", "error", err)
+		writeReport(err)
+		os.Exit(errs.ExitCode(err))
+	}
 	// apply any command-line overrides
 	if args.DbUser != "" {
 		conf.DatabaseConfig.User = args.DbUser
 	}
-	if args.DbPass != "" {
+	if args.DbPass == "-" {
+		pass, err := config.ReadSecret(fmt.Sprintf("Password for %s: ", conf.DatabaseConfig.User))
+		if err != nil {
+			err = &errs.ConfigError{Err: fmt.Errorf("failed to read password: %v", err)}
+			slog.Error("failed to read password", "error", err)
+			writeReport(err)
+			os.Exit(errs.ExitCode(err))
+		}
+		conf.DatabaseConfig.Password = pass
+	} else if args.DbPass != "" {
 		conf.DatabaseConfig.Password = args.DbPass
 	}
 	if args.SchemaDir != "" {
 		conf.GenConfig.SchemaDir = args.SchemaDir
 	}
-	if args.ImportBatchSize > 1 && args.ImportBatchSize < 1000 {
-		importDb.ImportBatSize = args.ImportBatchSize
+	if args.NoBackup {
+		conf.GenConfig.Backup.IsDisabled = true
 	}
-	fmt.Println("done")
 
-	// Create a stub context for use with our db-ops.  We're not doing anything fancy with it now, but it will give us a
-	// few options if we ever desire them (deadlines, cancel funcs, key:val mapping)
+	// validate after the overrides so that problems they fix (or cause) are accounted for
+	err = conf.Validate()
+	if err != nil {
+		var validationErrs config.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			for i := range validationErrs {
+				slog.Error("config error", "file", conf.FilePath(), "line", validationErrs[i].Line, "column", validationErrs[i].Column, "path", validationErrs[i].Path, "error", validationErrs[i].Message)
+			}
+		} else {
+			slog.Error("config error", "file", conf.FilePath(), "error", err)
+		}
+		err = &errs.ConfigError{File: conf.FilePath(), Err: err}
+		writeReport(err)
+		os.Exit(errs.ExitCode(err))
+	}
+	if args.CheckConfig {
+		slog.Info("config is valid", "file", conf.FilePath())
+		if !args.Preflight {
+			writeReport(nil)
+			return
+		}
+	}
+
+	// appCtx is cancelled by Ctrl-C/SIGTERM or -timeout.  Every query runs with it, so cancelling stops the current
+	// batch and the driver's open transaction is rolled back.
 	// https://pkg.go.dev/context
-	appCtx := context.Background()
+	appCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// when serving, -timeout applies to each job instead of the server
+	if args.Timeout > 0 && args.Serve == "" {
+		var cancel context.CancelFunc
+		appCtx, cancel = context.WithTimeout(appCtx, args.Timeout)
+		defer cancel()
+	}
+	go func() {
+		// restore the default signal behavior so that a second Ctrl-C kills the process if rollback hangs
+		<-appCtx.Done()
+		stop()
+	}()
+	appCtx = runReport.WithReport(appCtx)
+
+	// without a job, e.g. with config check or serve, the login is checked as if it will import
+	if args.Preflight {
+		_, err = preflight.Check(appCtx, conf, !args.HasDbJob() || args.ManagesDatabases())
+		if err != nil {
+			slog.Error("preflight failed", "error", err)
+			writeReport(err)
+			os.Exit(errs.ExitCode(err))
+		}
+	}
 
 	dbs := []dbInfo{}
 	for i := range conf.GenConfig.GameDbs {
@@ -85,51 +213,175 @@ func main() {
 	// TODO: Add multi-db support by updating the config structure with LoginDbs and LogDbs
 	// and adding them to the dbs list
 
-	for i := range dbs {
-		err := processDb(appCtx, dbs[i], args)
+	if args.Serve != "" {
+		serveDbs := make([]server.Database, len(dbs))
+		for i := range dbs {
+			serveDbs[i] = server.Database(dbs[i])
+		}
+		srv := server.New(conf, args, serveDbs, func(ctx context.Context, db server.Database, jobArgs arg.Args) error {
+			return processDb(ctx, conf, dbInfo(db), jobArgs)
+		})
+		err = srv.ListenAndServe(appCtx, args.Serve)
 		if err != nil {
-			panic(err)
+			slog.Error("server error", "error", err)
+			writeReport(err)
+			os.Exit(errs.ExitCode(err))
 		}
+		writeReport(nil)
+		return
 	}
-}
 
-// processDb attempts requested jobs for the given database
-func processDb(appCtx context.Context, db dbInfo, args arg.Args) (err error) {
-	// a clean driver should be used/configured per database as the application logic
-	// makes heavy use of the driver.GenDbConfig
-	driver := mssql.NewMssqlDbDriver(db.Config, db.Type)
+	// every database is watched at once, until cancelled
+	if args.Watch {
+		wg := sync.WaitGroup{}
+		mu := sync.Mutex{}
+		var watchErr error
+		for i := range dbs {
+			wg.Add(1)
+			go func(db dbInfo) {
+				defer wg.Done()
+				err := processDb(appCtx, conf, db, args)
+				if err != nil {
+					slog.Error("watch failed", "db", db.Config.Name, "error", err)
+					mu.Lock()
+					watchErr = errors.Join(watchErr, err)
+					mu.Unlock()
+				}
+			}(dbs[i])
+		}
+		wg.Wait()
+		writeReport(watchErr)
+		if watchErr != nil {
+			os.Exit(errs.ExitCode(watchErr))
+		}
+		return
+	}
 
-	var tx *gorm.DB
-	defer func() {
-		// catch-all panic error
-		if r := recover(); r != nil {
-			err = fmt.Errorf("panic: %v", r)
+	// jobs that drop or replace data are checked against the safety config and confirmed before any database is touched
+	if jobs := args.DestructiveJobs(); len(jobs) > 0 {
+		err = confirmDestructive(conf, args, jobs, dbs)
+		if err != nil {
+			slog.Error("safety check failed", "error", err)
+			writeReport(err)
+			os.Exit(errs.ExitCode(err))
 		}
-		if tx != nil {
-			if err != nil {
-				rErr := driver.RollbackTx()
-				if rErr != nil {
-					fmt.Printf("failed to rollback transaction: %v", rErr)
+	}
+
+	if args.HasDbJob() {
+		for i := range dbs {
+			err := processDb(appCtx, conf, dbs[i], args)
+			if err != nil && appCtx.Err() != nil {
+				reason := "interrupted"
+				if errors.Is(appCtx.Err(), context.DeadlineExceeded) {
+					reason = fmt.Sprintf("timed out after %s", args.Timeout)
 				}
-			} else {
-				err = driver.CommitTx()
+				slog.Error(reason+"; open transaction was rolled back, closing", "db", dbs[i].Config.Name)
+				writeReport(err)
+				os.Exit(errs.ExitCancelled)
+			}
+			if err != nil {
+				exitCode := errs.ExitCode(err)
+				slog.Error("job failed", "db", dbs[i].Config.Name, "error", err, "exitCode", exitCode)
+				writeReport(err)
+				os.Exit(exitCode)
 			}
 		}
-		driver.CloseConnection()
-	}()
+	}
+
+	// lint runs last so that it checks the output of any jsonschema/procs export in the same run
+	if args.LintSchema {
+		err := lint.JsonSchema(appCtx, conf.GenConfig.SchemaDir)
+		if err != nil {
+			slog.Error("lint error", "error", err)
+			writeReport(err)
+			os.Exit(errs.ExitCode(err))
+		}
+	}
+
+	writeReport(nil)
+}
+
+// confirmDestructive checks the configured server against the safety host lists, then asks the user to confirm jobs
+// against the databases that their isForbid* flags allow, unless -yes was given
+func confirmDestructive(conf *config.KodbConfig, args arg.Args, jobs []string, dbs []dbInfo) error {
+	err := safety.CheckHost(conf)
+	if err != nil {
+		return err
+	}
+	dbNames := []string{}
+	for i := range dbs {
+		if args.ForbidReason(dbs[i].Config) == "" {
+			dbNames = append(dbNames, dbs[i].Config.Name)
+		}
+	}
+	if len(dbNames) == 0 || args.Yes {
+		return nil
+	}
+	return safety.Confirm(conf, jobs, dbNames)
+}
+
+// jobOptions returns the driver options set by args
+func jobOptions(args arg.Args) mssql.JobOptions {
+	return mssql.JobOptions{
+		ImportBatchSize:  args.ImportBatchSize,
+		DropOrphanLogins: args.DropOrphanLogins,
+		Force:            args.Force,
+		TraceSql:         args.TraceSql,
+		SlowSqlThreshold: args.SlowSql,
+	}
+}
 
-	// Set the model package DB Name
-	// kogen.SetDbNameByType(kogen.DbType(driver.DbType), driver.GenDbConfig.Name)
-	kogen.SetLoginDbName(driver.GenDbConfig.Name)
-	kogen.SetGameDbName(driver.GenDbConfig.Name)
-	kogen.SetLogDbName(driver.GenDbConfig.Name)
+// processDb attempts requested jobs for the given database of conf
+func processDb(appCtx context.Context, conf *config.KodbConfig, db dbInfo, args arg.Args) error {
+	// watch only runs scripts, and would hold the models until cancelled
+	return kodb.RunDb(appCtx, conf, db.Config, db.Type, jobOptions(args), !args.Watch, func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+		return runJobs(ctx, args, driver)
+	})
+}
+
+// runJobs runs the jobs requested by args against the driver's database, skipping those its isForbid* flags don't
+// allow
+func runJobs(appCtx context.Context, args arg.Args, driver *mssql.MssqlDbDriver) (err error) {
+	// round trip works against its own scratch database; the configured database doesn't need to exist
+	if args.RoundTrip {
+		return runPhase(appCtx, args, "round trip", roundTrip.RoundTrip, driver)
+	}
+
+	// restore replaces the database, so it's subject to both the clean and import forbid flags
+	if args.Restore != "" {
+		if driver.GenDbConfig.IsForbidClean || driver.GenDbConfig.IsForbidImport {
+			slog.WarnContext(appCtx, "clean or import operation for database is forbidden, skipping -restore action", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "restore", "isForbidImport or isForbidClean")
+			return nil
+		}
+		return runPhase(appCtx, args, "restore", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+			return backup.Restore(ctx, driver, args.Restore)
+		}, driver)
+	}
+
+	if args.HasSnapshotJob() {
+		return processSnapshots(appCtx, args, driver)
+	}
+
+	if args.Watch {
+		if driver.GenDbConfig.IsForbidImport {
+			slog.WarnContext(appCtx, "import operation for database is forbidden, skipping -watch action", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "watch", "isForbidImport")
+			return nil
+		}
+		// the watch runs until cancelled, so -phaseTimeout doesn't apply
+		watchArgs := args
+		watchArgs.PhaseTimeout = 0
+		return runPhase(appCtx, watchArgs, "watch", watch.Watch, driver)
+	}
 
 	// Run clean if either -clean or -import was called
 	if args.Clean || args.Import {
 		if driver.GenDbConfig.IsForbidClean {
-			fmt.Printf("WARN: clean operation for %s database is forbidden, skipping -clean action\n", driver.GenDbConfig.Name)
+			slog.WarnContext(appCtx, "clean operation for database is forbidden, skipping -clean action", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "clean", "isForbidClean")
 		} else {
-			err = clean.Clean(appCtx, driver)
+			err = runPhase(appCtx, args, "clean", clean.Clean, driver)
 			if err != nil {
 				return err
 			}
@@ -138,57 +390,103 @@ func processDb(appCtx context.Context, db dbInfo, args arg.Args) (err error) {
 
 	if args.Import {
 		if driver.GenDbConfig.IsForbidImport || driver.GenDbConfig.IsForbidClean {
-			fmt.Printf("WARN: clean or Import operation for %s database is forbidden, skipping -import action\n", driver.GenDbConfig.Name)
+			slog.WarnContext(appCtx, "clean or import operation for database is forbidden, skipping -import action", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "import", "isForbidImport or isForbidClean")
 		} else {
-			err = importDb.ImportDb(appCtx, driver)
+			err = runPhase(appCtx, args, "import", importDb.ImportDb, driver)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	if driver.GenDbConfig.IsForbidExport && (args.ExportStructure || args.ExportData || args.ExportJsonSchema || args.ExportAll) {
-		fmt.Printf("WARN: export operation for %s database is forbidden, skipping -export* actions\n", driver.GenDbConfig.Name)
+	// views, procs, and data are replaced in the existing database, within the driver's transaction
+	if args.HasPartialImportJob() {
+		if driver.GenDbConfig.IsForbidImport {
+			slog.WarnContext(appCtx, "import operation for database is forbidden, skipping -importViews/-importProcs/-importData actions", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "import", "isForbidImport")
+		} else {
+			if args.ImportData {
+				err = runPhase(appCtx, args, "import data", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+					return importDb.ImportData(ctx, driver, args.ImportTables)
+				}, driver)
+				if err != nil {
+					return err
+				}
+			}
+			if args.ImportViews {
+				err = runPhase(appCtx, args, "import views", importDb.ImportViews, driver)
+				if err != nil {
+					return err
+				}
+			}
+			if args.ImportProcs {
+				err = runPhase(appCtx, args, "import procs", importDb.ImportStoredProcs, driver)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// verification is read-only, so it isn't subject to the forbid flags
+	if args.VerifyModels {
+		err = runPhase(appCtx, args, "verify models", verify.Models, driver)
+		if err != nil {
+			return err
+		}
+	}
+
+	if driver.GenDbConfig.IsForbidExport && args.HasExportJob() {
+		slog.WarnContext(appCtx, "export operation for database is forbidden, skipping -export* actions", "db", driver.GenDbConfig.Name)
+		report.Skip(appCtx, "export", "isForbidExport")
 		return nil
 	}
 
 	// ImportDb will set driver.Tx as it has a mix of work to do on master/gen databases.  Get a ref to that pointer,
 	// or open it now if import wasn't called
-	tx, err = driver.GetTx()
+	_, err = driver.GetTx()
 	if err != nil {
 		return err
 	}
 
 	if args.ExportJsonSchema {
-		err = export.JsonSchema(driver)
+		err = runPhase(appCtx, args, "export jsonSchema", export.JsonSchema, driver)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.ExportSecurity {
+		err = runPhase(appCtx, args, "export security", export.Security, driver)
 		if err != nil {
 			return err
 		}
 	}
 
 	if args.ExportStructure || args.ExportAll {
-		err = export.Structure(driver)
+		err = runPhase(appCtx, args, "export structure", export.Structure, driver)
 		if err != nil {
 			return err
 		}
 	}
 
 	if args.ExportData || args.ExportAll {
-		err = export.TableData(driver)
+		err = runPhase(appCtx, args, "export data", export.TableData, driver)
 		if err != nil {
 			return err
 		}
 	}
 
 	if args.ExportViews || args.ExportAll {
-		err = export.Views(driver)
+		err = runPhase(appCtx, args, "export views", export.Views, driver)
 		if err != nil {
 			return err
 		}
 	}
 
 	if args.ExportProcs || args.ExportAll {
-		err = export.StoredProcedures(driver)
+		err = runPhase(appCtx, args, "export procs", export.StoredProcedures, driver)
 		if err != nil {
 			return err
 		}
@@ -196,3 +494,51 @@ func processDb(appCtx context.Context, db dbInfo, args arg.Args) (err error) {
 
 	return nil
 }
+
+// runPhase runs a single job against driver as a report phase, limited by -phaseTimeout when set
+func runPhase(appCtx context.Context, args arg.Args, name string, job func(context.Context, *mssql.MssqlDbDriver) error, driver *mssql.MssqlDbDriver) (err error) {
+	ctx, phase := report.StartPhase(appCtx, name)
+	defer func() {
+		phase.End(err)
+	}()
+	if args.PhaseTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.PhaseTimeout)
+		defer cancel()
+	}
+	return job(ctx, driver)
+}
+
+// processSnapshots runs the requested snapshot jobs.  Creating, reverting, and dropping snapshots are subject to
+// isForbidClean; listing them isn't.
+func processSnapshots(appCtx context.Context, args arg.Args, driver *mssql.MssqlDbDriver) (err error) {
+	if args.Snapshot != "" || args.Revert != "" || args.DropSnapshot != "" {
+		if driver.GenDbConfig.IsForbidClean {
+			slog.WarnContext(appCtx, "clean operation for database is forbidden, skipping snapshot actions", "db", driver.GenDbConfig.Name)
+			report.Skip(appCtx, "snapshot", "isForbidClean")
+		} else if args.Snapshot != "" {
+			err = runPhase(appCtx, args, "create snapshot", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+				return snapshot.Create(ctx, driver, args.Snapshot)
+			}, driver)
+		} else if args.Revert != "" {
+			err = runPhase(appCtx, args, "revert snapshot", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+				return snapshot.Revert(ctx, driver, args.Revert)
+			}, driver)
+		} else {
+			err = runPhase(appCtx, args, "drop snapshot", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+				return snapshot.Drop(ctx, driver, args.DropSnapshot)
+			}, driver)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.ListSnapshots {
+		err = runPhase(appCtx, args, "list snapshots", func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+			_, err := snapshot.List(ctx, driver)
+			return err
+		}, driver)
+	}
+	return err
+}
@@ -0,0 +1,294 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Open-KO/kodb-godef/enums/dbType"
+	"io"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/jobs/clean"
+	"kodb-util/jobs/export"
+	"kodb-util/jobs/importDb"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/preflight"
+	"kodb-util/progress"
+	"kodb-util/report"
+	"kodb-util/safety"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// the kodb package runs the kodb-util jobs in-process, e.g. from integration tests or a launcher:
+//
+//	conf, err := config.Load("kodb-util-config.yaml", "")
+//	runner, err := kodb.New(conf, kodb.Options{Logger: slog.New(slog.NewTextHandler(w, nil))})
+//	err = runner.Import(ctx)
+//
+// A Runner only uses the configuration and options it was created with, so several can be used at once with different
+// configurations.  Destructive jobs don't ask for confirmation; the configured safety host lists and guarded row
+// check still apply.
+
+// Export kinds, see Runner.Export
+const (
+	ExportData       = "data"       // table data as 6_InsertData_*.sql
+	ExportStructure  = "structure"  // database, schema, user, login, table, and role creation scripts from the config
+	ExportViews      = "views"      // views as 7_CreateView_*.sql
+	ExportProcs      = "procs"      // stored procedures as 8_CreateStoredProc_*.sql and jsonSchema/procedures
+	ExportJsonSchema = "jsonschema" // table properties merged into jsonSchema; not part of all
+	ExportSecurity   = "security"   // schemas, users, logins, roles, and grants read from the server; not part of all, and not with structure
+	ExportAll        = "all"        // data, structure, views, and procs
+)
+
+// exportJobs are the export kinds in the order they're run
+var exportJobs = []struct {
+	Kind  string
+	Phase string
+	Job   func(context.Context, *mssql.MssqlDbDriver) error
+}{
+	{Kind: ExportJsonSchema, Phase: "export jsonSchema", Job: export.JsonSchema},
+	{Kind: ExportSecurity, Phase: "export security", Job: export.Security},
+	{Kind: ExportStructure, Phase: "export structure", Job: export.Structure},
+	{Kind: ExportData, Phase: "export data", Job: export.TableData},
+	{Kind: ExportViews, Phase: "export views", Job: export.Views},
+	{Kind: ExportProcs, Phase: "export procs", Job: export.StoredProcedures},
+}
+
+// allExportKinds are the kinds ExportAll stands for
+var allExportKinds = []string{ExportData, ExportStructure, ExportViews, ExportProcs}
+
+// Options are the settings of a Runner that aren't part of the configuration file
+type Options struct {
+	// Logger receives the log records of the Runner's jobs.  nil uses the slog default logger
+	Logger *slog.Logger
+
+	// Progress is where long-running jobs draw their progress, when it's a terminal.  Otherwise, including when it's
+	// nil, progress is logged periodically
+	Progress io.Writer
+
+	// Report, if set, records the databases, phases, files, and warnings of every job the Runner runs
+	Report *report.Report
+
+	// Databases limits the jobs to the genConfig.gameDb entries with these names.  Empty runs them against every one
+	Databases []string
+
+	// Jobs are the job settings the CLI sets with -batchSize, -dropOrphanLogins, -force, and -traceSql
+	Jobs mssql.JobOptions
+}
+
+// Runner runs jobs against the databases of a configuration
+type Runner struct {
+	conf *config.KodbConfig
+	opts Options
+}
+
+// New returns a Runner for conf, which is validated first.  conf is used as-is: load it with config.Load to apply the
+// profiles, KODB_* environment variables, and secret references of a configuration file.
+func New(conf *config.KodbConfig, opts Options) (*Runner, error) {
+	err := conf.Validate()
+	if err != nil {
+		return nil, &errs.ConfigError{File: conf.FilePath(), Err: err}
+	}
+	for _, name := range opts.Databases {
+		if !slices.ContainsFunc(conf.GenConfig.GameDbs, func(db config.GenDbConfig) bool { return db.Name == name }) {
+			return nil, &errs.ConfigError{File: conf.FilePath(), Err: fmt.Errorf("database %s is not in genConfig.gameDb", name)}
+		}
+	}
+	return &Runner{conf: conf, opts: opts}, nil
+}
+
+// Config returns the configuration the Runner was created with
+func (this *Runner) Config() *config.KodbConfig {
+	return this.conf
+}
+
+// Preflight checks OpenKO-db, the server's version and edition, and that the configured login can create databases and
+// logins.  Missing permissions are problems with requireCreate, e.g. before Import or Clean, and warnings otherwise.
+// Warnings are recorded in Options.Report; problems are returned as a ValidationError, see preflight.Check.
+func (this *Runner) Preflight(ctx context.Context, requireCreate bool) (preflight.Result, error) {
+	if this.opts.Logger != nil {
+		ctx = logging.WithHandler(ctx, this.opts.Logger.Handler())
+	}
+	ctx = this.opts.Report.WithReport(ctx)
+	return preflight.Check(ctx, this.conf, requireCreate)
+}
+
+// Clean drops each database and its logins, backing up databases that have data first unless
+// genConfig.backup.isDisabled is set.  Databases with isForbidClean are skipped.
+func (this *Runner) Clean(ctx context.Context) error {
+	err := safety.CheckHost(this.conf)
+	if err != nil {
+		return err
+	}
+	return this.forEachDb(ctx, func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+		if driver.GenDbConfig.IsForbidClean {
+			return skip(ctx, "clean", "isForbidClean", driver)
+		}
+		return runPhase(ctx, "clean", clean.Clean, driver)
+	})
+}
+
+// Import cleans each database, then imports OpenKO-db into it.  Databases with isForbidImport or isForbidClean are
+// skipped.
+func (this *Runner) Import(ctx context.Context) error {
+	err := safety.CheckHost(this.conf)
+	if err != nil {
+		return err
+	}
+	return this.forEachDb(ctx, func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+		if driver.GenDbConfig.IsForbidImport || driver.GenDbConfig.IsForbidClean {
+			return skip(ctx, "import", "isForbidImport or isForbidClean", driver)
+		}
+		err := runPhase(ctx, "clean", clean.Clean, driver)
+		if err != nil {
+			return err
+		}
+		return runPhase(ctx, "import", importDb.ImportDb, driver)
+	})
+}
+
+// Export writes each database to OpenKO-db.  kinds are the Export* constants; at least one is required.  Databases
+// with isForbidExport are skipped.
+func (this *Runner) Export(ctx context.Context, kinds ...string) error {
+	if len(kinds) == 0 {
+		return errors.New("export requires at least one kind")
+	}
+	selected := []string{}
+	for _, kind := range kinds {
+		kind = strings.ToLower(kind)
+		switch {
+		case kind == ExportAll:
+			selected = append(selected, allExportKinds...)
+		case isExportKind(kind):
+			selected = append(selected, kind)
+		default:
+			return fmt.Errorf("unknown export kind %s", kind)
+		}
+	}
+	if slices.Contains(selected, ExportSecurity) && slices.Contains(selected, ExportStructure) {
+		// both write the 2_, 3_, and 4_ scripts; structure would replace the ones read from the server
+		return fmt.Errorf("export %s cannot be combined with %s or %s", ExportSecurity, ExportStructure, ExportAll)
+	}
+
+	return this.forEachDb(ctx, func(ctx context.Context, driver *mssql.MssqlDbDriver) error {
+		if driver.GenDbConfig.IsForbidExport {
+			return skip(ctx, "export", "isForbidExport", driver)
+		}
+		_, err := driver.GetTx()
+		if err != nil {
+			return err
+		}
+		for _, job := range exportJobs {
+			if !slices.Contains(selected, job.Kind) {
+				continue
+			}
+			err = runPhase(ctx, job.Phase, job.Job, driver)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SyncJsonSchema merges the table structure of each database into OpenKO-db/jsonSchema; the same as
+// Export(ctx, ExportJsonSchema)
+func (this *Runner) SyncJsonSchema(ctx context.Context) error {
+	return this.Export(ctx, ExportJsonSchema)
+}
+
+// isExportKind returns true if kind is one of the exportJobs
+func isExportKind(kind string) bool {
+	for _, job := range exportJobs {
+		if job.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachDb runs job against each selected database, stopping at the first error
+func (this *Runner) forEachDb(ctx context.Context, job func(context.Context, *mssql.MssqlDbDriver) error) error {
+	if this.opts.Logger != nil {
+		ctx = logging.WithHandler(ctx, this.opts.Logger.Handler())
+	}
+	progressOut := this.opts.Progress
+	if progressOut == nil {
+		progressOut = io.Discard
+	}
+	ctx = progress.WithOutput(ctx, progressOut)
+	ctx = this.opts.Report.WithReport(ctx)
+
+	for _, db := range this.conf.GenConfig.GameDbs {
+		if len(this.opts.Databases) > 0 && !slices.Contains(this.opts.Databases, db.Name) {
+			continue
+		}
+		err := this.processDb(ctx, db, job)
+		if err != nil {
+			return fmt.Errorf("database %s: %w", db.Name, err)
+		}
+	}
+	return nil
+}
+
+// processDb runs job against db with a new driver
+func (this *Runner) processDb(ctx context.Context, db config.GenDbConfig, job func(context.Context, *mssql.MssqlDbDriver) error) error {
+	return RunDb(ctx, this.conf, db, dbType.GAME, this.opts.Jobs, true, job)
+}
+
+// RunDb runs job against db with a new driver, recorded as a database in the context's report.  The driver's
+// transaction is committed if job succeeds, and rolled back otherwise; a panic is returned as an error.  With
+// usesModels, the OpenKO-gorm models are pointed at db for the whole job, and jobs against other databases wait for it;
+// jobs that only run scripts, e.g. watch, can leave them alone.  The CLI and the HTTP API run their jobs through it
+// too.
+func RunDb(ctx context.Context, conf *config.KodbConfig, db config.GenDbConfig, dbType dbType.DbType, opts mssql.JobOptions, usesModels bool, job func(context.Context, *mssql.MssqlDbDriver) error) (err error) {
+	if usesModels {
+		release := mssql.UseModels(db.Name)
+		defer release()
+	}
+
+	var dbReport *report.Database
+	ctx, dbReport = report.StartDatabase(ctx, db.Name, string(dbType))
+	// a new driver is used per database, as the jobs make heavy use of driver.GenDbConfig
+	driver := mssql.NewMssqlDbDriver(ctx, conf, db, dbType, opts)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		// the transaction may have been opened by a job (e.g. import) that failed before returning it
+		if driver.HasTx() {
+			if err != nil {
+				rErr := driver.RollbackTx()
+				if rErr != nil {
+					logging.FromContext(ctx).ErrorContext(ctx, "failed to rollback transaction", "db", db.Name, "error", rErr)
+				}
+			} else {
+				err = driver.CommitTx()
+			}
+		}
+		driver.CloseConnection()
+		dbReport.End(err)
+	}()
+
+	return job(ctx, driver)
+}
+
+// runPhase runs job against driver as a report phase
+func runPhase(ctx context.Context, name string, job func(context.Context, *mssql.MssqlDbDriver) error, driver *mssql.MssqlDbDriver) (err error) {
+	ctx, phase := report.StartPhase(ctx, name)
+	defer func() {
+		phase.End(err)
+	}()
+	return job(ctx, driver)
+}
+
+// skip logs and reports that the job named name isn't run against the driver's database because of its forbid flags
+func skip(ctx context.Context, name string, reason string, driver *mssql.MssqlDbDriver) error {
+	logging.FromContext(ctx).WarnContext(ctx, fmt.Sprintf("%s operation for database is forbidden, skipping", name), "db", driver.GenDbConfig.Name, "reason", reason)
+	report.Skip(ctx, name, reason)
+	return nil
+}
@@ -0,0 +1,42 @@
+package kodb
+
+import (
+	"context"
+	"kodb-util/config"
+	"strings"
+	"testing"
+)
+
+func TestExportKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		kinds   []string
+		wantErr string // substring of the expected error; empty for none
+	}{
+		{name: "single", kinds: []string{ExportViews}},
+		{name: "several", kinds: []string{ExportViews, ExportProcs, ExportJsonSchema}},
+		{name: "all", kinds: []string{ExportAll}},
+		{name: "case insensitive", kinds: []string{"JsonSchema"}},
+		{name: "security alone", kinds: []string{ExportSecurity}},
+		{name: "none", wantErr: "at least one kind"},
+		{name: "unknown", kinds: []string{ExportViews, "tables"}, wantErr: "unknown export kind tables"},
+		{name: "security with structure", kinds: []string{ExportSecurity, ExportStructure}, wantErr: "cannot be combined"},
+		{name: "security with all", kinds: []string{ExportAll, ExportSecurity}, wantErr: "cannot be combined"},
+	}
+	// without databases the kinds are validated, but nothing is exported
+	runner := &Runner{conf: &config.KodbConfig{}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := runner.Export(context.Background(), test.kinds...)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Export(%v) error = %v", test.kinds, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Export(%v) error = %v, want %q", test.kinds, err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Listener receives a copy of every record logged with a context it was attached to by WithListener
+type Listener func(ctx context.Context, record slog.Record)
+
+type listenerKey struct{}
+
+type loggerKey struct{}
+
+// WithListener returns a context whose log records are also passed to listener.  Records are only seen by the listener
+// if they're logged with the context, e.g. FromContext(ctx).InfoContext(ctx, ...).
+func WithListener(ctx context.Context, listener Listener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, listener)
+}
+
+// WithHandler returns a context whose log records are handled by handler instead of the default logger's handler, so
+// that jobs run for different callers in one process log to their own destinations.  The default logger is left
+// alone; only records logged through FromContext(ctx) are routed to handler.
+func WithHandler(ctx context.Context, handler slog.Handler) context.Context {
+	return context.WithValue(ctx, loggerKey{}, slog.New(contextHandler{Handler: handler}))
+}
+
+// FromContext returns the logger set by WithHandler, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// contextHandler passes records to the Listener in the logging context, if any, before handling them as usual
+type contextHandler struct {
+	slog.Handler
+	attrs []slog.Attr
+}
+
+func (this contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if listener, ok := ctx.Value(listenerKey{}).(Listener); ok {
+		listenerRecord := record.Clone()
+		listenerRecord.AddAttrs(this.attrs...)
+		listener(ctx, listenerRecord)
+	}
+	return this.Handler.Handle(ctx, record)
+}
+
+func (this contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{
+		Handler: this.Handler.WithAttrs(attrs),
+		attrs:   append(this.attrs[:len(this.attrs):len(this.attrs)], attrs...),
+	}
+}
+
+func (this contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: this.Handler.WithGroup(name), attrs: this.attrs}
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"regexp"
+	"time"
+)
+
+// passwordRegex matches the PASSWORD = '...' and OLD_PASSWORD = '...' of CREATE/ALTER LOGIN, as literals or hashes
+var passwordRegex = regexp.MustCompile(`(?i)(PASSWORD\s*=\s*)(N?'(?:[^']|'')*'|0x[0-9a-f]*)`)
+
+// gormLogger implements gorm's logger.Interface on top of the context's logger, see FromContext
+type gormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+// NewGormLogger returns the logger used for gorm connections.  Unless traceSql is set gorm is silent; errors are
+// returned to, and reported by, the calling job.  With traceSql every statement is logged with its duration and row
+// count, statements slower than slowThreshold (0 uses DefaultSlowSqlThreshold) are logged as warnings, and failed
+// statements as errors.
+func NewGormLogger(traceSql bool, slowThreshold time.Duration) logger.Interface {
+	level := logger.Silent
+	if traceSql {
+		level = logger.Info
+	}
+	if slowThreshold <= 0 {
+		slowThreshold = DefaultSlowSqlThreshold
+	}
+	return &gormLogger{
+		level:         level,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (this *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *this
+	newLogger.level = level
+	return &newLogger
+}
+
+func (this *gormLogger) Info(ctx context.Context, msg string, args ...any) {
+	if this.level >= logger.Info {
+		FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (this *gormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if this.level >= logger.Warn {
+		FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (this *gormLogger) Error(ctx context.Context, msg string, args ...any) {
+	if this.level >= logger.Error {
+		FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (this *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if this.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && this.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		FromContext(ctx).ErrorContext(ctx, "sql failed", "elapsed", elapsed, "rows", rows, "sql", RedactSql(sql), "error", err)
+	case this.slowThreshold > 0 && elapsed > this.slowThreshold && this.level >= logger.Warn:
+		sql, rows := fc()
+		FromContext(ctx).WarnContext(ctx, "slow sql", "elapsed", elapsed, "threshold", this.slowThreshold, "rows", rows, "sql", RedactSql(sql))
+	case this.level >= logger.Info:
+		sql, rows := fc()
+		FromContext(ctx).InfoContext(ctx, "sql", "elapsed", elapsed, "rows", rows, "sql", RedactSql(sql))
+	}
+}
+
+// RedactSql returns sql with the login passwords it sets replaced by ***, so that logs don't hold them
+func RedactSql(sql string) string {
+	return passwordRegex.ReplaceAllString(sql, "${1}'***'")
+}
@@ -0,0 +1,20 @@
+package logging
+
+import "testing"
+
+func TestRedactSql(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "CREATE LOGIN [knight] WITH PASSWORD = 'it''s secret', CHECK_POLICY = OFF", want: "CREATE LOGIN [knight] WITH PASSWORD = '***', CHECK_POLICY = OFF"},
+		{sql: "ALTER LOGIN [a] WITH PASSWORD=N'new' OLD_PASSWORD = 'old'", want: "ALTER LOGIN [a] WITH PASSWORD='***' OLD_PASSWORD = '***'"},
+		{sql: "CREATE LOGIN [a] WITH password = 0x0200AB HASHED", want: "CREATE LOGIN [a] WITH password = '***' HASHED"},
+		{sql: "SELECT [name] FROM [sys].[server_principals] WHERE [name] = 'knight'", want: "SELECT [name] FROM [sys].[server_principals] WHERE [name] = 'knight'"},
+	}
+	for _, test := range tests {
+		if got := RedactSql(test.sql); got != test.want {
+			t.Errorf("RedactSql(%q) = %q, want %q", test.sql, got, test.want)
+		}
+	}
+}
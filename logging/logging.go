@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+
+	// DefaultSlowSqlThreshold is the statement duration above which -traceSql logs a slow query warning
+	DefaultSlowSqlThreshold = 200 * time.Millisecond
+)
+
+// Options configures the application logger
+type Options struct {
+	Level  string // debug, info, warn, or error
+	Format string // FormatText or FormatJson
+	File   string // if set, logs are written to this file in addition to stdout
+}
+
+// Setup creates the application logger from opts and installs it as the slog default.  The returned func closes the
+// log file, if any, and should be deferred by the caller.
+func Setup(opts Options) (closeFn func() error, err error) {
+	var level slog.Level
+	if opts.Level != "" {
+		err = level.UnmarshalText([]byte(opts.Level))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level %s, expected one of: debug, info, warn, error", opts.Level)
+		}
+	}
+
+	closeFn = func() error { return nil }
+	var writer io.Writer = os.Stdout
+	if opts.File != "" {
+		logFile, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %v", err)
+		}
+		writer = io.MultiWriter(os.Stdout, logFile)
+		closeFn = logFile.Close
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch opts.Format {
+	case "", FormatText:
+		handler = slog.NewTextHandler(writer, handlerOpts)
+	case FormatJson:
+		handler = slog.NewJSONHandler(writer, handlerOpts)
+	default:
+		_ = closeFn()
+		return nil, fmt.Errorf("invalid log format %s, expected one of: %s, %s", opts.Format, FormatText, FormatJson)
+	}
+	slog.SetDefault(slog.New(contextHandler{Handler: handler}))
+
+	return closeFn, nil
+}
@@ -0,0 +1,54 @@
+package mssql
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"strings"
+)
+
+const (
+	databaseExistsSql = "SELECT COUNT(*) FROM [sys].[databases] WHERE [name] = ?"
+	loginExistsSql    = "SELECT COUNT(*) FROM [sys].[server_principals] WHERE [name] = ?"
+	snapshotNamesSql  = "SELECT [name] FROM [sys].[databases] WHERE [source_database_id] = DB_ID(?) ORDER BY [create_date]"
+)
+
+// DatabaseExists returns true if the server has a database named name.  conn can be any connection to the server.
+func DatabaseExists(ctx context.Context, conn *gorm.DB, name string) (exists bool, err error) {
+	var count int
+	err = conn.WithContext(ctx).Raw(databaseExistsSql, name).Scan(&count).Error
+	return count > 0, err
+}
+
+// LoginExists returns true if the server has a login (server principal) named name.  conn can be any connection to
+// the server.
+func LoginExists(ctx context.Context, conn *gorm.DB, name string) (exists bool, err error) {
+	var count int
+	err = conn.WithContext(ctx).Raw(loginExistsSql, name).Scan(&count).Error
+	return count > 0, err
+}
+
+// SnapshotNames returns the names of the database snapshots of the database named name, oldest first
+func SnapshotNames(ctx context.Context, conn *gorm.DB, name string) (names []string, err error) {
+	err = conn.WithContext(ctx).Raw(snapshotNamesSql, name).Scan(&names).Error
+	return names, err
+}
+
+// QuoteString returns val as an N'...' string literal, for statements such as BACKUP that don't accept parameters
+func QuoteString(val string) string {
+	return "N'" + strings.ReplaceAll(val, "'", "''") + "'"
+}
+
+// JoinServerPath joins a file name to a directory on the database server, which may not use this machine's path
+// separator
+func JoinServerPath(dir string, name string) string {
+	separator := "/"
+	if strings.Contains(dir, `\`) {
+		separator = `\`
+	}
+	return strings.TrimRight(dir, `/\`) + separator + name
+}
+
+// ServerPathDir returns the directory of a file path on the database server
+func ServerPathDir(path string) string {
+	return path[:strings.LastIndexAny(path, `/\`)+1]
+}
@@ -0,0 +1,40 @@
+package mssql
+
+import (
+	"errors"
+	mssqldb "github.com/microsoft/go-mssqldb"
+)
+
+// SQL Server error numbers that jobs check for, see sys.messages
+const (
+	// ErrNumCannotDropObject: Cannot drop the %S_MSG '%.*ls', because it does not exist or you do not have permission.
+	ErrNumCannotDropObject int32 = 3701
+)
+
+// ErrorNumber returns the SQL Server error number of err, if it came from the server
+func ErrorNumber(err error) (number int32, ok bool) {
+	var sqlErr mssqldb.Error
+	if errors.As(err, &sqlErr) {
+		return sqlErr.Number, true
+	}
+	// the driver returns Error by value, but be tolerant of anything that wraps a pointer
+	var sqlErrPtr *mssqldb.Error
+	if errors.As(err, &sqlErrPtr) && sqlErrPtr != nil {
+		return sqlErrPtr.Number, true
+	}
+	return 0, false
+}
+
+// IsErrorNumber returns true if err came from the server with one of numbers
+func IsErrorNumber(err error, numbers ...int32) bool {
+	number, ok := ErrorNumber(err)
+	if !ok {
+		return false
+	}
+	for i := range numbers {
+		if numbers[i] == number {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package mssql
+
+import (
+	"github.com/Open-KO/OpenKO-gorm/kogen"
+	"sync"
+)
+
+// modelsMu is held while the OpenKO-gorm models point at a database.  They take their database name from
+// package-level variables, so jobs that use them can't run against different databases at the same time.
+var modelsMu sync.Mutex
+
+// UseModels waits for any other job using the OpenKO-gorm models to finish, then points them at dbName.  The returned
+// func releases them; jobs that only run scripts, e.g. watch, don't need to hold them.
+func UseModels(dbName string) (release func()) {
+	modelsMu.Lock()
+	SetModelDbName(dbName)
+	return modelsMu.Unlock
+}
+
+// SetModelDbName points the OpenKO-gorm models at dbName, e.g. a scratch database.  The caller must hold the models
+// through UseModels.
+func SetModelDbName(dbName string) {
+	kogen.SetLoginDbName(dbName)
+	kogen.SetGameDbName(dbName)
+	kogen.SetLogDbName(dbName)
+}
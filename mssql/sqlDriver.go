@@ -1,16 +1,21 @@
 package mssql
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/Open-KO/kodb-godef/enums/dbType"
-	_ "github.com/microsoft/go-mssqldb"
+	mssqldb "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/azuread"
+	_ "github.com/microsoft/go-mssqldb/integratedauth/krb5"
 	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"kodb-util/config"
-	"log"
+	"kodb-util/errs"
+	"kodb-util/logging"
 	"net/url"
-	"os"
+	"strconv"
 	"time"
 )
 
@@ -20,21 +25,31 @@ const (
 	// driverName is required by the sql.Open() function to identify the go-mssqldb driver
 	driverName = "sqlserver"
 
-	// 1: Username
-	// 2: Password
-	// 3: Host
-	// 4: Port
-	// 5: Instance Name
-	// 6: Database Name
-	// connStringFmt is the connection string format used when a dbuser/dbpass are specified
-	connStringFmt = "sqlserver://%[1]s:%[2]s@%[3]s:%[4]d/%[5]s?database=%[6]s"
-
-	// 1: Host
-	// 2: Port
-	// 2: Instance
-	// 3: Database
-	// winAuthConnStrFmt is the connection string format used when no dbuser is specified; Windows Authentication
-	winAuthConnStrFmt = "sqlserver://@%[1]s:%[2]d/%[3]s?database=%[4]s"
+	// connUrlScheme is the URL scheme go-mssqldb expects in URL-style connection strings
+	connUrlScheme = "sqlserver"
+
+	// go-mssqldb connection string parameter names, see: https://github.com/microsoft/go-mssqldb#connection-parameters-and-dsn
+	paramDatabase               = "database"
+	paramEncrypt                = "encrypt"
+	paramTrustServerCertificate = "TrustServerCertificate"
+	paramCertificate            = "certificate"
+	paramHostNameInCertificate  = "hostNameInCertificate"
+	paramDialTimeout            = "dial timeout"
+	paramConnectionTimeout      = "connection timeout"
+	paramPacketSize             = "packet size"
+	paramAppName                = "app name"
+	paramFailoverPartner        = "failoverpartner"
+	paramFailoverPort           = "failoverport"
+	paramFedAuth                = "fedauth"
+	paramAuthenticator          = "authenticator"
+	paramKrb5ConfigFile         = "krb5-configfile"
+	paramKrb5KeytabFile         = "krb5-keytabfile"
+	paramKrb5CredCacheFile      = "krb5-credcachefile"
+	paramKrb5Realm              = "krb5-realm"
+	paramServerSpn              = "ServerSPN"
+
+	// krb5AuthenticatorName is registered by the go-mssqldb/integratedauth/krb5 package
+	krb5AuthenticatorName = "krb5"
 
 	// DefaultSysDbName is the name of the main system database in MSSQL Server; used for database creation queries
 	DefaultSysDbName = "master"
@@ -46,39 +61,140 @@ const (
 	BatchTerminator = "\nGO"
 )
 
+// JobOptions are the settings of a run that jobs read from the driver, rather than from the configuration file
+type JobOptions struct {
+	// ImportBatchSize is the number of rows sent in each insert batch.  Valid values 2-999; anything else uses the
+	// import default
+	ImportBatchSize int
+
+	// DropOrphanLogins has clean drop the orphan logins it finds instead of only reporting them
+	DropOrphanLogins bool
+
+	// Force skips the safety guarded row check; the host checks still apply
+	Force bool
+
+	// TraceSql logs every statement with its duration and row count, with statements slower than SlowSqlThreshold
+	// logged as warnings.  0 uses logging.DefaultSlowSqlThreshold
+	TraceSql         bool
+	SlowSqlThreshold time.Duration
+}
+
 // MssqlDbDriver contains information needed to perform our application's SQL connections
 type MssqlDbDriver struct {
+	ctx         context.Context // used to begin the top-level transaction; cancelling it rolls the transaction back
 	dbConfig    config.DatabaseConfig
+	Config      *config.KodbConfig // the whole configuration the run was started with
 	GenDbConfig config.GenDbConfig
 	DbType      dbType.DbType
+	Options     JobOptions
 	connString  string
 	conn        *gorm.DB
 	masterConn  *gorm.DB
 	tx          *gorm.DB
 }
 
-// NewMssqlDbDriver returns an instance of MssqlDbDriver populated with GenDbConfig for a particular database connection
-func NewMssqlDbDriver(dbConfig config.GenDbConfig, databaseType dbType.DbType) *MssqlDbDriver {
+// NewMssqlDbDriver returns an instance of MssqlDbDriver populated with GenDbConfig for a particular database connection.
+// ctx should live for the whole run against the database, as the top-level transaction is bound to it.
+func NewMssqlDbDriver(ctx context.Context, conf *config.KodbConfig, dbConfig config.GenDbConfig, databaseType dbType.DbType, opts JobOptions) *MssqlDbDriver {
 	return &MssqlDbDriver{
-		dbConfig:    config.GetConfig().DatabaseConfig,
+		ctx:         ctx,
+		dbConfig:    conf.DatabaseConfig,
+		Config:      conf,
 		GenDbConfig: dbConfig,
 		DbType:      databaseType,
+		Options:     opts,
 	}
 }
 
+// SchemaDir returns the configured path of the OpenKO-db project
+func (this *MssqlDbDriver) SchemaDir() string {
+	return this.Config.GenConfig.SchemaDir
+}
+
 // GetConnectionString returns a formatted connection string using the configurations on MssqlDbDriver
 func (this *MssqlDbDriver) GetConnectionString(dbName string) string {
-	if this.dbConfig.User == "" {
-		// Attempt Windows Auth
-		this.connString = fmt.Sprintf(winAuthConnStrFmt, this.dbConfig.Host, this.dbConfig.Port, this.dbConfig.Instance, dbName)
-	} else {
-		// Used Mixed Auth
-		this.connString = fmt.Sprintf(connStringFmt, this.dbConfig.User, url.QueryEscape(this.dbConfig.Password), this.dbConfig.Host, this.dbConfig.Port, this.dbConfig.Instance, dbName)
+	connUrl := url.URL{
+		Scheme: connUrlScheme,
+		Host:   fmt.Sprintf("%s:%d", this.dbConfig.Host, this.dbConfig.Port),
+		Path:   this.dbConfig.Instance,
+	}
+
+	// Windows Auth is attempted when no user is specified
+	if this.dbConfig.User != "" && this.dbConfig.AuthMode != config.AuthModeWindows {
+		connUrl.User = url.UserPassword(this.dbConfig.User, this.dbConfig.Password)
 	}
 
+	query := url.Values{}
+	query.Set(paramDatabase, dbName)
+	setIfNotEmpty(query, paramEncrypt, this.dbConfig.Encrypt)
+	if this.dbConfig.TrustServerCertificate {
+		query.Set(paramTrustServerCertificate, "true")
+	}
+	setIfNotEmpty(query, paramCertificate, this.dbConfig.Certificate)
+	setIfNotEmpty(query, paramHostNameInCertificate, this.dbConfig.HostNameInCertificate)
+	setIfNotZero(query, paramDialTimeout, this.dbConfig.DialTimeout)
+	setIfNotZero(query, paramConnectionTimeout, this.dbConfig.ConnectionTimeout)
+	setIfNotZero(query, paramPacketSize, this.dbConfig.PacketSize)
+	setIfNotEmpty(query, paramAppName, this.dbConfig.AppName)
+	setIfNotEmpty(query, paramFailoverPartner, this.dbConfig.FailoverPartner)
+	setIfNotZero(query, paramFailoverPort, this.dbConfig.FailoverPort)
+
+	switch this.dbConfig.AuthMode {
+	case config.AuthModeAzure:
+		query.Set(paramFedAuth, this.dbConfig.Azure.FedAuth)
+	case config.AuthModeKerberos:
+		query.Set(paramAuthenticator, krb5AuthenticatorName)
+		setIfNotEmpty(query, paramKrb5ConfigFile, this.dbConfig.Kerberos.ConfigFile)
+		setIfNotEmpty(query, paramKrb5KeytabFile, this.dbConfig.Kerberos.KeytabFile)
+		setIfNotEmpty(query, paramKrb5CredCacheFile, this.dbConfig.Kerberos.CredCacheFile)
+		setIfNotEmpty(query, paramKrb5Realm, this.dbConfig.Kerberos.Realm)
+		setIfNotEmpty(query, paramServerSpn, this.dbConfig.Kerberos.ServerSpn)
+	}
+
+	// raw params win over everything above
+	for key, val := range this.dbConfig.Params {
+		query.Set(key, val)
+	}
+
+	connUrl.RawQuery = query.Encode()
+	this.connString = connUrl.String()
 	return this.connString
 }
 
+// getDialector returns the gorm dialector for dbName.  Azure AD and Kerberos authentication go through a go-mssqldb
+// connector so that the token/ticket providers are configured; everything else uses the plain connection string.
+func (this *MssqlDbDriver) getDialector(dbName string) (gorm.Dialector, error) {
+	connString := this.GetConnectionString(dbName)
+
+	var connector *mssqldb.Connector
+	var err error
+	switch this.dbConfig.AuthMode {
+	case config.AuthModeAzure:
+		connector, err = azuread.NewConnector(connString)
+	case config.AuthModeKerberos:
+		connector, err = mssqldb.NewConnector(connString)
+	default:
+		return sqlserver.Open(connString), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s connector: %v", this.dbConfig.AuthMode, err)
+	}
+
+	return sqlserver.New(sqlserver.Config{Conn: sql.OpenDB(connector)}), nil
+}
+
+func setIfNotEmpty(query url.Values, key string, val string) {
+	if val != "" {
+		query.Set(key, val)
+	}
+}
+
+func setIfNotZero(query url.Values, key string, val int) {
+	if val != 0 {
+		query.Set(key, strconv.Itoa(val))
+	}
+}
+
 // GetConnection returns a *gorm.DB instance from a connection string generated using the configuration on MssqlDbDriver
 func (this *MssqlDbDriver) GetConnection() (*gorm.DB, error) {
 	// if there's an existing connection, re-use it
@@ -90,16 +206,7 @@ func (this *MssqlDbDriver) GetConnection() (*gorm.DB, error) {
 		return this.conn, nil
 	}
 
-	gormLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold:             time.Second,   // Slow SQL threshold
-			LogLevel:                  logger.Silent, // Log level
-			IgnoreRecordNotFoundError: true,          // Ignore ErrRecordNotFound error for logger
-			ParameterizedQueries:      true,          // Don't include params in the SQL log
-			Colorful:                  false,         // Disable color
-		},
-	)
+	gormLogger := logging.NewGormLogger(this.Options.TraceSql, this.Options.SlowSqlThreshold)
 
 	gormConfig := &gorm.Config{
 		Logger: gormLogger,
@@ -107,9 +214,13 @@ func (this *MssqlDbDriver) GetConnection() (*gorm.DB, error) {
 
 	// open a connection against the master db
 	var err error
-	this.conn, err = gorm.Open(sqlserver.Open(this.GetConnectionString(this.GenDbConfig.Name)), gormConfig)
+	dialector, err := this.getDialector(this.GenDbConfig.Name)
+	if err != nil {
+		return nil, &errs.ConnectionError{Host: this.dbConfig.Host, Database: this.GenDbConfig.Name, Err: err}
+	}
+	this.conn, err = gorm.Open(dialector, gormConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open connection to mssql: %v", err)
+		return nil, &errs.ConnectionError{Host: this.dbConfig.Host, Database: this.GenDbConfig.Name, Err: err}
 	}
 
 	return this.conn, nil
@@ -121,16 +232,7 @@ func (this *MssqlDbDriver) GetMasterConnection() (*gorm.DB, error) {
 		return this.masterConn, nil
 	}
 
-	gormLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
-		logger.Config{
-			SlowThreshold:             time.Second,   // Slow SQL threshold
-			LogLevel:                  logger.Silent, // Log level
-			IgnoreRecordNotFoundError: true,          // Ignore ErrRecordNotFound error for logger
-			ParameterizedQueries:      true,          // Don't include params in the SQL log
-			Colorful:                  false,         // Disable color
-		},
-	)
+	gormLogger := logging.NewGormLogger(this.Options.TraceSql, this.Options.SlowSqlThreshold)
 
 	gormConfig := &gorm.Config{
 		Logger:                 gormLogger,
@@ -139,9 +241,13 @@ func (this *MssqlDbDriver) GetMasterConnection() (*gorm.DB, error) {
 
 	// open a connection against the master db
 	var err error
-	this.masterConn, err = gorm.Open(sqlserver.Open(this.GetConnectionString(DefaultSysDbName)), gormConfig)
+	dialector, err := this.getDialector(DefaultSysDbName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open connection to mssql: %v", err)
+		return nil, &errs.ConnectionError{Host: this.dbConfig.Host, Database: DefaultSysDbName, Err: err}
+	}
+	this.masterConn, err = gorm.Open(dialector, gormConfig)
+	if err != nil {
+		return nil, &errs.ConnectionError{Host: this.dbConfig.Host, Database: DefaultSysDbName, Err: err}
 	}
 
 	return this.masterConn, nil
@@ -151,6 +257,11 @@ func (this *MssqlDbDriver) GetDb() *gorm.DB {
 	return this.conn
 }
 
+// HasTx returns true if the top-level transaction fence for this driver is open
+func (this *MssqlDbDriver) HasTx() bool {
+	return this.tx != nil
+}
+
 // GetTx returns the top-level transaction fence for this driver
 func (this *MssqlDbDriver) GetTx() (tx *gorm.DB, err error) {
 	if this.conn == nil {
@@ -160,29 +271,51 @@ func (this *MssqlDbDriver) GetTx() (tx *gorm.DB, err error) {
 		}
 	}
 	if this.tx == nil {
-		this.tx = this.conn.Begin()
+		this.tx = this.conn.WithContext(this.ctx).Begin()
+		if this.tx.Error != nil {
+			err = this.tx.Error
+			this.tx = nil
+			return nil, err
+		}
 	}
 
 	return this.tx, nil
 }
 
-// CommitTx attempts to commit the top level transaction fence for this driver
+// CommitTx attempts to commit the top level transaction fence for this driver.  The next GetTx call will open a new one.
 func (this *MssqlDbDriver) CommitTx() error {
 	if this.tx != nil {
-		return this.tx.Commit().Error
+		tx := this.tx
+		this.tx = nil
+		return tx.Commit().Error
 	}
 	return fmt.Errorf("no transaction to commit")
 }
 
-// RollbackTx attempts to rollback the top level transaction fence for this driver
+// RollbackTx attempts to rollback the top level transaction fence for this driver.  The next GetTx call will open a new one.
+// If the driver's context was cancelled the transaction has already been rolled back by database/sql, which is not
+// treated as an error.
 func (this *MssqlDbDriver) RollbackTx() error {
 	if this.tx != nil {
-		return this.tx.Rollback().Error
+		tx := this.tx
+		this.tx = nil
+		err := tx.Rollback().Error
+		if errors.Is(err, sql.ErrTxDone) && this.ctx.Err() != nil {
+			return nil
+		}
+		return err
 	}
 	return fmt.Errorf("no transaction to rollback")
 }
 
-// CloseConnection nulls the connection pointer pointer; gorm doesn't require manual connection closes
+// CloseConnection closes the connection pool to GenDbConfig.Name and nulls the connection pointer so that the database
+// isn't held in use; the master connection is left open
 func (this *MssqlDbDriver) CloseConnection() {
+	if this.conn != nil {
+		if sqlDb, err := this.conn.DB(); err == nil {
+			_ = sqlDb.Close()
+		}
+	}
 	this.conn = nil
+	this.tx = nil
 }
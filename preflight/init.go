@@ -0,0 +1,329 @@
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// passwordEnvRef is written instead of the database password when the user doesn't want it saved in the file
+	passwordEnvRef = "${KODB_DB_PASSWORD}"
+
+	// clearValue is typed to clear a prompt's default
+	clearValue = "-"
+)
+
+// prompter reads answers to the Init questions from stdin
+type prompter struct {
+	reader *bufio.Reader
+	path   string // the config file, for errors
+}
+
+// Init asks for the connection settings and the OpenKO-db directory, tests them with the preflight checks, and writes
+// the configuration to path.  The existing file at path, or template if there isn't one, provides the defaults and
+// every other setting; only the answered properties are changed, so comments are kept.  Requires stdin to be a
+// terminal.
+func Init(ctx context.Context, path string, template []byte) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return &errs.ConfigError{File: path, Err: fmt.Errorf("init asks questions; stdin isn't a terminal")}
+	}
+
+	baseBytes, err := os.ReadFile(path)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if isNew {
+		baseBytes = template
+	} else if err != nil {
+		return &errs.FileSystemError{Op: "read", Path: path, Err: err}
+	}
+	doc := yaml.Node{}
+	err = yaml.Unmarshal(baseBytes, &doc)
+	if err == nil && len(doc.Content) == 0 {
+		err = fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return &errs.ConfigError{File: path, Err: fmt.Errorf("failed to parse: %v", err)}
+	}
+	conf := &config.KodbConfig{}
+	err = doc.Decode(conf)
+	if err != nil {
+		return &errs.ConfigError{File: path, Err: fmt.Errorf("failed to parse: %v", err)}
+	}
+	dbConf := &conf.DatabaseConfig
+	if isNew {
+		// the template's user and password are placeholders
+		dbConf.User = ""
+		dbConf.Password = ""
+	}
+
+	if isNew {
+		fmt.Printf("Creating %s.  Press Enter to keep the value in brackets, or type %s to clear it.\n", path, clearValue)
+	} else {
+		fmt.Printf("Updating the connection settings of %s.  Press Enter to keep the value in brackets, or type %s to clear it.\n", path, clearValue)
+	}
+	p := prompter{reader: bufio.NewReader(os.Stdin), path: path}
+	dbConf.Host, err = p.ask("Host", dbConf.Host)
+	if err != nil {
+		return err
+	}
+	for {
+		port, err := p.ask("Port", strconv.Itoa(dbConf.Port))
+		if err != nil {
+			return err
+		}
+		dbConf.Port, err = strconv.Atoi(port)
+		if err == nil {
+			break
+		}
+		fmt.Printf("%s is not a port number\n", port)
+	}
+	dbConf.Instance, err = p.ask("Instance name (blank for the default instance)", dbConf.Instance)
+	if err != nil {
+		return err
+	}
+
+	authMode := dbConf.AuthMode
+	if authMode == "" {
+		authMode = config.AuthModeSql
+		if !isNew && dbConf.User == "" {
+			authMode = config.AuthModeWindows
+		}
+	}
+	for {
+		authMode, err = p.ask(fmt.Sprintf("Authentication (%s, %s, %s, %s)", config.AuthModeSql, config.AuthModeWindows, config.AuthModeAzure, config.AuthModeKerberos), authMode)
+		if err != nil {
+			return err
+		}
+		authMode = strings.ToLower(authMode)
+		if authMode == config.AuthModeSql || authMode == config.AuthModeWindows || authMode == config.AuthModeAzure || authMode == config.AuthModeKerberos {
+			break
+		}
+		fmt.Printf("unknown authentication %s\n", authMode)
+	}
+	dbConf.AuthMode = authMode
+
+	isPasswordSaved := true
+	switch authMode {
+	case config.AuthModeWindows:
+		dbConf.User = ""
+		dbConf.Password = ""
+	case config.AuthModeKerberos:
+		dbConf.Kerberos.KeytabFile, err = p.ask("Kerberos keytab file", dbConf.Kerberos.KeytabFile)
+		if err != nil {
+			return err
+		}
+		dbConf.Kerberos.Realm, err = p.ask("Kerberos realm", dbConf.Kerberos.Realm)
+		if err != nil {
+			return err
+		}
+		dbConf.Kerberos.ConfigFile, err = p.ask("krb5.conf", dbConf.Kerberos.ConfigFile)
+		if err != nil {
+			return err
+		}
+	default:
+		if authMode == config.AuthModeAzure {
+			dbConf.Azure.FedAuth, err = p.ask("Azure fedAuth workflow", dbConf.Azure.FedAuth)
+			if err != nil {
+				return err
+			}
+		}
+		dbConf.User, err = p.ask("User", dbConf.User)
+		if err != nil {
+			return err
+		}
+		prompt := "Password: "
+		if dbConf.Password != "" {
+			prompt = "Password (blank keeps the current one): "
+		}
+		password, err := config.ReadSecret(prompt)
+		if err != nil {
+			return &errs.ConfigError{File: path, Err: fmt.Errorf("failed to read password: %v", err)}
+		}
+		if password != "" {
+			dbConf.Password = password
+			// passwordFile would take precedence over the new password
+			if dbConf.PasswordFile != "" {
+				dbConf.PasswordFile = ""
+				setValue(doc.Content[0], "", "databaseConfig", "passwordFile")
+			}
+		}
+		if dbConf.Password != "" {
+			isPasswordSaved, err = p.confirm(fmt.Sprintf("Save the password in the file? Otherwise it's read from %s", passwordEnvRef), false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	dbConf.TrustServerCertificate, err = p.confirm("Trust the server's certificate, e.g. a self-signed one of SQL Server in a container?", dbConf.TrustServerCertificate)
+	if err != nil {
+		return err
+	}
+	conf.GenConfig.SchemaDir, err = p.ask("OpenKO-db directory", conf.GenConfig.SchemaDir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Testing the connection...")
+	// the file's password may be an ${ENV_VAR} reference or passwordFile
+	testConf := *conf
+	testConf.DatabaseConfig.Password, err = config.ResolveSecret(dbConf.Password, dbConf.PasswordFile)
+	result, runErr := Result{}, err
+	if runErr == nil {
+		result, runErr = Run(ctx, &testConf, true)
+	}
+	isSave := true
+	if runErr != nil {
+		fmt.Printf("  connection failed: %v\n", runErr)
+	} else {
+		fmt.Printf("  connected to %s %s as %s", result.Server.Edition, result.Server.Version, result.Server.Login)
+		if roles := result.Server.Roles(); len(roles) > 0 {
+			fmt.Printf(" (%s)", strings.Join(roles, ", "))
+		}
+		fmt.Println()
+	}
+	for i := range result.Problems {
+		fmt.Printf("  problem: %s\n", result.Problems[i])
+	}
+	for i := range result.Warnings {
+		fmt.Printf("  warning: %s\n", result.Warnings[i])
+	}
+	if runErr != nil || len(result.Problems) > 0 {
+		isSave, err = p.confirm(fmt.Sprintf("Write %s anyway?", path), runErr == nil)
+		if err != nil {
+			return err
+		}
+	}
+	if !isSave {
+		return &errs.ConfigError{File: path, Err: fmt.Errorf("not written")}
+	}
+
+	password := dbConf.Password
+	if !isPasswordSaved {
+		password = passwordEnvRef
+	}
+	root := doc.Content[0]
+	setValue(root, dbConf.Host, "databaseConfig", "host")
+	setValue(root, dbConf.Port, "databaseConfig", "port")
+	setValue(root, dbConf.Instance, "databaseConfig", "instance")
+	setValue(root, dbConf.AuthMode, "databaseConfig", "authMode")
+	setValue(root, dbConf.User, "databaseConfig", "user")
+	setValue(root, password, "databaseConfig", "password")
+	setValue(root, dbConf.TrustServerCertificate, "databaseConfig", "trustServerCertificate")
+	if authMode == config.AuthModeAzure {
+		setValue(root, dbConf.Azure.FedAuth, "databaseConfig", "azure", "fedAuth")
+	}
+	if authMode == config.AuthModeKerberos {
+		setValue(root, dbConf.Kerberos.KeytabFile, "databaseConfig", "kerberos", "keytabFile")
+		setValue(root, dbConf.Kerberos.Realm, "databaseConfig", "kerberos", "realm")
+		setValue(root, dbConf.Kerberos.ConfigFile, "databaseConfig", "kerberos", "configFile")
+	}
+	setValue(root, conf.GenConfig.SchemaDir, "genConfig", "schemaDir")
+
+	buf := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&doc)
+	if err != nil {
+		return &errs.ConfigError{File: path, Err: err}
+	}
+	// the file may hold the database password
+	err = os.WriteFile(path, buf.Bytes(), 0600)
+	if err != nil {
+		return &errs.FileSystemError{Op: "write", Path: path, Err: err}
+	}
+	fmt.Printf("Wrote %s\n", path)
+	if !isPasswordSaved {
+		fmt.Printf("Set %s to the password before running kodb-util\n", strings.Trim(passwordEnvRef, "${}"))
+	}
+	return nil
+}
+
+// ask prints question and returns the answer, or def if the answer is blank
+func (this prompter) ask(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := this.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", &errs.ConfigError{File: this.path, Err: fmt.Errorf("failed to read answer: %v", err)}
+	}
+	answer := strings.TrimSpace(line)
+	switch answer {
+	case "":
+		return def, nil
+	case clearValue:
+		return "", nil
+	}
+	return answer, nil
+}
+
+// confirm asks a yes/no question, returning def if the answer is blank
+func (this prompter) confirm(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		answer, err := this.ask(fmt.Sprintf("%s (%s)", question, choices), "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// setValue sets the scalar at path (mapping keys from root) to val, adding any keys that are missing.  The comments of
+// existing nodes are kept.
+func setValue(root *yaml.Node, val any, path ...string) {
+	node := root
+	for _, key := range path {
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			if node.Kind != yaml.MappingNode {
+				// an empty value, e.g. "azure:" with nothing under it
+				node.Kind, node.Tag, node.Value = yaml.MappingNode, "!!map", ""
+			}
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		}
+		node = next
+	}
+
+	node.Kind, node.Content, node.Style = yaml.ScalarNode, nil, 0
+	switch v := val.(type) {
+	case string:
+		node.Tag, node.Value = "!!str", v
+		if v == "" {
+			node.Tag = "!!null"
+		}
+	case int:
+		node.Tag, node.Value = "!!int", strconv.Itoa(v)
+	case bool:
+		node.Tag, node.Value = "!!bool", strconv.FormatBool(v)
+	}
+}
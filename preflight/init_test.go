@@ -0,0 +1,74 @@
+package preflight
+
+import (
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+const setValueYaml = `# connection settings
+databaseConfig:
+  host: localhost # the server
+  port: 1433
+  user: knight
+  azure:
+genConfig:
+  schemaDir: ./OpenKO-db
+`
+
+func TestSetValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		val   any
+		path  []string
+		want  []string // lines the output must contain
+		isNot []string // lines the output must not contain
+	}{
+		{name: "replace string", val: "db.local", path: []string{"databaseConfig", "host"}, want: []string{"  host: db.local # the server", "# connection settings"}},
+		{name: "replace int", val: 1500, path: []string{"databaseConfig", "port"}, want: []string{"  port: 1500"}},
+		{name: "clear", val: "", path: []string{"databaseConfig", "user"}, want: []string{"  user:"}, isNot: []string{"knight"}},
+		{name: "add key", val: true, path: []string{"databaseConfig", "trustServerCertificate"}, want: []string{"  trustServerCertificate: true", "  port: 1433"}},
+		{name: "add under empty value", val: "ActiveDirectoryDefault", path: []string{"databaseConfig", "azure", "fedAuth"}, want: []string{"  azure:", "    fedAuth: ActiveDirectoryDefault"}},
+		{name: "add mapping", val: "EXAMPLE.COM", path: []string{"databaseConfig", "kerberos", "realm"}, want: []string{"  kerberos:", "    realm: EXAMPLE.COM"}},
+		{name: "keep quoting of strings", val: "123", path: []string{"genConfig", "schemaDir"}, want: []string{`  schemaDir: "123"`}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := yaml.Node{}
+			err := yaml.Unmarshal([]byte(setValueYaml), &doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setValue(doc.Content[0], test.val, test.path...)
+
+			out := strings.Builder{}
+			encoder := yaml.NewEncoder(&out)
+			encoder.SetIndent(2)
+			err = encoder.Encode(&doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(out.String(), "\n")
+			for _, want := range test.want {
+				if !contains(lines, want) {
+					t.Errorf("output is missing %q:\n%s", want, out.String())
+				}
+			}
+			for _, isNot := range test.isNot {
+				if strings.Contains(out.String(), isNot) {
+					t.Errorf("output contains %q:\n%s", isNot, out.String())
+				}
+			}
+		})
+	}
+}
+
+// contains returns true if one of lines is line, ignoring trailing spaces
+func contains(lines []string, line string) bool {
+	for i := range lines {
+		if strings.TrimRight(lines[i], " ") == line {
+			return true
+		}
+	}
+	return false
+}
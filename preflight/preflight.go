@@ -0,0 +1,189 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"github.com/Open-KO/kodb-godef/enums/dbType"
+	"kodb-util/artifacts"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"kodb-util/report"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the preflight package checks that the configured server and OpenKO-db can run the jobs before any of them starts,
+// and bootstraps the config file interactively with the same checks
+
+const (
+	// MinMajorVersion is SQL Server 2016, the first version with DROP ... IF EXISTS, which import uses
+	MinMajorVersion = 13
+
+	// engineEditionAzureSqlDatabase is the SERVERPROPERTY('EngineEdition') of Azure SQL Database, which doesn't support
+	// the USE statements the models and templates rely on
+	engineEditionAzureSqlDatabase = 5
+
+	serverInfoSql = `SELECT CAST(SERVERPROPERTY('ProductVersion') AS NVARCHAR(128)) AS [version],
+	CAST(SERVERPROPERTY('Edition') AS NVARCHAR(128)) AS [edition],
+	CAST(SERVERPROPERTY('EngineEdition') AS INT) AS [engineEdition],
+	SUSER_SNAME() AS [login],
+	ISNULL(IS_SRVROLEMEMBER('sysadmin'), 0) AS [isSysadmin],
+	ISNULL(IS_SRVROLEMEMBER('dbcreator'), 0) AS [isDbCreator],
+	ISNULL(IS_SRVROLEMEMBER('securityadmin'), 0) AS [isSecurityAdmin],
+	ISNULL(HAS_PERMS_BY_NAME(NULL, NULL, 'CREATE ANY DATABASE'), 0) AS [canCreateDatabases],
+	ISNULL(HAS_PERMS_BY_NAME(NULL, NULL, 'ALTER ANY LOGIN'), 0) AS [canCreateLogins]`
+)
+
+// templates are the OpenKO-db/Templates files that import and export structure render
+var templates = []string{artifacts.CreateDatabaseTemplate, artifacts.CreateSchemaTemplate, artifacts.CreateUserTemplate, artifacts.CreateLoginTemplate}
+
+// ServerInfo describes the configured server and what the configured login can do on it
+type ServerInfo struct {
+	Version            string // e.g. 16.0.1000.6
+	Edition            string // e.g. Developer Edition (64-bit)
+	EngineEdition      int    `gorm:"column:engineEdition"`
+	Login              string // the login the connection authenticated as
+	IsSysadmin         bool   `gorm:"column:isSysadmin"`
+	IsDbCreator        bool   `gorm:"column:isDbCreator"`
+	IsSecurityAdmin    bool   `gorm:"column:isSecurityAdmin"`
+	CanCreateDatabases bool   `gorm:"column:canCreateDatabases"` // through sysadmin, dbcreator, or CREATE ANY DATABASE
+	CanCreateLogins    bool   `gorm:"column:canCreateLogins"`    // through sysadmin, securityadmin, or ALTER ANY LOGIN
+}
+
+// MajorVersion returns the major version of the server, e.g. 16 for SQL Server 2022, or 0 if it's unknown
+func (this ServerInfo) MajorVersion() int {
+	major, _, _ := strings.Cut(this.Version, ".")
+	version, _ := strconv.Atoi(major)
+	return version
+}
+
+// Roles returns the names of the fixed server roles the login is a member of
+func (this ServerInfo) Roles() []string {
+	roles := []string{}
+	for _, role := range []struct {
+		Name     string
+		IsMember bool
+	}{{"sysadmin", this.IsSysadmin}, {"dbcreator", this.IsDbCreator}, {"securityadmin", this.IsSecurityAdmin}} {
+		if role.IsMember {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles
+}
+
+// Result is the outcome of the preflight checks.  Problems stop jobs from running; warnings don't.
+type Result struct {
+	Server   ServerInfo
+	Problems []string
+	Warnings []string
+}
+
+// Run checks the OpenKO-db directory of conf, then connects to the configured server and checks its version and
+// edition, and the permissions of the configured login.  Missing permissions to create databases and logins are
+// problems when requireCreate is set, e.g. for import and clean, and warnings otherwise.  A failed connection is
+// returned as the error, with the directory checks already in the result.
+func Run(ctx context.Context, conf *config.KodbConfig, requireCreate bool) (result Result, err error) {
+	result.Problems = append(result.Problems, checkSchemaDir(conf.GenConfig.SchemaDir)...)
+
+	driver := mssql.NewMssqlDbDriver(ctx, conf, config.GenDbConfig{}, dbType.GAME, mssql.JobOptions{})
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return result, err
+	}
+	if sqlDb, err := conn.DB(); err == nil {
+		defer sqlDb.Close()
+	}
+	err = conn.WithContext(ctx).Raw(serverInfoSql).Scan(&result.Server).Error
+	if err != nil {
+		return result, fmt.Errorf("failed to read server properties: %w", err)
+	}
+	server := result.Server
+
+	if major := server.MajorVersion(); major < MinMajorVersion {
+		result.Problems = append(result.Problems, fmt.Sprintf("SQL Server %s is not supported; version %d (SQL Server 2016) or later is required", server.Version, MinMajorVersion))
+	}
+	if server.EngineEdition == engineEditionAzureSqlDatabase {
+		result.Problems = append(result.Problems, "Azure SQL Database is not supported; use SQL Server or Azure SQL Managed Instance")
+	}
+
+	missing := []string{}
+	if !server.CanCreateDatabases {
+		missing = append(missing, fmt.Sprintf("login %s can't create or drop databases; import, clean, restore, and snapshots need it to be a member of sysadmin or dbcreator", server.Login))
+	}
+	if !server.CanCreateLogins && hasLogins(conf) {
+		missing = append(missing, fmt.Sprintf("login %s can't create or drop logins; import and clean need it to be a member of sysadmin or securityadmin", server.Login))
+	}
+	if requireCreate {
+		result.Problems = append(result.Problems, missing...)
+	} else {
+		result.Warnings = append(result.Warnings, missing...)
+	}
+
+	return result, nil
+}
+
+// Check runs the preflight checks, logs the result, and records the warnings in the context's report.  Returns a
+// ValidationError if any problems were found.
+func Check(ctx context.Context, conf *config.KodbConfig, requireCreate bool) (result Result, err error) {
+	logging.FromContext(ctx).InfoContext(ctx, "running preflight checks", "server", conf.DatabaseConfig.Host, "schemaDir", conf.GenConfig.SchemaDir)
+	result, err = Run(ctx, conf, requireCreate)
+	for i := range result.Problems {
+		logging.FromContext(ctx).ErrorContext(ctx, "preflight problem", "problem", result.Problems[i])
+	}
+	if err != nil {
+		return result, err
+	}
+	server := result.Server
+	logging.FromContext(ctx).InfoContext(ctx, "connected to server", "version", server.Version, "edition", server.Edition, "login", server.Login, "roles", strings.Join(server.Roles(), ","))
+	for i := range result.Warnings {
+		report.Warn(ctx, "preflight warning", "warning", result.Warnings[i])
+	}
+
+	if len(result.Problems) > 0 {
+		return result, &errs.ValidationError{Check: "preflight", Problems: len(result.Problems), Err: fmt.Errorf("preflight found %d problem(s)", len(result.Problems))}
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "preflight checks passed")
+	return result, nil
+}
+
+// checkSchemaDir returns the problems with the OpenKO-db directory: it, Templates, and ManualSetup must exist, and
+// Templates must have the templates import renders
+func checkSchemaDir(schemaDir string) (problems []string) {
+	if !isDir(schemaDir) {
+		return []string{fmt.Sprintf("schemaDir %s does not exist; run: git submodule update --init --recursive --remote", schemaDir)}
+	}
+	for _, dir := range []string{artifacts.TemplatesDir, artifacts.ManualSetupDir} {
+		if !isDir(filepath.Join(schemaDir, dir)) {
+			problems = append(problems, fmt.Sprintf("schemaDir %s has no %s directory; is it an OpenKO-db checkout?", schemaDir, dir))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	for _, template := range templates {
+		if _, err := os.Stat(filepath.Join(schemaDir, artifacts.TemplatesDir, template)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing from %s", template, filepath.Join(schemaDir, artifacts.TemplatesDir)))
+		}
+	}
+	return problems
+}
+
+// isDir returns true if path is an existing directory
+func isDir(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
+
+// hasLogins returns true if any database has logins configured
+func hasLogins(conf *config.KodbConfig) bool {
+	for i := range conf.GenConfig.GameDbs {
+		if len(conf.GenConfig.GameDbs[i].Logins) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"kodb-util/logging"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// redrawInterval limits how often the terminal line is redrawn
+	redrawInterval = 100 * time.Millisecond
+
+	// logInterval is how often a progress line is logged when not attached to a terminal
+	logInterval = 10 * time.Second
+)
+
+// Status is a snapshot of a Reporter's progress, passed to the Listener in its context
+type Status struct {
+	Label      string  `json:"label"`
+	Unit       string  `json:"unit"`
+	Current    string  `json:"current,omitempty"`
+	Done       int     `json:"done"`
+	Total      int     `json:"total"`
+	Rows       int64   `json:"rows"`
+	RowsPerSec float64 `json:"rowsPerSec"`
+	EtaMs      int64   `json:"etaMs"`
+	Finished   bool    `json:"finished,omitempty"`
+}
+
+// Listener receives the progress of every Reporter created with a context it was attached to by WithListener
+type Listener func(status Status)
+
+type listenerKey struct{}
+
+type outputKey struct{}
+
+// WithListener returns a context whose Reporters also pass their status to listener, at most every redrawInterval
+func WithListener(ctx context.Context, listener Listener) context.Context {
+	return context.WithValue(ctx, listenerKey{}, listener)
+}
+
+// WithOutput returns a context whose Reporters draw their status on out instead of stderr.  Progress is only drawn
+// when out is a terminal; otherwise it's logged periodically.
+func WithOutput(ctx context.Context, out io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, out)
+}
+
+// Reporter tracks the progress of a long-running job made of a known number of steps (batches, tables).  On a terminal
+// the status is redrawn in place on stderr; otherwise a progress line is logged periodically.
+type Reporter struct {
+	label   string
+	unit    string
+	total   int
+	done    int
+	rows    int64
+	current string
+
+	start    time.Time
+	lastDraw time.Time
+	isLogged bool // a periodic line was logged, so log a final line too
+	out      io.Writer
+	isTty    bool
+
+	ctx        context.Context
+	listener   Listener
+	lastNotify time.Time
+}
+
+// New creates a Reporter for total steps of unit, e.g. New(ctx, "importing table data", "batches", 1200)
+func New(ctx context.Context, label string, unit string, total int) *Reporter {
+	listener, _ := ctx.Value(listenerKey{}).(Listener)
+	out, ok := ctx.Value(outputKey{}).(io.Writer)
+	if !ok {
+		out = os.Stderr
+	}
+	return &Reporter{
+		ctx:      ctx,
+		listener: listener,
+		label:    label,
+		unit:     unit,
+		total:    total,
+		start:    time.Now(),
+		out:      out,
+		isTty:    isTerminal(out),
+	}
+}
+
+// SetCurrent sets the name of the item being worked on, e.g. the table or script name
+func (this *Reporter) SetCurrent(name string) {
+	this.current = name
+	this.report(false)
+}
+
+// Add records that steps more steps have completed, inserting or reading rows rows
+func (this *Reporter) Add(steps int, rows int64) {
+	this.done += steps
+	this.rows += rows
+	this.report(false)
+}
+
+// Done clears the terminal status line, or logs the final state if periodic lines were logged
+func (this *Reporter) Done() {
+	if this.listener != nil {
+		status := this.status(time.Now())
+		status.Finished = true
+		this.listener(status)
+	}
+	if this.isTty {
+		if !this.lastDraw.IsZero() {
+			fmt.Fprint(this.out, "\r\033[K")
+		}
+		return
+	}
+	if this.isLogged {
+		this.report(true)
+	}
+}
+
+// report redraws/logs the current status if enough time has passed since the last one, or if force is set
+func (this *Reporter) report(force bool) {
+	now := time.Now()
+	if this.listener != nil && (force || now.Sub(this.lastNotify) >= redrawInterval) {
+		this.lastNotify = now
+		this.listener(this.status(now))
+	}
+
+	interval := logInterval
+	if this.isTty {
+		interval = redrawInterval
+	}
+	if !force && now.Sub(this.lastDraw) < interval {
+		return
+	}
+	// don't log a line for jobs that finish within the first interval
+	if !this.isTty && !force && now.Sub(this.start) < interval {
+		return
+	}
+	this.lastDraw = now
+
+	elapsed := now.Sub(this.start)
+	rowsPerSec := 0.0
+	if elapsed > 0 {
+		rowsPerSec = float64(this.rows) / elapsed.Seconds()
+	}
+	eta := this.eta(elapsed)
+
+	if !this.isTty {
+		this.isLogged = true
+		logging.FromContext(this.ctx).InfoContext(this.ctx, this.label, "current", this.current, this.unit, fmt.Sprintf("%d/%d", this.done, this.total),
+			"rows", this.rows, "rowsPerSec", fmt.Sprintf("%.0f", rowsPerSec), "eta", eta.Round(time.Second))
+		return
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("%s: %d/%d %s", this.label, this.done, this.total, this.unit))
+	if this.total > 0 {
+		sb.WriteString(fmt.Sprintf(" (%d%%)", this.done*100/this.total))
+	}
+	if this.rows > 0 {
+		sb.WriteString(fmt.Sprintf(", %.0f rows/s", rowsPerSec))
+	}
+	if this.done > 0 && this.done < this.total {
+		sb.WriteString(fmt.Sprintf(", ETA %s", eta.Round(time.Second)))
+	}
+	if this.current != "" {
+		sb.WriteString(fmt.Sprintf(" - %s", this.current))
+	}
+
+	line := sb.String()
+	if width, _, err := term.GetSize(int(this.out.(*os.File).Fd())); err == nil && width > 1 && len(line) >= width {
+		line = line[:width-1]
+	}
+	fmt.Fprintf(this.out, "\r\033[K%s", line)
+}
+
+// status returns a snapshot of the current progress
+func (this *Reporter) status(now time.Time) Status {
+	elapsed := now.Sub(this.start)
+	rowsPerSec := 0.0
+	if elapsed > 0 {
+		rowsPerSec = float64(this.rows) / elapsed.Seconds()
+	}
+	return Status{
+		Label:      this.label,
+		Unit:       this.unit,
+		Current:    this.current,
+		Done:       this.done,
+		Total:      this.total,
+		Rows:       this.rows,
+		RowsPerSec: rowsPerSec,
+		EtaMs:      this.eta(elapsed).Milliseconds(),
+	}
+}
+
+// eta estimates the time remaining from the average time per completed step
+func (this *Reporter) eta(elapsed time.Duration) time.Duration {
+	if this.done == 0 || this.done >= this.total {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(this.done) * float64(this.total-this.done))
+}
+
+// isTerminal returns true if out is a terminal that the status line can be redrawn on
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
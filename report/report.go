@@ -0,0 +1,298 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kodb-util/logging"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report is the machine-readable summary of a run written by -report
+type Report struct {
+	StartedAt  time.Time   `json:"startedAt"`
+	DurationMs int64       `json:"durationMs"`
+	Args       []string    `json:"args"`
+	Databases  []*Database `json:"databases"`
+	Warnings   []string    `json:"warnings,omitempty"` // warnings not tied to a database
+	Error      string      `json:"error,omitempty"`    // the error the run ended with, if any
+
+	mu sync.Mutex
+}
+
+// Database records the phases run against a single configured database
+type Database struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Phases     []*Phase  `json:"phases"`
+	Error      string    `json:"error,omitempty"`
+
+	report *Report
+}
+
+// Phase records a single unit of work (clean, import tables, export views, etc.); phases may contain sub-phases
+type Phase struct {
+	Name         string    `json:"name"`
+	StartedAt    time.Time `json:"startedAt"`
+	DurationMs   int64     `json:"durationMs"`
+	Skipped      string    `json:"skipped,omitempty"` // reason the phase wasn't run, e.g. a forbid flag
+	FilesRead    []string  `json:"filesRead,omitempty"`
+	FilesWritten []string  `json:"filesWritten,omitempty"`
+	Rows         int64     `json:"rows,omitempty"`
+	Warnings     []string  `json:"warnings,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Phases       []*Phase  `json:"phases,omitempty"`
+
+	report *Report
+}
+
+type ctxKey struct{}
+
+type writesKey struct{}
+
+// Writes collects the paths passed to FileWritten with a context returned by WithWrites, whether or not there's a
+// report
+type Writes struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// secretArgs are the CLI flags whose values are never written to the report
+var secretArgs = []string{"dbpass"}
+
+// New starts a report for this run
+func New() *Report {
+	return &Report{
+		StartedAt: time.Now(),
+		Args:      redactArgs(os.Args[1:]),
+		Databases: []*Database{},
+	}
+}
+
+// WithReport returns a context that StartDatabase uses to add databases to this report.  A nil report returns ctx
+// unchanged, which turns every other function in this package into a no-op.
+func (this *Report) WithReport(ctx context.Context) context.Context {
+	if this == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, this)
+}
+
+// Finish records the final error and total duration
+func (this *Report) Finish(err error) {
+	if this == nil {
+		return
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.DurationMs = time.Since(this.StartedAt).Milliseconds()
+	if err != nil {
+		this.Error = err.Error()
+	}
+}
+
+// WriteFile writes the report as indented JSON to path
+func (this *Report) WriteFile(path string) error {
+	if this == nil {
+		return nil
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	reportBytes, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(reportBytes, '\n'), 0644)
+}
+
+// StartDatabase adds a database to the report in ctx and returns a context that phases are recorded under
+func StartDatabase(ctx context.Context, name string, dbType string) (context.Context, *Database) {
+	rpt, ok := ctx.Value(ctxKey{}).(*Report)
+	if !ok {
+		return ctx, nil
+	}
+	db := &Database{
+		Name:      name,
+		Type:      dbType,
+		StartedAt: time.Now(),
+		Phases:    []*Phase{},
+		report:    rpt,
+	}
+	rpt.mu.Lock()
+	rpt.Databases = append(rpt.Databases, db)
+	rpt.mu.Unlock()
+	return context.WithValue(ctx, ctxKey{}, db), db
+}
+
+// End records the database's duration and error
+func (this *Database) End(err error) {
+	if this == nil {
+		return
+	}
+	this.report.mu.Lock()
+	defer this.report.mu.Unlock()
+	this.DurationMs = time.Since(this.StartedAt).Milliseconds()
+	if err != nil {
+		this.Error = err.Error()
+	}
+}
+
+// StartPhase adds a phase under the database or phase in ctx and returns a context that files, rows, warnings, and
+// sub-phases are recorded under.  Call End on the returned phase when it's done.
+func StartPhase(ctx context.Context, name string) (context.Context, *Phase) {
+	phase := addPhase(ctx, name)
+	if phase == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, ctxKey{}, phase), phase
+}
+
+// Skip records a phase that wasn't run and why
+func Skip(ctx context.Context, name string, reason string) {
+	phase := addPhase(ctx, name)
+	if phase == nil {
+		return
+	}
+	phase.report.mu.Lock()
+	defer phase.report.mu.Unlock()
+	phase.Skipped = reason
+}
+
+// End records the phase's duration and error
+func (this *Phase) End(err error) {
+	if this == nil {
+		return
+	}
+	this.report.mu.Lock()
+	defer this.report.mu.Unlock()
+	this.DurationMs = time.Since(this.StartedAt).Milliseconds()
+	if err != nil {
+		this.Error = err.Error()
+	}
+}
+
+// FileRead records that path was read by the phase in ctx
+func FileRead(ctx context.Context, path string) {
+	if phase, ok := ctx.Value(ctxKey{}).(*Phase); ok {
+		phase.report.mu.Lock()
+		defer phase.report.mu.Unlock()
+		phase.FilesRead = append(phase.FilesRead, path)
+	}
+}
+
+// WithWrites returns a context whose FileWritten calls are also collected in the returned Writes, e.g. to check what
+// a job wrote
+func WithWrites(ctx context.Context) (context.Context, *Writes) {
+	writes := &Writes{}
+	return context.WithValue(ctx, writesKey{}, writes), writes
+}
+
+// Paths returns the paths written so far
+func (this *Writes) Paths() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return slices.Clone(this.paths)
+}
+
+// FileWritten records that path was written by the phase in ctx
+func FileWritten(ctx context.Context, path string) {
+	if writes, ok := ctx.Value(writesKey{}).(*Writes); ok {
+		writes.mu.Lock()
+		writes.paths = append(writes.paths, path)
+		writes.mu.Unlock()
+	}
+	if phase, ok := ctx.Value(ctxKey{}).(*Phase); ok {
+		phase.report.mu.Lock()
+		defer phase.report.mu.Unlock()
+		phase.FilesWritten = append(phase.FilesWritten, path)
+	}
+}
+
+// AddRows adds rows to the row count of the phase in ctx
+func AddRows(ctx context.Context, rows int64) {
+	if phase, ok := ctx.Value(ctxKey{}).(*Phase); ok {
+		phase.report.mu.Lock()
+		defer phase.report.mu.Unlock()
+		phase.Rows += rows
+	}
+}
+
+// Warn logs a warning and records it against the phase, database, or report in ctx.  args are slog key/value pairs.
+func Warn(ctx context.Context, msg string, args ...any) {
+	logging.FromContext(ctx).WarnContext(ctx, msg, args...)
+
+	warning := formatWarning(msg, args...)
+	switch node := ctx.Value(ctxKey{}).(type) {
+	case *Phase:
+		node.report.mu.Lock()
+		defer node.report.mu.Unlock()
+		node.Warnings = append(node.Warnings, warning)
+	case *Database:
+		// databases only hold phases; keep the warning at the report level
+		node.report.mu.Lock()
+		defer node.report.mu.Unlock()
+		node.report.Warnings = append(node.report.Warnings, fmt.Sprintf("%s: %s", node.Name, warning))
+	case *Report:
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		node.Warnings = append(node.Warnings, warning)
+	}
+}
+
+// addPhase creates a phase under the database or phase in ctx; returns nil if there isn't one
+func addPhase(ctx context.Context, name string) *Phase {
+	phase := &Phase{
+		Name:      name,
+		StartedAt: time.Now(),
+	}
+	switch parent := ctx.Value(ctxKey{}).(type) {
+	case *Database:
+		phase.report = parent.report
+		parent.report.mu.Lock()
+		defer parent.report.mu.Unlock()
+		parent.Phases = append(parent.Phases, phase)
+	case *Phase:
+		phase.report = parent.report
+		parent.report.mu.Lock()
+		defer parent.report.mu.Unlock()
+		parent.Phases = append(parent.Phases, phase)
+	default:
+		return nil
+	}
+	return phase
+}
+
+// formatWarning renders msg and its slog key/value pairs as a single line, e.g. "removing column table=ITEM column=Foo"
+func formatWarning(msg string, args ...any) string {
+	sb := strings.Builder{}
+	sb.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		sb.WriteString(fmt.Sprintf(" %v=%v", args[i], args[i+1]))
+	}
+	return sb.String()
+}
+
+// redactArgs returns a copy of args with the values of secretArgs replaced
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i < len(redacted); i++ {
+		name, _, hasVal := strings.Cut(strings.TrimLeft(redacted[i], "-"), "=")
+		if !strings.HasPrefix(redacted[i], "-") || !slices.Contains(secretArgs, name) {
+			continue
+		}
+		if hasVal {
+			redacted[i] = redacted[i][:strings.Index(redacted[i], "=")+1] + "***"
+		} else if i+1 < len(redacted) {
+			i++
+			redacted[i] = "***"
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,33 @@
+package report
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "no secrets", args: []string{"-config", "a.yaml", "import"}, want: []string{"-config", "a.yaml", "import"}},
+		{name: "separate value", args: []string{"-dbpass", "secret", "import"}, want: []string{"-dbpass", "***", "import"}},
+		{name: "joined value", args: []string{"--dbpass=secret", "clean"}, want: []string{"--dbpass=***", "clean"}},
+		{name: "prompt", args: []string{"-dbpass=-"}, want: []string{"-dbpass=***"}},
+		{name: "flag last", args: []string{"import", "-dbpass"}, want: []string{"import", "-dbpass"}},
+		{name: "positional named like the flag", args: []string{"export", "dbpass"}, want: []string{"export", "dbpass"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := slices.Clone(test.args)
+			got := redactArgs(args)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("redactArgs(%v) = %v, want %v", test.args, got, test.want)
+			}
+			if !slices.Equal(args, test.args) {
+				t.Errorf("redactArgs modified its input: %v", args)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package safety
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"golang.org/x/term"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/mssql"
+	"os"
+	"path"
+	"strings"
+)
+
+// the safety package guards the jobs that drop or replace data against being run on the wrong server: a host
+// allowlist/denylist, an interactive confirmation, and a limit on the player data a database can hold
+
+// 1. database name
+const guardedRowsSqlFmt = `SELECT t.[name] AS [table], SUM(p.[rows]) AS [rows]
+FROM [%[1]s].[sys].[tables] t
+INNER JOIN [%[1]s].[sys].[partitions] p ON p.[object_id] = t.[object_id]
+WHERE p.[index_id] IN (0, 1) AND t.[name] IN ?
+GROUP BY t.[name]`
+
+// ServerName returns how the configured server is shown to the user, e.g. localhost\SQLEXPRESS
+func ServerName(dbConf config.DatabaseConfig) string {
+	if dbConf.Instance != "" {
+		return dbConf.Host + `\` + dbConf.Instance
+	}
+	return fmt.Sprintf("%s:%d", dbConf.Host, dbConf.Port)
+}
+
+// CheckHost returns an error if destructive jobs aren't allowed against the configured server by the safety
+// allowedHosts and deniedHosts
+func CheckHost(conf *config.KodbConfig) error {
+	dbConf := conf.DatabaseConfig
+	names := []string{strings.ToLower(dbConf.Host)}
+	if dbConf.Instance != "" {
+		names = append(names, strings.ToLower(dbConf.Host+`\`+dbConf.Instance))
+	}
+
+	if pattern, ok := matchHost(conf.Safety.DeniedHosts, names); ok {
+		return &errs.SafetyError{Host: ServerName(dbConf), Err: fmt.Errorf("host matches safety.deniedHosts entry %s", pattern)}
+	}
+	if len(conf.Safety.AllowedHosts) > 0 {
+		if _, ok := matchHost(conf.Safety.AllowedHosts, names); !ok {
+			return &errs.SafetyError{Host: ServerName(dbConf), Err: fmt.Errorf("host is not listed in safety.allowedHosts")}
+		}
+	}
+	return nil
+}
+
+// matchHost returns the first pattern that matches any of names
+func matchHost(patterns []string, names []string) (string, bool) {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			// patterns are validated with the config, so errors can't happen here
+			if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Confirm asks the user to confirm that jobs may run against dbNames on the configured server.  Returns an error if
+// the user doesn't type yes, or if stdin isn't a terminal; use -yes to confirm non-interactively.
+func Confirm(conf *config.KodbConfig, jobs []string, dbNames []string) error {
+	server := ServerName(conf.DatabaseConfig)
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return &errs.SafetyError{Host: server, Err: fmt.Errorf("%s must be confirmed; stdin isn't a terminal, use -yes to confirm", strings.Join(jobs, ", "))}
+	}
+
+	fmt.Printf("About to %s on host %s, instance %s:\n", strings.Join(jobs, ", "), conf.DatabaseConfig.Host, instanceName(conf.DatabaseConfig))
+	for _, dbName := range dbNames {
+		fmt.Printf("  %s\n", dbName)
+	}
+	fmt.Print("Type yes to continue: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return &errs.SafetyError{Host: server, Err: fmt.Errorf("failed to read confirmation: %v", err)}
+	}
+	if !strings.EqualFold(strings.TrimSpace(line), "yes") {
+		return &errs.SafetyError{Host: server, Err: fmt.Errorf("not confirmed")}
+	}
+	return nil
+}
+
+// instanceName returns the configured instance, or the default instance's name
+func instanceName(dbConf config.DatabaseConfig) string {
+	if dbConf.Instance == "" {
+		return "MSSQLSERVER"
+	}
+	return dbConf.Instance
+}
+
+// CheckGuardedRows returns an error if the driver's database holds more rows in the safety guardedTables than
+// maxGuardedRows allows, unless driver.Options.Force is set.  job is the job about to drop or replace the data.  If tables is given,
+// only the guarded tables among them are counted, e.g. the tables whose data is about to be replaced.
+func CheckGuardedRows(ctx context.Context, driver *mssql.MssqlDbDriver, job string, tables ...string) error {
+	conf := driver.Config
+	maxRows := int64(conf.Safety.MaxGuardedRows)
+	if maxRows < 0 {
+		return nil
+	}
+	if maxRows == 0 {
+		maxRows = config.DefaultMaxGuardedRows
+	}
+	guardedTables := conf.Safety.GuardedTables
+	if len(guardedTables) == 0 {
+		guardedTables = config.DefaultGuardedTables
+	}
+	if len(tables) > 0 {
+		filtered := []string{}
+		for _, table := range guardedTables {
+			for _, name := range tables {
+				if strings.EqualFold(table, name) {
+					filtered = append(filtered, table)
+					break
+				}
+			}
+		}
+		if len(filtered) == 0 {
+			return nil
+		}
+		guardedTables = filtered
+	}
+
+	conn, err := driver.GetMasterConnection()
+	if err != nil {
+		return err
+	}
+	conn = conn.WithContext(ctx)
+	dbName := driver.GenDbConfig.Name
+	exists, err := mssql.DatabaseExists(ctx, conn, dbName)
+	if err != nil || !exists {
+		return err
+	}
+
+	counts := []struct {
+		Table string
+		Rows  int64
+	}{}
+	err = conn.Raw(fmt.Sprintf(guardedRowsSqlFmt, dbName), guardedTables).Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	total := int64(0)
+	found := []string{}
+	for _, count := range counts {
+		total += count.Rows
+		if count.Rows > 0 {
+			found = append(found, fmt.Sprintf("%s=%d", count.Table, count.Rows))
+		}
+	}
+	if total <= maxRows {
+		return nil
+	}
+	if driver.Options.Force {
+		logging.FromContext(ctx).WarnContext(ctx, "database holds player data; continuing because of -force", "db", dbName, "job", job, "rows", strings.Join(found, " "))
+		return nil
+	}
+	return &errs.SafetyError{
+		Host:     ServerName(conf.DatabaseConfig),
+		Database: dbName,
+		Err:      fmt.Errorf("%s would remove %d rows of player data (%s), more than safety.maxGuardedRows %d; use -force if this is intended", job, total, strings.Join(found, ", "), maxRows),
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kodb-util/progress"
+	"kodb-util/report"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// maxJobEvents is the number of events kept per job for clients that connect late or reconnect
+	maxJobEvents = 5000
+
+	// subscriberBuffer is the number of events queued per SSE client; slower clients are disconnected and can
+	// resume with Last-Event-ID
+	subscriberBuffer = 256
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// event types sent to SSE clients
+const (
+	EventLog      = "log"
+	EventProgress = "progress"
+	EventDone     = "done"
+)
+
+// JobInfo is the state of a job returned by the API
+type JobInfo struct {
+	Id        string         `json:"id"`
+	Db        string         `json:"db"`
+	Job       string         `json:"job"` // e.g. "import" or "export data"
+	State     JobState       `json:"state"`
+	CreatedAt time.Time      `json:"createdAt"`
+	StartedAt *time.Time     `json:"startedAt,omitempty"`
+	EndedAt   *time.Time     `json:"endedAt,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	ExitCode  int            `json:"exitCode"`
+	Report    *report.Report `json:"report,omitempty"` // set once the job has ended
+}
+
+// Job is a single request to run a job against a database
+type Job struct {
+	info        JobInfo
+	mu          sync.Mutex
+	events      []Event
+	nextEventId int
+	subscribers map[chan Event]struct{}
+	cancel      context.CancelCauseFunc
+	done        chan struct{}
+}
+
+// Event is a log record, progress update, or the final state of a job
+type Event struct {
+	Id   int    `json:"id"`
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+func newJob(id string, db string, name string) *Job {
+	return &Job{
+		info: JobInfo{
+			Id:        id,
+			Db:        db,
+			Job:       name,
+			State:     JobQueued,
+			CreatedAt: time.Now(),
+		},
+		nextEventId: 1,
+		subscribers: map[chan Event]struct{}{},
+		done:        make(chan struct{}),
+	}
+}
+
+// snapshot returns a copy of the job's state that is safe to encode while the job runs
+func (this *Job) snapshot() JobInfo {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.info
+}
+
+// isActive returns true if the job is queued or running
+func (this *Job) isActive() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.info.State == JobQueued || this.info.State == JobRunning
+}
+
+// start marks the job as running
+func (this *Job) start() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	now := time.Now()
+	this.info.State = JobRunning
+	this.info.StartedAt = &now
+}
+
+// end records the outcome of the job, sends the done event, and disconnects every subscriber
+func (this *Job) end(ctx context.Context, err error, exitCode int, rpt *report.Report) {
+	this.mu.Lock()
+	now := time.Now()
+	this.info.EndedAt = &now
+	this.info.ExitCode = exitCode
+	this.info.Report = rpt
+	switch {
+	case err == nil:
+		this.info.State = JobSucceeded
+	case errors.Is(ctx.Err(), context.Canceled):
+		// cancelled through the API or by the server shutting down
+		this.info.State = JobCancelled
+	default:
+		this.info.State = JobFailed
+	}
+	if err != nil {
+		this.info.Error = err.Error()
+	}
+	this.mu.Unlock()
+
+	snapshot := this.snapshot()
+	snapshot.Report = nil
+	this.publish(EventDone, snapshot)
+
+	this.mu.Lock()
+	for sub := range this.subscribers {
+		close(sub)
+	}
+	this.subscribers = nil
+	this.mu.Unlock()
+	close(this.done)
+}
+
+// publish records an event and sends it to every subscriber.  Subscribers that have fallen too far behind are
+// disconnected rather than blocking the job.
+func (this *Job) publish(eventType string, data any) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	event := Event{Id: this.nextEventId, Type: eventType, Data: data}
+	this.nextEventId++
+	this.events = append(this.events, event)
+	if len(this.events) > maxJobEvents {
+		this.events = this.events[len(this.events)-maxJobEvents:]
+	}
+	for sub := range this.subscribers {
+		select {
+		case sub <- event:
+		default:
+			close(sub)
+			delete(this.subscribers, sub)
+		}
+	}
+}
+
+// subscribe returns the events after lastEventId and a channel that receives new events until the job ends.  The
+// channel is nil if the job has already ended.  Call unsubscribe when done reading.
+func (this *Job) subscribe(lastEventId int) (history []Event, events chan Event, unsubscribe func()) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for i := range this.events {
+		if this.events[i].Id > lastEventId {
+			history = append(history, this.events[i])
+		}
+	}
+	if this.subscribers == nil {
+		return history, nil, func() {}
+	}
+	events = make(chan Event, subscriberBuffer)
+	this.subscribers[events] = struct{}{}
+	return history, events, func() {
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		if _, ok := this.subscribers[events]; ok {
+			delete(this.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// logListener publishes the job's log records as events
+func (this *Job) logListener(_ context.Context, record slog.Record) {
+	data := map[string]any{
+		"time":  record.Time,
+		"level": record.Level.String(),
+		"msg":   record.Message,
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		data[attr.Key] = attrValue(attr.Value)
+		return true
+	})
+	this.publish(EventLog, data)
+}
+
+// progressListener publishes the job's progress updates as events
+func (this *Job) progressListener(status progress.Status) {
+	this.publish(EventProgress, status)
+}
+
+// attrValue converts a log attribute to a value that encodes as readable JSON
+func attrValue(value slog.Value) any {
+	value = value.Resolve()
+	switch value.Kind() {
+	case slog.KindDuration:
+		return value.Duration().String()
+	case slog.KindGroup:
+		group := map[string]any{}
+		for _, attr := range value.Group() {
+			group[attr.Key] = attrValue(attr.Value)
+		}
+		return group
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return err.Error()
+		}
+		if stringer, ok := value.Any().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+	return value.Any()
+}
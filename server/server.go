@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Open-KO/kodb-godef/enums/dbType"
+	"kodb-util/arg"
+	"kodb-util/config"
+	"kodb-util/errs"
+	"kodb-util/logging"
+	"kodb-util/progress"
+	"kodb-util/report"
+	"kodb-util/safety"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the server package exposes the database jobs as a localhost HTTP API for launchers and dashboards:
+//
+//	GET    /dbs                               configured databases, their forbid flags, and running job
+//	POST   /dbs/{db}/import                   clean, then import OpenKO-db
+//	POST   /dbs/{db}/import/{kind}            replace views, procs, or data (?tables=A,B) without cleaning
+//	POST   /dbs/{db}/clean                    drop the database and logins
+//	POST   /dbs/{db}/export/{kind}            data, structure, views, procs, jsonschema, security, or all
+//	POST   /dbs/{db}/diff/{mode}              models or roundtrip
+//	POST   /dbs/{db}/snapshots/{name}         create a database snapshot
+//	POST   /dbs/{db}/snapshots/{name}/revert  revert the database to the snapshot
+//	DELETE /dbs/{db}/snapshots/{name}         drop the snapshot
+//	GET    /jobs                              every job started since the server started
+//	GET    /jobs/{id}                         job state; includes the run report once the job has ended
+//	DELETE /jobs/{id}                         cancel a job; its transaction is rolled back
+//	GET    /jobs/{id}/events                  Server-Sent Events: log, progress, and done
+//
+// Starting a job returns 202 with the job and its Location.  A job for a database that already has one queued or
+// running is refused with 409, and a job that the database's isForbid* flags don't allow is refused with 403.  Jobs
+// that drop or replace data are refused with 403 unless the server was started with -yes.
+
+// shutdownTimeout limits how long the server waits for requests to finish once the run is cancelled
+const shutdownTimeout = 5 * time.Second
+
+// errJobCancelled is the cancel cause of jobs cancelled through the API
+var errJobCancelled = errors.New("job cancelled")
+
+// Database is a configured database that jobs can be run against
+type Database struct {
+	Type   dbType.DbType
+	Config config.GenDbConfig
+}
+
+// ProcessFunc runs the jobs requested in args against db, i.e. kodb-util's processDb
+type ProcessFunc func(ctx context.Context, db Database, args arg.Args) error
+
+// Server runs the jobs requested over HTTP
+type Server struct {
+	conf    *config.KodbConfig
+	args    arg.Args // global options (timeouts, etc.) applied to every job
+	dbs     []Database
+	process ProcessFunc
+
+	ctx     context.Context // cancelled when the server shuts down; every job is cancelled with it
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	jobIds  []string        // job ids in the order they were created
+	running map[string]*Job // queued or running job by database name
+	wg      sync.WaitGroup
+}
+
+// New creates a server for the dbs of conf.  args supplies the options that aren't set per request, e.g. -phaseTimeout.
+func New(conf *config.KodbConfig, args arg.Args, dbs []Database, process ProcessFunc) *Server {
+	return &Server{
+		conf:    conf,
+		args:    args,
+		dbs:     dbs,
+		process: process,
+		ctx:     context.Background(),
+		jobs:    map[string]*Job{},
+		running: map[string]*Job{},
+	}
+}
+
+// ListenAndServe serves the API on addr until ctx is cancelled, then cancels any jobs and waits for their
+// transactions to be rolled back
+func (this *Server) ListenAndServe(ctx context.Context, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &errs.ArgsError{Err: err}
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+
+	this.ctx = ctx
+	httpServer := &http.Server{
+		Handler:     this.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+	slog.Info("serving jobs", "addr", listener.Addr().String())
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+		err = httpServer.Shutdown(shutdownCtx)
+	}
+	this.wg.Wait()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// Handler returns the API's routes; requests that don't come from localhost are refused
+func (this *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dbs", this.handleDbs)
+	mux.HandleFunc("POST /dbs/{db}/import", this.handleStart(func(a *arg.Args, _ *http.Request) (string, error) {
+		a.Import = true
+		return "import", nil
+	}))
+	mux.HandleFunc("POST /dbs/{db}/import/{kind}", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		switch strings.ToLower(r.PathValue("kind")) {
+		case "views":
+			a.ImportViews = true
+		case "procs":
+			a.ImportProcs = true
+		case "data":
+			a.ImportData = true
+			for _, table := range strings.Split(r.URL.Query().Get("tables"), ",") {
+				if table = strings.TrimSpace(table); table != "" {
+					a.ImportTables = append(a.ImportTables, table)
+				}
+			}
+		default:
+			return "", fmt.Errorf("unknown import kind %s, expected views, procs, or data", r.PathValue("kind"))
+		}
+		return "import " + r.PathValue("kind"), nil
+	}))
+	mux.HandleFunc("POST /dbs/{db}/clean", this.handleStart(func(a *arg.Args, _ *http.Request) (string, error) {
+		a.Clean = true
+		return "clean", nil
+	}))
+	mux.HandleFunc("POST /dbs/{db}/export/{kind}", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		return "export " + r.PathValue("kind"), arg.SetExportKind(a, r.PathValue("kind"))
+	}))
+	mux.HandleFunc("POST /dbs/{db}/diff/{mode}", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		return "diff " + r.PathValue("mode"), arg.SetDiffMode(a, r.PathValue("mode"))
+	}))
+	mux.HandleFunc("POST /dbs/{db}/snapshots/{name}", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		a.Snapshot = r.PathValue("name")
+		return "create snapshot " + a.Snapshot, arg.ValidateSnapshotName(a.Snapshot)
+	}))
+	mux.HandleFunc("POST /dbs/{db}/snapshots/{name}/revert", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		a.Revert = r.PathValue("name")
+		return "revert snapshot " + a.Revert, arg.ValidateSnapshotName(a.Revert)
+	}))
+	mux.HandleFunc("DELETE /dbs/{db}/snapshots/{name}", this.handleStart(func(a *arg.Args, r *http.Request) (string, error) {
+		a.DropSnapshot = r.PathValue("name")
+		return "drop snapshot " + a.DropSnapshot, arg.ValidateSnapshotName(a.DropSnapshot)
+	}))
+	mux.HandleFunc("GET /jobs", this.handleJobs)
+	mux.HandleFunc("GET /jobs/{id}", this.handleJob)
+	mux.HandleFunc("DELETE /jobs/{id}", this.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/events", this.handleEvents)
+	return localOnly(mux)
+}
+
+// localOnly refuses requests whose Host or Origin isn't localhost.  Binding to the loopback interface keeps other
+// machines out; these checks stop web pages from reaching the API through the browser (DNS rebinding, cross-site
+// form posts).
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %s is not allowed", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			originUrl, err := url.Parse(origin)
+			if err != nil || !isLocalHost(originUrl.Host) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("origin %s is not allowed", origin))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLocalHost returns true if hostPort names the local machine
+func isLocalHost(hostPort string) bool {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type dbResponse struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	IsForbidClean  bool   `json:"isForbidClean"`
+	IsForbidImport bool   `json:"isForbidImport"`
+	IsForbidExport bool   `json:"isForbidExport"`
+	JobId          string `json:"jobId,omitempty"` // queued or running job, if any
+}
+
+func (this *Server) handleDbs(w http.ResponseWriter, _ *http.Request) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	resp := []dbResponse{}
+	for i := range this.dbs {
+		db := dbResponse{
+			Name:           this.dbs[i].Config.Name,
+			Type:           string(this.dbs[i].Type),
+			IsForbidClean:  this.dbs[i].Config.IsForbidClean,
+			IsForbidImport: this.dbs[i].Config.IsForbidImport,
+			IsForbidExport: this.dbs[i].Config.IsForbidExport,
+		}
+		if job, ok := this.running[db.Name]; ok {
+			db.JobId = job.info.Id
+		}
+		resp = append(resp, db)
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+// handleStart returns a handler that starts the job that setJob enables in the job's args.  setJob returns the job's
+// display name.
+func (this *Server) handleStart(setJob func(a *arg.Args, r *http.Request) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		db, ok := this.findDb(r.PathValue("db"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("database %s is not configured", r.PathValue("db")))
+			return
+		}
+
+		jobArgs := arg.Args{
+			ImportBatchSize: this.args.ImportBatchSize,
+			PhaseTimeout:    this.args.PhaseTimeout,
+		}
+		name, err := setJob(&jobArgs, r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// fail early with the same checks processDb makes, rather than recording a skipped phase
+		if reason := jobArgs.ForbidReason(db.Config); reason != "" {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s is forbidden for database %s by %s", name, db.Config.Name, reason))
+			return
+		}
+		if len(jobArgs.DestructiveJobs()) > 0 {
+			// there's no one to ask for confirmation, so starting the server with -yes is the confirmation
+			if !this.args.Yes {
+				writeError(w, http.StatusForbidden, fmt.Errorf("%s drops or replaces data; start the server with -yes to allow it", name))
+				return
+			}
+			if err = safety.CheckHost(this.conf); err != nil {
+				writeError(w, http.StatusForbidden, err)
+				return
+			}
+		}
+
+		job, err := this.start(db, name, jobArgs)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		w.Header().Set("Location", "/jobs/"+job.info.Id)
+		writeJson(w, http.StatusAccepted, job.snapshot())
+	}
+}
+
+func (this *Server) findDb(name string) (Database, bool) {
+	for i := range this.dbs {
+		if this.dbs[i].Config.Name == name {
+			return this.dbs[i], true
+		}
+	}
+	return Database{}, false
+}
+
+// start queues a job against db, refusing it if db already has a queued or running job
+func (this *Server) start(db Database, name string, jobArgs arg.Args) (*Job, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if running, ok := this.running[db.Config.Name]; ok {
+		info := running.snapshot()
+		return nil, fmt.Errorf("database %s already has a %s %s job: %s", db.Config.Name, info.State, info.Job, info.Id)
+	}
+
+	job := newJob(rand.Text(), db.Config.Name, name)
+	this.jobs[job.info.Id] = job
+	this.jobIds = append(this.jobIds, job.info.Id)
+	this.running[db.Config.Name] = job
+
+	var ctx context.Context
+	ctx, job.cancel = context.WithCancelCause(this.ctx)
+	this.wg.Add(1)
+	go this.run(ctx, job, db, jobArgs)
+	return job, nil
+}
+
+// run runs the job and records the outcome.  Jobs against different databases run at the same time, except that
+// kodb.RunDb waits for mssql.UseModels while another job is using the OpenKO-gorm models.
+func (this *Server) run(ctx context.Context, job *Job, db Database, jobArgs arg.Args) {
+	defer this.wg.Done()
+	defer job.cancel(nil)
+
+	rpt := report.New()
+	ctx = rpt.WithReport(ctx)
+	ctx = logging.WithListener(ctx, job.logListener)
+	ctx = progress.WithListener(ctx, job.progressListener)
+
+	if this.args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.args.Timeout)
+		defer cancel()
+	}
+	job.start()
+	logging.FromContext(ctx).InfoContext(ctx, "job started", "job", job.info.Job, "id", job.info.Id, "db", db.Config.Name)
+	err := this.runProcess(ctx, db, jobArgs)
+
+	exitCode := errs.ExitCode(err)
+	if err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "job failed", "job", job.info.Job, "id", job.info.Id, "db", db.Config.Name, "error", err, "exitCode", exitCode)
+	} else {
+		logging.FromContext(ctx).InfoContext(ctx, "job succeeded", "job", job.info.Job, "id", job.info.Id, "db", db.Config.Name)
+	}
+	rpt.Finish(err)
+
+	this.mu.Lock()
+	delete(this.running, db.Config.Name)
+	this.mu.Unlock()
+	job.end(ctx, err, exitCode, rpt)
+}
+
+// runProcess runs the job, turning a panic into an error so that it fails the job instead of the server
+func (this *Server) runProcess(ctx context.Context, db Database, jobArgs arg.Args) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return this.process(ctx, db, jobArgs)
+}
+
+func (this *Server) findJob(id string) (*Job, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	job, ok := this.jobs[id]
+	return job, ok
+}
+
+func (this *Server) handleJobs(w http.ResponseWriter, _ *http.Request) {
+	this.mu.Lock()
+	jobs := make([]*Job, 0, len(this.jobIds))
+	for i := range this.jobIds {
+		jobs = append(jobs, this.jobs[this.jobIds[i]])
+	}
+	this.mu.Unlock()
+
+	resp := make([]JobInfo, 0, len(jobs))
+	for i := range jobs {
+		info := jobs[i].snapshot()
+		info.Report = nil
+		resp = append(resp, info)
+	}
+	writeJson(w, http.StatusOK, resp)
+}
+
+func (this *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := this.findJob(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		return
+	}
+	writeJson(w, http.StatusOK, job.snapshot())
+}
+
+func (this *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := this.findJob(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		return
+	}
+	if !job.isActive() {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s has already ended", job.info.Id))
+		return
+	}
+	job.cancel(errJobCancelled)
+	<-job.done
+	writeJson(w, http.StatusOK, job.snapshot())
+}
+
+// handleEvents streams the job's events as Server-Sent Events, starting after the Last-Event-ID header when a client
+// reconnects.  The stream ends after the done event.
+func (this *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := this.findJob(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	lastEventId, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	history, events, unsubscribe := job.subscribe(lastEventId)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for i := range history {
+		if writeEvent(w, history[i]) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	if events == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if writeEvent(w, event) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(body); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}